@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/kube"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+const backupCheckInterval = 10 * time.Minute
+
+func backupObjectName(b *types.Backup) string {
+	return "backups/" + b.ProjectID.KubeName() + "/" + b.ID + ".tar.gz"
+}
+
+func takeBackup(ctx *hzhttp.Context, p *types.Project) (*types.Backup, error) {
+	b := &types.Backup{
+		ID:        uuid.New(),
+		ProjectID: p.ID,
+		Mode:      p.BackupConfig.Mode,
+		Created:   time.Now(),
+	}
+
+	switch b.Mode {
+	case types.BackupSnapshot:
+		snapshot, err := ctx.Kube.SnapshotRDB(p.KubeName())
+		if err != nil {
+			return nil, err
+		}
+		b.Location = snapshot
+	case types.BackupDump:
+		b.Location = backupObjectName(b)
+		w := ctx.Objects.NewWriter(
+			storageBucket, b.Location, "application/gzip")
+		err := ctx.Kube.DumpRDB(p.KubeName(), w)
+		if err != nil {
+			w.CloseWithError(err)
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown backup mode %#v", b.Mode)
+	}
+
+	err := ctx.DB().AddBackup(b)
+	if err != nil {
+		if err := deleteBackupData(ctx, b); err != nil {
+			ctx.Error("Couldn't clean up unrecorded backup %v: %v", b.Location, err)
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func deleteBackupData(ctx *hzhttp.Context, b *types.Backup) error {
+	switch b.Mode {
+	case types.BackupSnapshot:
+		return ctx.Provider.DeleteSnapshot(b.Location)
+	case types.BackupDump:
+		return ctx.Objects.Delete(storageBucket, b.Location)
+	}
+	return fmt.Errorf("unknown backup mode %#v", b.Mode)
+}
+
+func deleteBackup(ctx *hzhttp.Context, b *types.Backup) error {
+	err := deleteBackupData(ctx, b)
+	if err != nil {
+		return err
+	}
+	return ctx.DB().DeleteBackup(b.ID)
+}
+
+func maybeBackupProject(ctx *hzhttp.Context, p *types.Project) {
+	ctx = ctx.WithLog(map[string]interface{}{"project": p.ID})
+
+	backups, err := ctx.DB().GetBackups(p.ID)
+	if err != nil {
+		ctx.Error("Couldn't get backups: %v", err)
+		return
+	}
+	if len(backups) > 0 && time.Since(backups[0].Created) < p.BackupConfig.Interval() {
+		return
+	}
+
+	ctx.Info("backing up project (%v)", p.BackupConfig.Mode)
+	b, err := takeBackup(ctx, p)
+	if err != nil {
+		ctx.Error("Couldn't back up project: %v", err)
+		return
+	}
+	ctx.Info("created backup %v at %v", b.ID, b.Location)
+
+	backups = append([]*types.Backup{b}, backups...)
+	for len(backups) > p.BackupConfig.Retain {
+		old := backups[len(backups)-1]
+		ctx.Info("deleting expired backup %v", old.ID)
+		err := deleteBackup(ctx, old)
+		if err != nil {
+			ctx.Error("Couldn't delete backup %v: %v", old.ID, err)
+			return
+		}
+		backups = backups[:len(backups)-1]
+	}
+}
+
+func backupLoop(ctx *hzhttp.Context) {
+	ctx = ctx.WithLog(map[string]interface{}{"action": "backupLoop"})
+	for {
+		projects, err := ctx.DB().GetProjectsWithBackups()
+		if err != nil {
+			ctx.Error("Couldn't get projects to back up: %v", err)
+		}
+		for _, p := range projects {
+			if p.Deleting {
+				continue
+			}
+			if !startWork() {
+				return
+			}
+			maybeBackupProject(ctx, p)
+			workers.Done()
+		}
+		if !sleep(backupCheckInterval) {
+			return
+		}
+	}
+}
+
+func applyRestore(
+	// Errors returned from this are shown to users.
+	k *kube.Kube, ctx *hzhttp.Context, conf *types.Project) error {
+	ctx.Info("Restoring backup %v", conf.RestoreBackupID)
+
+	b, err := ctx.DB().GetBackup(conf.RestoreBackupID)
+	if err != nil {
+		ctx.Error("%v", err)
+		return fmt.Errorf("unable to find backup %v", conf.RestoreBackupID)
+	}
+	if b.ProjectID != conf.ID {
+		ctx.UserError("backup %v belongs to %v", b.ID, b.ProjectID)
+		return fmt.Errorf("backup %v does not belong to this project", b.ID)
+	}
+
+	switch b.Mode {
+	case types.BackupSnapshot:
+		err = k.RestoreRDBFromSnapshot(conf.KubeName(), conf.KubeConfig, b.Location)
+	case types.BackupDump:
+		err = restoreDump(k, ctx, conf, b)
+	default:
+		err = fmt.Errorf("unknown backup mode %#v", b.Mode)
+	}
+	if err != nil {
+		ctx.Error("%v", err)
+		if cerr, ok := err.(*kube.ConfigError); ok {
+			return cerr
+		}
+		return fmt.Errorf("error restoring backup %v", b.ID)
+	}
+	return nil
+}
+
+func restoreDump(
+	k *kube.Kube, ctx *hzhttp.Context, conf *types.Project, b *types.Backup) error {
+	rd, err := ctx.Objects.NewReader(storageBucket, b.Location)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	return k.RestoreRDBFromDump(conf.KubeName(), conf.KubeConfig, rd)
+}
+
+func setBackupConfig(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.SetBackupConfigReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	err := ctx.DB().SetBackupConfig(project.ID, r.BackupConfig)
+	if err != nil {
+		ctx.Error("Couldn't set backup config: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.SetBackupConfigResp{})
+}
+
+func listBackups(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListBackupsReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	backups, err := ctx.DB().GetBackups(project.ID)
+	if err != nil {
+		ctx.Error("Couldn't list backups: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.ListBackupsResp{Backups: backups})
+}
+
+func restoreBackup(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.RestoreBackupReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	b, err := ctx.DB().GetBackup(r.BackupID)
+	if err != nil || b.ProjectID != project.ID {
+		ctx.UserError("No backup %v for %v (%v)", r.BackupID, project.ID, err)
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("No backup %v for this project", r.BackupID))
+		return
+	}
+
+	version, err := ctx.DB().RequestRestore(project.ID, b.ID)
+	if err != nil {
+		ctx.Error("Couldn't request restore: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.RestoreBackupResp{RestoreVersion: version})
+}
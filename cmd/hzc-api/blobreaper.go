@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+const blobReaperInterval = time.Hour
+
+// reapBlobs deletes deploy blobs that no release refers to and that no
+// deploy has touched for longer than grace.
+//
+// A blob is marked as being deleted before its object is deleted, and only
+// forgotten after that.  Deploys touch their blobs before looking for them,
+// so a deploy either touches a blob first, and it isn't marked, or sees
+// the mark and is turned away until the blob is gone; it never relies on
+// an object that is about to be deleted.  Blobs left marked by a reaper
+// that died part way are finished off on the next pass.
+func reapBlobs(ctx *hzhttp.Context, grace time.Duration) {
+	deleting, err := ctx.DB().GetDeletingBlobs(nil)
+	if err != nil {
+		ctx.Error("Couldn't list blobs being deleted: %v", err)
+		return
+	}
+	for _, md5 := range deleting {
+		deleteBlob(ctx, md5)
+	}
+
+	before := time.Now().Add(-grace)
+	md5s, err := ctx.DB().GetUnreferencedBlobs(before)
+	if err != nil {
+		ctx.Error("Couldn't list unreferenced blobs: %v", err)
+		return
+	}
+	for _, md5 := range md5s {
+		marked, err := ctx.DB().MarkBlobDeleting(md5, before)
+		if err != nil {
+			ctx.Error("Couldn't mark blob %v: %v", types.BlobName(md5), err)
+			continue
+		}
+		if !marked {
+			// Used again since we listed it, or already being deleted.
+			continue
+		}
+		deleteBlob(ctx, md5)
+	}
+}
+
+// deleteBlob deletes the object of a blob marked as being deleted, and
+// then forgets the blob.
+func deleteBlob(ctx *hzhttp.Context, md5 []byte) {
+	name := types.BlobName(md5)
+	ctx.Info("deleting unused blob %v", name)
+	err := ctx.Objects.Delete(storageBucket, name)
+	if err != nil && err != provider.ErrObjectNotExist {
+		ctx.Error("Couldn't delete blob %v: %v", name, err)
+		return
+	}
+	err = ctx.DB().ForgetBlob(md5)
+	if err != nil {
+		ctx.Error("Couldn't forget blob %v: %v", name, err)
+	}
+}
+
+func blobReaperLoop(ctx *hzhttp.Context, grace time.Duration) {
+	ctx = ctx.WithLog(map[string]interface{}{"action": "blobReaper"})
+	for {
+		reapBlobs(ctx, grace)
+		if !sleep(blobReaperInterval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// hzc-http obtains certificates for domains with ACME; these store its
+// certificates, challenges and account so that all hzc-http servers share
+// them.
+
+func listCertificates(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListCertificatesReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	domains, err := ctx.DB().GetAllDomains()
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	expiries, err := ctx.DB().GetCertificateExpiries()
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	ret := make(map[string]time.Time, len(domains))
+	for _, domain := range domains {
+		ret[domain] = expiries[domain]
+	}
+	api.WriteJSON(rw, http.StatusOK, api.ListCertificatesResp{Domains: ret})
+}
+
+func getCertificate(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.GetCertificateReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	cert, err := ctx.DB().GetCertificate(r.Domain)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.GetCertificateResp{Certificate: cert})
+}
+
+func setCertificate(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.SetCertificateReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	err := ctx.DB().SetCertificate(&r.Certificate)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.Info("new certificate for %v expires %v",
+		r.Certificate.Domain, r.Certificate.NotAfter)
+	api.WriteJSON(rw, http.StatusOK, api.SetCertificateResp{})
+}
+
+func lockCertificate(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.LockCertificateReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	locked, err := ctx.DB().LockCertificate(
+		r.Domain, r.Holder, time.Now().Add(r.Duration))
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.LockCertificateResp{Locked: locked})
+}
+
+func getACMEChallenge(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.GetACMEChallengeReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	keyAuth, err := ctx.DB().GetACMEChallenge(r.Token)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.GetACMEChallengeResp{KeyAuth: keyAuth})
+}
+
+func setACMEChallenge(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.SetACMEChallengeReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	var err error
+	if r.KeyAuth == "" {
+		err = ctx.DB().RemoveACMEChallenge(r.Token)
+	} else {
+		err = ctx.DB().SetACMEChallenge(&types.ACMEChallenge{
+			Token:   r.Token,
+			KeyAuth: r.KeyAuth,
+			Created: time.Now(),
+		})
+	}
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.SetACMEChallengeResp{})
+}
+
+func getACMEAccount(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.GetACMEAccountReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	acct, err := ctx.DB().GetOrAddACMEAccount(&r.Account)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.GetACMEAccountResp{Account: acct})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+)
+
+const diskReaperInterval = time.Hour
+
+// reapDisks makes one pass over the project disks.  kube.DeleteProject
+// leaves disks behind on purpose; a disk is deleted once it has been
+// orphaned (no RC or pod uses it, and its project is gone or being deleted)
+// for longer than grace.  Disks of projects that still exist are never
+// deleted, so that disks replaced by a restore can be recovered by hand.
+//
+// Disks created before they were tagged with their project have no
+// description.  They're orphaned as soon as nothing uses them, since
+// there's no telling which project they belong to.
+func reapDisks(ctx *hzhttp.Context, grace time.Duration, dryRun bool) {
+	k := ctx.Kube
+
+	disks, err := ctx.Provider.ListDisks("uuid-")
+	if err != nil {
+		ctx.Error("Couldn't list disks: %v", err)
+		return
+	}
+	inUse, err := k.VolumesInUse()
+	if err != nil {
+		ctx.Error("Couldn't list volumes in use: %v", err)
+		return
+	}
+	projects, err := ctx.DB().GetAllProjects()
+	if err != nil {
+		ctx.Error("Couldn't list projects: %v", err)
+		return
+	}
+	orphans, err := ctx.DB().GetOrphanedDisks()
+	if err != nil {
+		ctx.Error("Couldn't list orphaned disks: %v", err)
+		return
+	}
+
+	liveProjects := make(map[string]bool, len(projects))
+	for _, p := range projects {
+		if !p.Deleting {
+			liveProjects[p.KubeName()] = true
+		}
+	}
+
+	now := time.Now()
+	existing := make(map[string]bool, len(disks))
+	for _, disk := range disks {
+		existing[disk.Name] = true
+		ctx := ctx.WithLog(map[string]interface{}{"disk": disk.Name})
+		firstSeen, recorded := orphans[disk.Name]
+		notOrphaned := func() {
+			if recorded {
+				ctx.MaybeError(ctx.DB().RemoveOrphanedDisk(disk.Name))
+			}
+		}
+
+		project := k.DiskProject(disk)
+		untagged := disk.Description == ""
+		if project == "" && !untagged {
+			ctx.Info("not created for this namespace (%#v), skipping", disk.Description)
+			continue
+		}
+		if user, ok := inUse[disk.Name]; ok {
+			ctx.Info("in use by %s, keeping", user)
+			notOrphaned()
+			continue
+		}
+		if liveProjects[project] {
+			ctx.Info("project %s still exists, keeping", project)
+			notOrphaned()
+			continue
+		}
+		if !recorded {
+			if untagged {
+				ctx.Info("newly orphaned, untagged")
+			} else {
+				ctx.Info("newly orphaned from project %s", project)
+			}
+			ctx.MaybeError(ctx.DB().AddOrphanedDisk(disk.Name, now))
+			continue
+		}
+		if now.Sub(firstSeen) < grace {
+			ctx.Info("orphaned since %v, within grace period", firstSeen)
+			continue
+		}
+		if dryRun {
+			ctx.Info("orphaned since %v, would delete (dry run)", firstSeen)
+			continue
+		}
+		ctx.Info("orphaned since %v, deleting", firstSeen)
+		err := ctx.Provider.DeleteDisk(disk.Name)
+		if err != nil {
+			ctx.Error("Couldn't delete disk: %v", err)
+			continue
+		}
+		notOrphaned()
+	}
+
+	// Forget about disks that were deleted some other way.
+	for name := range orphans {
+		if !existing[name] {
+			ctx.MaybeError(ctx.DB().RemoveOrphanedDisk(name))
+		}
+	}
+}
+
+func diskReaperLoop(ctx *hzhttp.Context, grace time.Duration, dryRun bool) {
+	ctx = ctx.WithLog(map[string]interface{}{
+		"action": "diskReaper",
+		"dryrun": dryRun,
+	})
+	for {
+		reapDisks(ctx, grace, dryRun)
+		if !sleep(diskReaperInterval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+const (
+	challengeTimeout     = 10 * time.Second
+	domainWatchKeepalive = 30 * time.Second
+)
+
+// privateNets are the address ranges challengeClient won't connect to,
+// since the host it's asked to fetch from is chosen by the user.
+var privateNets = func() []*net.IPNet {
+	var ret []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, n)
+	}
+	return ret
+}()
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var errNotPublic = errors.New("it doesn't resolve to a public address")
+
+// dialPublic resolves addr's host itself and connects only to a public
+// address, so that the address checked is the one connected to.
+func dialPublic(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: challengeTimeout}
+	for _, ip := range ips {
+		if isPublicIP(ip) {
+			return dialer.Dial(network, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	return nil, errNotPublic
+}
+
+// notPublic reports whether a challengeClient request failed because
+// dialPublic refused the host.
+func notPublic(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if oerr, ok := err.(*net.OpError); ok {
+		err = oerr.Err
+	}
+	return err == errNotPublic
+}
+
+var challengeClient = &http.Client{
+	Timeout:   challengeTimeout,
+	Transport: &http.Transport{Dial: dialPublic},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return errors.New("redirects aren't followed")
+	},
+}
+
+// verifyDomainClaim checks whether the claim's ID has been published in
+// its TXT record or at its well-known URL.  The error is for the user, so
+// it says what was missing without repeating what the host sent back.
+func verifyDomainClaim(c *types.DomainClaim) error {
+	txts, dnsErr := net.LookupTXT(c.TXTRecord())
+	for _, txt := range txts {
+		if strings.TrimSpace(txt) == c.ID {
+			return nil
+		}
+	}
+
+	resp, err := challengeClient.Get(c.WellKnownURL())
+	if err != nil {
+		if notPublic(err) {
+			return fmt.Errorf("no TXT record %v containing %v (%v), "+
+				"and couldn't fetch %v: %v",
+				c.TXTRecord(), c.ID, dnsErr, c.WellKnownURL(), errNotPublic)
+		}
+		return fmt.Errorf("no TXT record %v containing %v (%v), "+
+			"and couldn't fetch %v",
+			c.TXTRecord(), c.ID, dnsErr, c.WellKnownURL())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err == nil && resp.StatusCode == http.StatusOK &&
+		strings.TrimSpace(string(body)) == c.ID {
+		return nil
+	}
+	return fmt.Errorf("no TXT record %v containing %v, "+
+		"and %v didn't respond with it",
+		c.TXTRecord(), c.ID, c.WellKnownURL())
+}
+
+func addDomain(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.AddDomainReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{
+		"project": r.ProjectID,
+		"domain":  r.Domain,
+	})
+
+	project := getOwnedProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	served, err := ctx.DB().GetProjectDomains(project.ID)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	if hasUser(served, r.Domain) {
+		api.WriteJSON(rw, http.StatusOK, api.AddDomainResp{Verified: true})
+		return
+	}
+
+	claim, err := ctx.DB().GetDomainClaim(r.Domain, project.ID)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	if claim == nil {
+		claim = &types.DomainClaim{
+			ID:        uuid.New(),
+			Domain:    r.Domain,
+			ProjectID: project.ID,
+			Created:   time.Now(),
+		}
+		err = ctx.DB().AddDomainClaim(claim)
+		if err != nil {
+			ctx.Error("Couldn't add domain claim: %v", err)
+			api.WriteJSONError(rw, http.StatusInternalServerError,
+				errors.New("Internal error"))
+			return
+		}
+		ctx.Info("claimed domain %v", r.Domain)
+		api.WriteJSON(rw, http.StatusOK, api.AddDomainResp{Claim: claim})
+		return
+	}
+
+	err = verifyDomainClaim(claim)
+	if err != nil {
+		ctx.UserError("Couldn't verify claim on %v: %v", r.Domain, err)
+		api.WriteJSON(rw, http.StatusOK, api.AddDomainResp{
+			Claim:       claim,
+			VerifyError: err.Error(),
+		})
+		return
+	}
+
+	err = ctx.DB().SetDomain(r.Domain, project.ID)
+	if err != nil {
+		ctx.Error("Couldn't set domain: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "addDomain",
+		ProjectID: &project.ID,
+		Domain:    r.Domain,
+	})
+	ctx.Info("serving %v on %v", project.ID, r.Domain)
+	api.WriteJSON(rw, http.StatusOK, api.AddDomainResp{Verified: true})
+}
+
+func removeDomain(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.RemoveDomainReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{
+		"project": r.ProjectID,
+		"domain":  r.Domain,
+	})
+
+	project := getOwnedProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	err = ctx.DB().RemoveDomain(r.Domain, project.ID)
+	if err != nil {
+		ctx.Error("Couldn't remove domain: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "removeDomain",
+		ProjectID: &project.ID,
+		Domain:    r.Domain,
+	})
+	ctx.Info("no longer serving %v on %v", project.ID, r.Domain)
+	api.WriteJSON(rw, http.StatusOK, api.RemoveDomainResp{})
+}
+
+func listDomains(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListDomainsReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	domains, err := ctx.DB().GetProjectDomains(project.ID)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	claims, err := ctx.DB().GetDomainClaims(project.ID)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.ListDomainsResp{
+		Domains: domains,
+		Claims:  claims,
+	})
+}
+
+// watchDomains streams api.DomainChanges until the client goes away, so
+// that hzc-http can drop cached ProjectAddrs as soon as they change.
+func watchDomains(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.WatchDomainsReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Streaming not supported"))
+		return
+	}
+	var gone <-chan bool
+	if cn, ok := rw.(http.CloseNotifier); ok {
+		gone = cn.CloseNotify()
+	}
+
+	changes := make(chan string)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	defer close(stop)
+	go func() {
+		done <- ctx.DB().WatchDomains(changes, stop)
+	}()
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(rw)
+	send := func(domain string) bool {
+		err := enc.Encode(api.DomainChange{Domain: domain})
+		if err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// Keepalives look like the first change, so don't send any until the
+	// changefeed has sent that.
+	watching := false
+	keepalive := time.NewTicker(domainWatchKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case domain := <-changes:
+			watching = true
+			if !send(domain) {
+				return
+			}
+		case <-keepalive.C:
+			if watching && !send("") {
+				return
+			}
+		case err := <-done:
+			ctx.Error("Domain changefeed failed: %v", err)
+			return
+		case <-gone:
+			return
+		case <-stopping:
+			// hzc-http reconnects to another replica.
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// settled reports whether every config version of conf has been applied,
+// so that its Kube objects should match it.
+func settled(conf *types.Project) bool {
+	for _, name := range types.ConfigVersionNames {
+		if cv := conf.ConfigVersion(name); cv.Desired != cv.Applied {
+			return false
+		}
+	}
+	return true
+}
+
+// claimProject marks a project as having a worker, so that changes to it
+// queue up instead of being applied while the drift checker corrects it.
+// It returns false if the project already has a worker, or hzc-api is
+// shutting down.  The claim is given up by running applyProjects for the
+// project, which applies anything that was queued in the meantime.
+func claimProject(kubeName string) bool {
+	projectsLock.Lock()
+	defer projectsLock.Unlock()
+	if _, workerRunning := projects[kubeName]; workerRunning || !startWork() {
+		return false
+	}
+	projects[kubeName] = nil
+	return true
+}
+
+// checkProjectDrift compares a project's Kube objects with its config,
+// correcting them if correct is set, and returns the result.
+func checkProjectDrift(
+	ctx *hzhttp.Context, conf *types.Project, correct bool) *types.DriftStatus {
+	k := ctx.Kube
+	status := &types.DriftStatus{
+		Kube:      conf.KubeName(),
+		ProjectID: conf.ID,
+		Checked:   time.Now(),
+	}
+	env, err := projectEnv(conf)
+	if err != nil {
+		ctx.Error("Couldn't decrypt environment: %v", err)
+		status.Error = "error decrypting environment variables"
+		return status
+	}
+	images, err := projectImages(conf)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	drift, err := k.ProjectDrift(conf.KubeName(), conf.KubeConfig, env, images)
+	if err != nil {
+		ctx.Error("Couldn't compare objects: %v", err)
+		status.Error = "error reading Kube objects"
+		return status
+	}
+	status.Drift = drift
+	if len(drift) == 0 {
+		return status
+	}
+	ctx.Info("found drift: %v", drift)
+	if !correct {
+		return status
+	}
+
+	ctx.Info("correcting drift")
+	status.Corrected = true
+	err = k.CorrectDrift(conf.KubeName(), conf.KubeConfig, env, images)
+	if err != nil {
+		ctx.Error("Couldn't correct drift: %v", err)
+		status.CorrectError = err.Error()
+		return status
+	}
+	err = k.ReconfigureRDB(conf.KubeName(), conf.KubeConfig.NumRDB)
+	if err != nil {
+		ctx.Error("Couldn't set RethinkDB replica counts: %v", err)
+		status.CorrectError = err.Error()
+	}
+	return status
+}
+
+// checkDrift makes one pass over the projects, recording a DriftStatus for
+// each one.  Projects with config changes still to be applied, or with a
+// worker applying them, are skipped until they settle.
+func checkDrift(ctx *hzhttp.Context, correct bool) {
+	rows, err := ctx.DB().GetAllProjects()
+	if err != nil {
+		ctx.Error("Couldn't list projects: %v", err)
+		return
+	}
+
+	var keep []string
+	for _, conf := range rows {
+		if shuttingDown() {
+			// Don't forget the statuses of projects not checked yet.
+			return
+		}
+		if conf.Deleting || conf.MovedTo != nil {
+			continue
+		}
+		keep = append(keep, conf.KubeName())
+		if !settled(conf) {
+			continue
+		}
+		ctx := ctx.WithLog(map[string]interface{}{"project": conf.ID})
+		kubeName := conf.KubeName()
+		if !claimProject(kubeName) {
+			ctx.Info("being applied, skipping")
+			continue
+		}
+		// The project may have changed, and the change been applied,
+		// since it was listed.
+		conf, err := ctx.DB().GetProject(conf.ID)
+		if err != nil {
+			ctx.Error("Couldn't get project: %v", err)
+		} else if settled(conf) && !conf.Deleting && conf.MovedTo == nil {
+			status := checkProjectDrift(ctx, conf, correct)
+			ctx.MaybeError(ctx.DB().SetDriftStatus(status))
+		}
+		go applyProjects(ctx, kubeName)
+	}
+	ctx.MaybeError(ctx.DB().DeleteDriftStatuses(keep))
+}
+
+func driftLoop(ctx *hzhttp.Context, interval time.Duration, correct bool) {
+	ctx = ctx.WithLog(map[string]interface{}{
+		"action":  "drift",
+		"correct": correct,
+	})
+	for sleep(interval) {
+		checkDrift(ctx, correct)
+	}
+}
+
+func getProjectDrift(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.GetProjectDriftReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	status, err := ctx.DB().GetDriftStatus(project.KubeName())
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.GetProjectDriftResp{Status: status})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// openEnv returns a project's environment with secret values unsealed,
+// for EnsureProject.
+func openEnv(env map[string]types.EnvVar) (map[string]string, error) {
+	ret := make(map[string]string, len(env))
+	for name, v := range env {
+		if !v.Secret {
+			ret[name] = v.Value
+			continue
+		}
+		value, err := envSealer.Open(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unseal %v: %v", name, err)
+		}
+		ret[name] = value
+	}
+	return ret, nil
+}
+
+// openHorizonSettings returns a copy of hs with its secrets unsealed.
+func openHorizonSettings(
+	hs *types.HorizonSettings) (*types.HorizonSettings, error) {
+	ret := *hs
+	var err error
+	if hs.TokenSecret != "" {
+		ret.TokenSecret, err = envSealer.Open(hs.TokenSecret)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unseal TokenSecret: %v", err)
+		}
+	}
+	ret.AuthProviders = make([]types.AuthProvider, len(hs.AuthProviders))
+	for i, p := range hs.AuthProviders {
+		p.Secret, err = envSealer.Open(p.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unseal %v secret: %v", p.Name, err)
+		}
+		ret.AuthProviders[i] = p
+	}
+	return &ret, nil
+}
+
+// projectEnv returns the environment a project's Horizon servers run with:
+// its Env, plus its HorizonSettings if it has any.
+func projectEnv(conf *types.Project) (map[string]string, error) {
+	env, err := openEnv(conf.Env)
+	if err != nil {
+		return nil, err
+	}
+	if conf.HorizonSettings != nil {
+		hs, err := openHorizonSettings(conf.HorizonSettings)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range hs.Env() {
+			env[name] = value
+		}
+	}
+	return env, nil
+}
+
+func setEnv(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.SetEnvReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{
+		"project": r.ProjectID,
+		"env":     r.Name,
+	})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := project.Env[r.Name]; !ok && len(project.Env) >= types.MaxEnvVars {
+		api.WriteJSONError(rw, http.StatusBadRequest, fmt.Errorf(
+			"Projects can't have more than %v environment variables",
+			types.MaxEnvVars))
+		return
+	}
+
+	v := types.EnvVar{Value: r.Value, Secret: r.Secret}
+	if v.Secret {
+		v.Value, err = envSealer.Seal(r.Value)
+		if err != nil {
+			ctx.Error("Couldn't seal %v: %v", r.Name, err)
+			api.WriteJSONError(rw, http.StatusInternalServerError,
+				errors.New("Internal error"))
+			return
+		}
+	}
+	_, err = ctx.DB().SetProjectEnv(project.ID, r.Name, v)
+	if err != nil {
+		ctx.Error("Couldn't set %v: %v", r.Name, err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "setEnv",
+		ProjectID: &project.ID,
+		Env:       r.Name,
+	})
+	ctx.Info("set %v (secret: %v)", r.Name, r.Secret)
+	api.WriteJSON(rw, http.StatusOK, api.SetEnvResp{})
+}
+
+func unsetEnv(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.UnsetEnvReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{
+		"project": r.ProjectID,
+		"env":     r.Name,
+	})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := project.Env[r.Name]; !ok {
+		// Nothing to do, and no reason to restart Horizon.
+		api.WriteJSON(rw, http.StatusOK, api.UnsetEnvResp{})
+		return
+	}
+	_, err = ctx.DB().UnsetProjectEnv(project.ID, r.Name)
+	if err != nil {
+		ctx.Error("Couldn't unset %v: %v", r.Name, err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "unsetEnv",
+		ProjectID: &project.ID,
+		Env:       r.Name,
+	})
+	ctx.Info("unset %v", r.Name)
+	api.WriteJSON(rw, http.StatusOK, api.UnsetEnvResp{})
+}
+
+func listEnv(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListEnvReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	env := make(map[string]types.EnvVar, len(project.Env))
+	for name, v := range project.Env {
+		if v.Secret {
+			v.Value = ""
+		}
+		env[name] = v
+	}
+	api.WriteJSON(rw, http.StatusOK, api.ListEnvResp{Env: env})
+}
+
+// oldAuthSecret returns the sealed secret of the named auth provider in
+// hs, or "" if there isn't one.
+func oldAuthSecret(hs *types.HorizonSettings, name string) string {
+	if hs == nil {
+		return ""
+	}
+	for _, p := range hs.AuthProviders {
+		if p.Name == name {
+			return p.Secret
+		}
+	}
+	return ""
+}
+
+// sealHorizonSettings seals the secrets in hs, keeping those from old
+// where hs leaves them empty.  Projects get a random TokenSecret if they
+// have never set one.
+func sealHorizonSettings(
+	hs *types.HorizonSettings, old *types.HorizonSettings) error {
+	var err error
+	switch {
+	case hs.TokenSecret != "":
+		hs.TokenSecret, err = envSealer.Seal(hs.TokenSecret)
+	case old != nil && old.TokenSecret != "":
+		hs.TokenSecret = old.TokenSecret
+	default:
+		var buf [32]byte
+		_, err = rand.Read(buf[:])
+		if err == nil {
+			hs.TokenSecret, err = envSealer.Seal(hex.EncodeToString(buf[:]))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range hs.AuthProviders {
+		p := &hs.AuthProviders[i]
+		if p.Secret == "" {
+			p.Secret = oldAuthSecret(old, p.Name)
+			continue
+		}
+		p.Secret, err = envSealer.Seal(p.Secret)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setHorizonSettings(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.SetHorizonSettingsReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	for _, p := range r.Settings.AuthProviders {
+		if p.Secret == "" && oldAuthSecret(project.HorizonSettings, p.Name) == "" {
+			api.WriteJSONError(rw, http.StatusBadRequest,
+				fmt.Errorf("auth provider %v needs a Secret", p.Name))
+			return
+		}
+	}
+
+	settings := r.Settings
+	err = sealHorizonSettings(&settings, project.HorizonSettings)
+	if err != nil {
+		ctx.Error("Couldn't seal Horizon settings: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	_, err = ctx.DB().SetHorizonSettings(project.ID, settings)
+	if err != nil {
+		ctx.Error("Couldn't set Horizon settings: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "setHorizonSettings",
+		ProjectID: &project.ID,
+	})
+	api.WriteJSON(rw, http.StatusOK, api.SetHorizonSettingsResp{})
+}
+
+func getHorizonSettings(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.GetHorizonSettingsReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	settings := types.DefaultHorizonSettings
+	if project.HorizonSettings != nil {
+		settings = *project.HorizonSettings
+	}
+	settings.TokenSecret = ""
+	providers := make([]types.AuthProvider, len(settings.AuthProviders))
+	for i, p := range settings.AuthProviders {
+		p.Secret = ""
+		providers[i] = p
+	}
+	settings.AuthProviders = providers
+	api.WriteJSON(rw, http.StatusOK, api.GetHorizonSettingsResp{
+		Settings: settings,
+		Version:  project.HorizonSettingsVersion,
+	})
+}
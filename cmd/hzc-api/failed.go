@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func init() {
+	RootCmd.AddCommand(failedCmd)
+	RootCmd.AddCommand(requeueCmd)
+}
+
+var failedCmd = &cobra.Command{
+	Use:   "failed",
+	Short: "list the project configs that the sync loop has given up on",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("failed takes no arguments")
+		}
+		failed, err := openDB().GetFailedProjects()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range failed {
+			for _, name := range types.ConfigVersionNames {
+				cv := p.ConfigVersion(name)
+				if !cv.Failed() {
+					continue
+				}
+				attempts := "no record of attempts"
+				if cv.Attempted == cv.Desired {
+					attempts = fmt.Sprintf("%d attempts, last at %v",
+						cv.Attempts, cv.LastAttempt)
+				}
+				fmt.Printf("%s %s %d (%s): %s\n", p.SlashName(), name,
+					cv.Desired, attempts, cv.LastError)
+			}
+		}
+	},
+}
+
+var requeueCmd = &cobra.Command{
+	Use:   "requeue OWNER/NAME [VERSION...]",
+	Short: "retry project configs that the sync loop has given up on",
+	Long: `Give failed config versions of a project (like KubeConfigVersion, as
+listed by "failed") a fresh set of attempts.  With no VERSION, every failed
+version of the project is requeued.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 || !strings.Contains(args[0], "/") {
+			log.Fatalf("requeue takes a project as OWNER/NAME")
+		}
+		parts := strings.SplitN(args[0], "/", 2)
+		d := openDB()
+		p, err := d.GetProject(types.NewProjectID(parts[0], parts[1]))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		names := args[1:]
+		if len(names) == 0 {
+			for _, name := range types.ConfigVersionNames {
+				if p.ConfigVersion(name).Failed() {
+					names = append(names, name)
+				}
+			}
+			if len(names) == 0 {
+				log.Fatalf("%s has no failed versions", p.SlashName())
+			}
+		}
+		for _, name := range names {
+			if p.ConfigVersion(name) == nil {
+				log.Fatalf("unknown version %#v (versions are %s)",
+					name, strings.Join(types.ConfigVersionNames, ", "))
+			}
+			_, err = d.RequeueConfigVersion(p.ID, name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Requeued %s of %s.", name, p.SlashName())
+		}
+	},
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+)
+
+// leaderLease is the lease held by the hzc-api replica that runs the sync
+// loop and the other background loops that change projects.
+const leaderLease = "hzc-api-leader"
+
+func leaseHolder() string {
+	hostname, _ := os.Hostname()
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	return hostname + "-" + hex.EncodeToString(nonce[:])
+}
+
+// leaderLoop waits for this replica to get the leader lease, runs lead,
+// and then keeps renewing the lease every ttl/3.  Every replica serves the
+// API, but only the leader applies changes to projects.
+//
+// The loops lead starts can't be stopped part way through, so a leader
+// that loses the lease, or can't renew it for 2*ttl/3, exits before
+// another replica can take over, and comes back as a follower.  The
+// deadline is kept by a watchdog timer, so a renewal that hangs can't
+// hold it off.
+//
+// When hzc-api shuts down, a follower returns straight away, and the
+// leader keeps the lease until drained is closed, once its workers are
+// finished, and then releases it so that another replica can take over
+// without waiting for it to expire.
+func leaderLoop(
+	ctx *hzhttp.Context, ttl time.Duration, lead func(), drained <-chan struct{}) {
+	holder := leaseHolder()
+	ctx = ctx.WithLog(map[string]interface{}{
+		"action": "leader",
+		"holder": holder,
+	})
+
+	var renewed time.Time
+	for {
+		start := time.Now()
+		ok, err := ctx.DB().AcquireLease(leaderLease, holder, ttl)
+		if err != nil {
+			ctx.Error("Couldn't acquire lease: %v", err)
+		} else if ok {
+			renewed = start
+			break
+		} else if lease, err := ctx.DB().GetLease(leaderLease); err == nil && lease != nil {
+			ctx.Info("following %v until %v", lease.Holder, lease.Expires)
+		}
+		if !sleep(ttl / 3) {
+			return
+		}
+	}
+
+	if shuttingDown() {
+		ctx.MaybeError(ctx.DB().ReleaseLease(leaderLease, holder))
+		return
+	}
+	ctx.Info("became leader")
+	watchdog := time.AfterFunc(2*ttl/3-time.Since(renewed), func() {
+		ctx.Error("Couldn't renew lease within %v, exiting", 2*ttl/3)
+		os.Exit(1)
+	})
+	lead()
+	for {
+		select {
+		case <-time.After(ttl / 3):
+		case <-drained:
+			ctx.Info("releasing lease")
+			ctx.MaybeError(ctx.DB().ReleaseLease(leaderLease, holder))
+			watchdog.Stop()
+			return
+		}
+		start := time.Now()
+		ok, err := ctx.DB().AcquireLease(leaderLease, holder, ttl)
+		switch {
+		case err != nil:
+			ctx.Error("Couldn't renew lease: %v", err)
+		case !ok:
+			ctx.Error("Lost the lease to another replica, exiting")
+			os.Exit(1)
+		default:
+			// If the watchdog has already fired this is too late, and
+			// it's exiting anyway.
+			if watchdog.Stop() {
+				watchdog.Reset(2*ttl/3 - time.Since(start))
+			}
+		}
+	}
+}
@@ -7,8 +7,13 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -22,7 +27,11 @@ import (
 	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
 	"github.com/rethinkdb/horizon-cloud/internal/hzlog"
 	"github.com/rethinkdb/horizon-cloud/internal/kube"
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+	"github.com/rethinkdb/horizon-cloud/internal/provider/local"
+	"github.com/rethinkdb/horizon-cloud/internal/provider/s3"
 	"github.com/rethinkdb/horizon-cloud/internal/types"
+	"github.com/rethinkdb/horizon-cloud/internal/util"
 )
 
 // TODO: find a way to figure out which fields were parsed and which
@@ -32,6 +41,8 @@ import (
 var (
 	storageBucket string
 	tokenSecret   []byte
+	// envSealer seals the values of secret project environment variables.
+	envSealer *util.Sealer
 )
 
 type validator interface {
@@ -76,7 +87,7 @@ func getProjectAddrsByKey(
 	}
 	addrs := make([]types.ProjectAddr, len(projects))
 	for i, p := range projects {
-		addrs[i] = p.Addr(storageBucket)
+		addrs[i] = p.Addr(ctx.Objects.PublicURL(), storageBucket)
 	}
 	api.WriteJSON(rw, http.StatusOK,
 		api.GetProjectAddrsByKeyResp{ProjectAddrs: addrs})
@@ -93,7 +104,12 @@ func getProjectAddrByDomain(
 		api.WriteJSONError(rw, http.StatusInternalServerError, err)
 		return
 	}
-	addr := id.Addr(storageBucket)
+	project, err := ctx.DB().GetProject(*id)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	addr := project.Addr(ctx.Objects.PublicURL(), storageBucket)
 	api.WriteJSON(rw, http.StatusOK,
 		api.GetProjectAddrByDomainResp{ProjectAddr: &addr})
 }
@@ -170,22 +186,20 @@ func getProjectsByToken(
 	api.WriteJSON(rw, http.StatusOK, api.GetProjectsByTokenResp{projects})
 }
 
-func updateProjectManifest(
-	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
-
-	var r api.UpdateProjectManifestReq
-	if !decode(rw, req.Body, &r) {
-		return
-	}
-
-	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+// getProjectForToken verifies token and returns the project matching
+// projectID that the token's users are allowed to access.  The owner half
+// of projectID may be empty if the name alone is unambiguous.  On failure it
+// writes an error response and returns nil.
+func getProjectForToken(
+	ctx *hzhttp.Context, rw http.ResponseWriter,
+	token string, projectID types.ProjectID) *types.Project {
 
-	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	tokData, err := api.VerifyToken(token, tokenSecret)
 	if err != nil {
 		err = fmt.Errorf("bad token in request: %v", err)
 		ctx.UserError("%v", err)
 		api.WriteJSONError(rw, http.StatusBadRequest, err)
-		return
+		return nil
 	}
 
 	allowedProjects, err := ctx.DB().GetProjectsByUsers(tokData.Users)
@@ -193,15 +207,15 @@ func updateProjectManifest(
 		ctx.Error("Couldn't get project list for users: %v", err)
 		api.WriteJSONError(rw, http.StatusInternalServerError,
 			errors.New("Internal error"))
-		return
+		return nil
 	}
 
 	var candidateProjects []*types.Project
 	for _, project := range allowedProjects {
-		if r.ProjectID.Owner() == "" || r.ProjectID.Owner() == project.ID.Owner() {
-			if r.ProjectID.Name() == project.ID.Name() {
+		if projectID.Owner() == "" || projectID.Owner() == project.ID.Owner() {
+			if projectID.Name() == project.ID.Name() {
 				candidateProjects = append(candidateProjects, project)
-				if r.ProjectID.Owner() != "" {
+				if projectID.Owner() != "" {
 					break
 				}
 			}
@@ -210,31 +224,47 @@ func updateProjectManifest(
 
 	if len(candidateProjects) == 0 {
 		ctx.UserError(
-			"User %v not allowed to deploy to project %v", tokData.Users, r.ProjectID)
+			"User %v not allowed to deploy to project %v", tokData.Users, projectID)
 		api.WriteJSONError(rw, http.StatusBadRequest,
 			fmt.Errorf("That project is not in the set of projects you can deploy to: %v",
 				allowedProjects))
-		return
+		return nil
 	} else if len(candidateProjects) > 1 {
 		ctx.UserError(
 			"User %v allowed to deploy to multiple projects for %v: %v",
-			tokData.Users, r.ProjectID, candidateProjects)
+			tokData.Users, projectID, candidateProjects)
 		api.WriteJSONError(rw, http.StatusBadRequest,
 			fmt.Errorf("Ambiguous project name %s.  Unable to distinguish: %v."+
 				"Please specify the owner of the project like `OWNER/%s`",
-				r.ProjectID.Name(), candidateProjects, r.ProjectID.Name()))
+				projectID.Name(), candidateProjects, projectID.Name()))
+		return nil
+	}
+
+	return candidateProjects[0]
+}
+
+func updateProjectManifest(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+
+	var r api.UpdateProjectManifestReq
+	if !decode(rw, req.Body, &r) {
 		return
 	}
 
-	trueProjectID := candidateProjects[0].ID
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
 
-	stagingPrefix := "deploy/" + trueProjectID.KubeName() + "/staging/"
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	trueProjectID := project.ID
 
-	requests, err := requestsForFilelist(
-		ctx,
-		storageBucket,
-		stagingPrefix,
-		r.Files)
+	requests, err := requestsForFilelist(ctx, storageBucket, r.Files)
+	if err == errBlobsDeleting {
+		ctx.UserError("%v", err)
+		api.WriteJSONError(rw, http.StatusServiceUnavailable, err)
+		return
+	}
 	if err != nil {
 		ctx.Error("Couldn't create request list for file list: %v", err)
 		api.WriteJSONError(rw, http.StatusInternalServerError,
@@ -260,13 +290,9 @@ func updateProjectManifest(
 		return
 	}
 
-	err = copyAllObjects(
-		ctx,
-		storageBucket, stagingPrefix,
-		storageBucket, "deploy/"+trueProjectID.KubeName()+"/active/")
+	release, err := createRelease(ctx, project, r.Files)
 	if err != nil {
-		ctx.Error("Couldn't copy objects for %v to active location: %v",
-			trueProjectID, err)
+		ctx.Error("Couldn't create release for %v: %v", trueProjectID, err)
 		api.WriteJSONError(rw, http.StatusInternalServerError,
 			errors.New("Internal error"))
 		return
@@ -274,6 +300,7 @@ func updateProjectManifest(
 
 	api.WriteJSON(rw, http.StatusOK, api.UpdateProjectManifestResp{
 		NeededRequests: []types.FileUploadRequest{},
+		ReleaseID:      release.ID,
 	})
 }
 
@@ -308,6 +335,18 @@ var RootCmd = &cobra.Command{
 		}
 		sharedSecret := string(data)
 
+		data, err = ioutil.ReadFile(viper.GetString("http_secret"))
+		if err != nil {
+			log.Fatal("Unable to read hzc-http secret file: ", err)
+		}
+		if len(data) < 16 {
+			log.Fatal("hzc-http secret was not long enough")
+		}
+		if string(data) == sharedSecret {
+			log.Fatal("hzc-http secret must differ from the shared secret")
+		}
+		httpSecret := string(data)
+
 		tokenSecret, err = ioutil.ReadFile(viper.GetString("token_secret"))
 		if err != nil {
 			log.Fatal("Unable to read token secret file: ", err)
@@ -316,21 +355,35 @@ var RootCmd = &cobra.Command{
 			log.Fatal("Token secret was not long enough")
 		}
 
-		rdbConn, err := db.New(viper.GetString("rethinkdb_addr"))
+		envKey, err := ioutil.ReadFile(viper.GetString("env_key"))
 		if err != nil {
-			log.Fatal("Unable to connect to RethinkDB: ", err)
+			log.Fatal("Unable to read env key file: ", err)
 		}
-		baseCtx = baseCtx.WithParts(&hzhttp.Context{DBConn: rdbConn})
+		if len(envKey) < 16 {
+			log.Fatal("Env key was not long enough")
+		}
+		envSealer, err = util.NewSealer(envKey)
+		if err != nil {
+			log.Fatal("Unable to use env key: ", err)
+		}
+
+		versions, err = loadVersions(viper.GetString("versions_file"))
+		if err != nil {
+			log.Fatal("Unable to load versions: ", err)
+		}
+		retryPolicy.MaxAttempts = viper.GetInt("retry_attempts")
+		retryPolicy.Backoff = viper.GetDuration("retry_backoff")
+		retryPolicy.MaxBackoff = viper.GetDuration("retry_max_backoff")
 
-		serviceAccountData, err := ioutil.ReadFile(viper.GetString("service_account"))
+		rdbConn, err := db.New(viper.GetString("rethinkdb_addr"))
 		if err != nil {
-			log.Fatal("Unable to read service account file: ", err)
+			log.Fatal("Unable to connect to RethinkDB: ", err)
 		}
-		serviceAccount, err := google.JWTConfigFromJSON(serviceAccountData, storage.ScopeFullControl, compute.ComputeScope)
+		err = rdbConn.EnsureSchema()
 		if err != nil {
-			log.Fatal("Unable to parse service account: ", err)
+			log.Fatal("Unable to set up RethinkDB tables: ", err)
 		}
-		baseCtx = baseCtx.WithParts(&hzhttp.Context{ServiceAccount: serviceAccount})
+		baseCtx = baseCtx.WithParts(&hzhttp.Context{DBConn: rdbConn})
 
 		storageBucketBytes, err := ioutil.ReadFile(viper.GetString("storage_bucket_file"))
 		if err != nil {
@@ -338,53 +391,204 @@ var RootCmd = &cobra.Command{
 		}
 		storageBucket = string(storageBucketBytes)
 
-		region := "us-central1-f" // TODO: Generalize/parameterize
-		gc, err := gcloud.New(serviceAccount, viper.GetString("cluster_name"), region)
-		if err != nil {
-			log.Fatal("Unable to create gcloud client: ", err)
+		var p provider.Provider
+		var objectHandler http.Handler
+		switch viper.GetString("provider") {
+		case "gce":
+			serviceAccountData, err := ioutil.ReadFile(viper.GetString("service_account"))
+			if err != nil {
+				log.Fatal("Unable to read service account file: ", err)
+			}
+			serviceAccount, err := google.JWTConfigFromJSON(serviceAccountData, storage.ScopeFullControl, compute.ComputeScope)
+			if err != nil {
+				log.Fatal("Unable to parse service account: ", err)
+			}
+			baseCtx = baseCtx.WithParts(&hzhttp.Context{ServiceAccount: serviceAccount})
+
+			region := "us-central1-f" // TODO: Generalize/parameterize
+			p, err = gcloud.New(serviceAccount, viper.GetString("cluster_name"), region)
+			if err != nil {
+				log.Fatal("Unable to create gcloud client: ", err)
+			}
+		case "local":
+			lp, err := local.New(viper.GetString("local_root"),
+				viper.GetString("object_url"), tokenSecret)
+			if err != nil {
+				log.Fatal("Unable to create local provider: ", err)
+			}
+			p = lp
+		default:
+			log.Fatalf("Unknown provider %#v", viper.GetString("provider"))
 		}
-		baseCtx = baseCtx.WithParts(&hzhttp.Context{GCloud: gc})
+		baseCtx = baseCtx.WithParts(&hzhttp.Context{Provider: p})
+
+		var objects provider.ObjectStore
+		switch viper.GetString("object_store") {
+		case "provider":
+			objects = p.Objects()
+		case "s3":
+			secretKey, err := ioutil.ReadFile(viper.GetString("s3_secret_key_file"))
+			if err != nil {
+				log.Fatal("Unable to read S3 secret key file: ", err)
+			}
+			objects, err = s3.New(viper.GetString("s3_endpoint"),
+				viper.GetString("s3_region"), viper.GetString("s3_access_key"),
+				strings.TrimSpace(string(secretKey)))
+			if err != nil {
+				log.Fatal("Unable to create S3 client: ", err)
+			}
+		case "dir":
+			objects, err = local.NewDir(viper.GetString("object_dir"),
+				viper.GetString("object_url"), tokenSecret)
+			if err != nil {
+				log.Fatal("Unable to create object directory: ", err)
+			}
+		default:
+			log.Fatalf("Unknown object store %#v", viper.GetString("object_store"))
+		}
+		if dir, ok := objects.(*local.Dir); ok {
+			objectHandler = http.StripPrefix("/objects", dir.Handler())
+		}
+		baseCtx = baseCtx.WithParts(&hzhttp.Context{Objects: objects})
 
 		k := kube.New(viper.GetString("template_path"),
-			viper.GetString("kube_namespace"), gc)
+			viper.GetString("kube_namespace"), p)
+		k.SaveOperation = baseCtx.DB().SetOperation
 		baseCtx = baseCtx.WithParts(&hzhttp.Context{Kube: k})
 
-		go projectSync(baseCtx)
+		lead := func() {
+			go projectSync(baseCtx)
+			go backupLoop(baseCtx)
+			go diskReaperLoop(baseCtx,
+				viper.GetDuration("disk_reaper_grace"),
+				viper.GetBool("disk_reaper_dry_run"))
+			go blobReaperLoop(baseCtx, viper.GetDuration("blob_reaper_grace"))
+			go driftLoop(baseCtx,
+				viper.GetDuration("drift_interval"),
+				viper.GetBool("drift_correct"))
+		}
+		drained := make(chan struct{})
+		leaderDone := make(chan struct{})
+		go func() {
+			defer close(leaderDone)
+			leaderLoop(baseCtx, viper.GetDuration("leader_lease"), lead, drained)
+		}()
 
 		paths := []struct {
-			Path          string
-			Func          func(ctx *hzhttp.Context, w http.ResponseWriter, r *http.Request)
-			RequireSecret bool
+			Path string
+			Func func(ctx *hzhttp.Context, w http.ResponseWriter, r *http.Request)
+			// Secret is the secret requests must carry, if any.
+			Secret string
 		}{
 			// Client uses these.
-			{api.UpdateProjectManifestPath, updateProjectManifest, false},
-			{api.GetProjectsByTokenPath, getProjectsByToken, false},
+			{api.UpdateProjectManifestPath, updateProjectManifest, ""},
+			{api.GetProjectsByTokenPath, getProjectsByToken, ""},
+			{api.CreateProjectPath, createProject, ""},
+			{api.DeleteProjectPath, deleteProject, ""},
+			{api.TransferProjectPath, transferProject, ""},
+			{api.RenameProjectPath, renameProject, ""},
+			{api.AddProjectUserPath, addProjectUser, ""},
+			{api.RemoveProjectUserPath, removeProjectUser, ""},
+			{api.ListUserKeysPath, listUserKeys, ""},
+			{api.AddUserKeyPath, addUserKey, ""},
+			{api.RemoveUserKeyPath, removeUserKey, ""},
+			{api.AddDomainPath, addDomain, ""},
+			{api.RemoveDomainPath, removeDomain, ""},
+			{api.ListDomainsPath, listDomains, ""},
+			{api.SetEnvPath, setEnv, ""},
+			{api.UnsetEnvPath, unsetEnv, ""},
+			{api.ListEnvPath, listEnv, ""},
+			{api.ListVersionsPath, listVersions, ""},
+			{api.SetRuntimePath, setRuntime, ""},
+			{api.SetHorizonSettingsPath, setHorizonSettings, ""},
+			{api.GetHorizonSettingsPath, getHorizonSettings, ""},
+			{api.GetProjectDriftPath, getProjectDrift, ""},
+			{api.GetProjectStatusPath, getProjectStatus, ""},
+			{api.ListOperationsPath, listOperations, ""},
+			{api.SetBackupConfigPath, setBackupConfig, ""},
+			{api.ListBackupsPath, listBackups, ""},
+			{api.RestoreBackupPath, restoreBackup, ""},
+			{api.ListReleasesPath, listReleases, ""},
+			{api.SetActiveReleasePath, setActiveRelease, ""},
 
 			// Other server stuff uses these.
-			{api.GetUsersByKeyPath, getUsersByKey, true},
-			{api.GetProjectAddrsByKeyPath, getProjectAddrsByKey, true},
-
-			// hzc-http uses this and doesn't have access to the secret
-			// because it runs in the user cluster.
-			{api.GetProjectAddrByDomainPath, getProjectAddrByDomain, false},
+			{api.GetUsersByKeyPath, getUsersByKey, sharedSecret},
+			{api.GetProjectAddrsByKeyPath, getProjectAddrsByKey, sharedSecret},
+
+			// hzc-http runs in the user cluster, so it doesn't have access
+			// to the shared secret.  It uses these to get certificates,
+			// with a secret of its own that is good for nothing else.
+			{api.ListCertificatesPath, listCertificates, httpSecret},
+			{api.GetCertificatePath, getCertificate, httpSecret},
+			{api.SetCertificatePath, setCertificate, httpSecret},
+			{api.LockCertificatePath, lockCertificate, httpSecret},
+			{api.GetACMEChallengePath, getACMEChallenge, httpSecret},
+			{api.SetACMEChallengePath, setACMEChallenge, httpSecret},
+			{api.GetACMEAccountPath, getACMEAccount, httpSecret},
+
+			// hzc-http also uses these, without any secret.
+			{api.GetProjectAddrByDomainPath, getProjectAddrByDomain, ""},
+			{api.GetReleaseManifestPath, getReleaseManifest, ""},
+			{api.WatchDomainsPath, watchDomains, ""},
 		}
 
 		mux := hzhttp.NewMuxer()
 		for _, path := range paths {
 			var h hzhttp.Handler = hzhttp.HandlerFunc(path.Func)
-			if path.RequireSecret {
-				h = api.RequireSecret(sharedSecret, h)
+			if path.Secret != "" {
+				h = api.RequireSecret(path.Secret, h)
 			}
 			mux.RegisterPath(path.Path, h)
 		}
 		logMux := hzhttp.LogHTTPRequests(mux)
 
-		logger.Info("Started.")
+		handler := hzhttp.BaseContext(baseCtx, logMux)
+		if objectHandler != nil {
+			topMux := http.NewServeMux()
+			topMux.Handle("/objects/", objectHandler)
+			topMux.Handle("/", handler)
+			handler = topMux
+		}
+
 		listenAddr := viper.GetString("listen")
-		err = http.ListenAndServe(listenAddr, hzhttp.BaseContext(baseCtx, logMux))
+		listener, err := net.Listen("tcp", listenAddr)
 		if err != nil {
+			logger.Error("Couldn't listen on %v: %v", listenAddr, err)
+			return
+		}
+		requests := &requestTracker{handler: handler}
+		server := &http.Server{Handler: requests}
+
+		// On SIGTERM, stop taking connections and starting work, and then
+		// give the requests and workers in progress until shutdown_timeout
+		// to finish before handing over the leader lease.
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+			sig := <-signals
+			logger.Info("Got %v, shutting down.", sig)
+			stopWork()
+			server.SetKeepAlivesEnabled(false)
+			listener.Close()
+		}()
+
+		logger.Info("Started.")
+		err = server.Serve(listener)
+		if !shuttingDown() {
 			logger.Error("Couldn't serve on %v: %v", listenAddr, err)
+			return
 		}
+
+		deadline := time.Now().Add(viper.GetDuration("shutdown_timeout"))
+		if !waitUntil(deadline, requests.wait) {
+			logger.Error("Gave up waiting for requests to finish.")
+		}
+		if !waitUntil(deadline, workers.Wait) {
+			logger.Error("Gave up waiting for workers to finish.")
+		}
+		close(drained)
+		<-leaderDone
+		logger.Info("Shut down.")
 	},
 }
 
@@ -398,16 +602,57 @@ func init() {
 		"/secrets/api-shared-secret/api-shared-secret",
 		"Location of API shared secret")
 
+	pf.String("http_secret",
+		"/secrets/api-http-secret/api-http-secret",
+		"Location of the secret hzc-http uses for the certificate and ACME endpoints")
+
 	pf.String("token_secret",
 		"/secrets/token-secret/token-secret",
 		"Location of token secret file")
 
+	pf.String("env_key",
+		"/secrets/env-key/env-key",
+		"Location of the key that project secrets are encrypted with")
+
+	pf.String("provider", "gce",
+		"Cloud provider to use for disks and storage (gce or local).")
+
 	pf.String("cluster_name", "horizon-cloud-1239",
 		"Name of the GCE cluster to use.")
 
-	pf.String("template_path",
-		os.Getenv("GOPATH")+"/src/github.com/rethinkdb/horizon-cloud/templates/",
-		"Path to the templates to use when creating Kube objects.")
+	pf.String("local_root", "/var/lib/hzc",
+		"Directory to keep disks and objects in with the local provider.")
+
+	pf.String("object_store", "provider",
+		"Where to store deploys and backups (provider, s3 or dir).")
+
+	pf.String("s3_endpoint", "https://s3.amazonaws.com",
+		"S3 or S3-compatible endpoint, with the s3 object store.")
+
+	pf.String("s3_region", "us-east-1",
+		"S3 region, with the s3 object store.")
+
+	pf.String("s3_access_key", "",
+		"S3 access key ID, with the s3 object store.")
+
+	pf.String("s3_secret_key_file",
+		"/secrets/s3-secret-key/s3-secret-key",
+		"Location of S3 secret key file, with the s3 object store.")
+
+	pf.String("object_dir", "/var/lib/hzc/objects",
+		"Directory to keep objects in, with the dir object store.")
+
+	pf.String("object_url", "http://localhost:8000/objects",
+		"URL at which clients can reach /objects/ on this server, with the local provider or dir object store.")
+
+	pf.String("template_path", "",
+		"Directory of text/template files (horizon.yaml, rethinkdb.yaml, "+
+			"rethinkdb-replica.yaml, rethinkdb-resize.yaml) overriding the built-in "+
+			"Kube manifests.")
+
+	pf.String("versions_file", "",
+		"JSON file listing the Horizon and RethinkDB images projects can run; "+
+			"by default they can only run $HORIZON_GCR_ID and $RETHINKDB_GCR_ID.")
 
 	pf.String("storage_bucket_file",
 		"",
@@ -423,6 +668,37 @@ func init() {
 	pf.String("kube_namespace", "dev",
 		"Kubernetes namespace to put pods in.")
 
+	pf.Int("retry_attempts", 5,
+		"How many times to try applying a project's config before giving up on it.")
+
+	pf.Duration("retry_backoff", 30*time.Second,
+		"How long to wait before retrying a project's config the first time; "+
+			"the wait doubles with each failure.")
+
+	pf.Duration("retry_max_backoff", 15*time.Minute,
+		"The longest wait between retries of a project's config.")
+
+	pf.Duration("leader_lease", 30*time.Second,
+		"How long the replica running the sync loop keeps the job without renewing its lease.")
+
+	pf.Duration("shutdown_timeout", 4*time.Minute,
+		"How long to wait for requests and project workers to finish when shutting down.")
+
+	pf.Duration("disk_reaper_grace", 7*24*time.Hour,
+		"How long a project disk must be orphaned before it is deleted.")
+
+	pf.Bool("disk_reaper_dry_run", false,
+		"Log which orphaned disks would be deleted without deleting them.")
+
+	pf.Duration("blob_reaper_grace", 24*time.Hour,
+		"How long a deploy blob must be unused before it is deleted.")
+
+	pf.Duration("drift_interval", 10*time.Minute,
+		"How often to compare projects' Kube objects with their config.")
+
+	pf.Bool("drift_correct", false,
+		"Put projects' Kube objects back the way their config says when they have drifted.")
+
 	viper.BindPFlags(pf)
 }
 
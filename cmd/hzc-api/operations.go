@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// operationHistory is how many of a project's operations are listed.
+const operationHistory = 20
+
+// rollBackOperations rolls back the operations left running by a leader
+// that died part way through them.  The Kube configs they were applying
+// are still pending, so the sync loop starts them over.  It must run
+// before any workers are started.
+//
+// If a project's Kube config has been applied since, which happens when
+// an operation could be run but not recorded as finished, or when drift
+// correction was cut short, its resources may be in use, so the operation
+// is only marked failed, and only the disks it never recorded the names of
+// are deleted.
+func rollBackOperations(ctx *hzhttp.Context) {
+	ctx = ctx.WithLog(map[string]interface{}{"action": "rollBackOperations"})
+
+	ops, err := ctx.DB().GetRunningOperations()
+	if err != nil {
+		ctx.Error("Couldn't list running operations: %v", err)
+		return
+	}
+	if len(ops) == 0 {
+		return
+	}
+	rows, err := ctx.DB().GetAllProjects()
+	if err != nil {
+		ctx.Error("Couldn't list projects: %v", err)
+		return
+	}
+	applied := make(map[string]bool, len(rows))
+	for _, p := range rows {
+		kcv := p.KubeConfigVersion
+		if !p.Deleting && p.MovedTo == nil && kcv.Applied == kcv.Desired {
+			applied[p.KubeName()] = true
+		}
+	}
+
+	for _, op := range ops {
+		if applied[op.Kube] {
+			ctx.Info("%v of %v from %v was cut short, but its config has been applied",
+				op.Name, op.Kube, op.Started)
+			err := ctx.Kube.DeleteUnrecordedDisks(op)
+			if err != nil {
+				ctx.Error("Couldn't delete disks of operation %v: %v", op.ID, err)
+			}
+			op.State = types.OperationFailed
+			op.Finished = time.Now()
+			op.Error = "interrupted"
+			ctx.MaybeError(ctx.DB().SetOperation(op))
+			continue
+		}
+		ctx.Info("rolling back %v of %v from %v", op.Name, op.Kube, op.Started)
+		err := ctx.Kube.RollBackOperation(op)
+		if err != nil {
+			ctx.Error("Couldn't roll back operation %v: %v", op.ID, err)
+		}
+	}
+}
+
+func listOperations(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListOperationsReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	ops, err := ctx.DB().GetOperations(project.KubeName(), operationHistory)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.ListOperationsResp{Operations: ops})
+}
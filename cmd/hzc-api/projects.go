@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/db"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// hasUser reports whether user is in users.
+func hasUser(users []string, user string) bool {
+	for _, u := range users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// getOwnedProjectForToken is like getProjectForToken, but only returns
+// projects owned by one of the token's users.  Other users of a project
+// can deploy to it, but can't delete, rename or transfer it.
+func getOwnedProjectForToken(
+	ctx *hzhttp.Context, rw http.ResponseWriter,
+	token string, projectID types.ProjectID) *types.Project {
+
+	project := getProjectForToken(ctx, rw, token, projectID)
+	if project == nil {
+		return nil
+	}
+	tokData, err := api.VerifyToken(token, tokenSecret)
+	if err != nil {
+		// Expired since getProjectForToken checked it.
+		err = fmt.Errorf("bad token in request: %v", err)
+		ctx.UserError("%v", err)
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return nil
+	}
+	if !hasUser(tokData.Users, project.Owner()) {
+		ctx.UserError("User %v is not the owner of %v", tokData.Users, project.ID)
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("Only %v can do that to %v.",
+				project.Owner(), project.SlashName()))
+		return nil
+	}
+	return project
+}
+
+// writeMoveError writes the response for a failed db.MoveProject.
+func writeMoveError(ctx *hzhttp.Context, rw http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrProjectExists, db.ErrProjectDeleting, db.ErrProjectMoving:
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+	ctx.Error("Couldn't move project: %v", err)
+	api.WriteJSONError(rw, http.StatusInternalServerError,
+		errors.New("Internal error"))
+}
+
+func createProject(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.CreateProjectReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		err = fmt.Errorf("bad token in request: %v", err)
+		ctx.UserError("%v", err)
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	owner := r.ProjectID.Owner()
+	if owner == "" {
+		if len(tokData.Users) != 1 {
+			api.WriteJSONError(rw, http.StatusBadRequest,
+				fmt.Errorf("You are more than one user (%v).  "+
+					"Please specify the owner of the project like `OWNER/%s`",
+					tokData.Users, r.ProjectID.Name()))
+			return
+		}
+		owner = tokData.Users[0]
+	} else if !hasUser(tokData.Users, owner) {
+		ctx.UserError("User %v can't create projects for %v", tokData.Users, owner)
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("You can only create projects owned by %v.", tokData.Users))
+		return
+	}
+
+	project := &types.Project{
+		ID:                types.NewProjectID(owner, r.ProjectID.Name()),
+		Users:             []string{owner},
+		Kube:              types.NewKubeName(),
+		KubeConfig:        types.DefaultKubeConfig,
+		KubeConfigVersion: types.ConfigVersion{Desired: 1},
+	}
+	err = ctx.DB().CreateProject(project)
+	if err == db.ErrProjectExists {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		ctx.Error("Couldn't create project: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	ctx.Info("created project %v", project.ID)
+	api.WriteJSON(rw, http.StatusOK, api.CreateProjectResp{Project: project})
+}
+
+func deleteProject(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.DeleteProjectReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getOwnedProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	err := ctx.DB().MarkProjectDeleting(project.ID)
+	if err != nil {
+		ctx.Error("Couldn't mark project for deletion: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	ctx.Info("deleting project %v", project.ID)
+	api.WriteJSON(rw, http.StatusOK, api.DeleteProjectResp{})
+}
+
+func transferProject(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.TransferProjectReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getOwnedProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	exists, err := ctx.DB().UserExists(r.NewOwner)
+	if err != nil {
+		ctx.Error("Couldn't look up user %v: %v", r.NewOwner, err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	if !exists {
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("No such user %v.", r.NewOwner))
+		return
+	}
+
+	// The new owner replaces the old one; other users keep their access.
+	users := []string{r.NewOwner}
+	for _, u := range project.Users {
+		if u != project.Owner() && u != r.NewOwner {
+			users = append(users, u)
+		}
+	}
+	newID := types.NewProjectID(r.NewOwner, project.Name())
+	moved, err := ctx.DB().MoveProject(project, newID, users)
+	if err != nil {
+		writeMoveError(ctx, rw, err)
+		return
+	}
+	ctx.Info("transferred project %v to %v", project.ID, moved.ID)
+	api.WriteJSON(rw, http.StatusOK, api.TransferProjectResp{Project: moved})
+}
+
+func renameProject(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.RenameProjectReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getOwnedProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	newID := types.NewProjectID(project.Owner(), r.NewName)
+	moved, err := ctx.DB().MoveProject(project, newID, project.Users)
+	if err != nil {
+		writeMoveError(ctx, rw, err)
+		return
+	}
+	ctx.Info("renamed project %v to %v", project.ID, moved.ID)
+	api.WriteJSON(rw, http.StatusOK, api.RenameProjectResp{Project: moved})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// releaseRetain is how many releases are kept per project, besides the
+// active one.  The blobs of older releases can then be collected.
+const releaseRetain = 20
+
+// createRelease records a release serving files, whose blobs must all
+// have been uploaded, and starts serving it.
+func createRelease(ctx *hzhttp.Context, p *types.Project,
+	files []types.FileDescription) (*types.Release, error) {
+	release := &types.Release{
+		ID:        uuid.New(),
+		ProjectID: p.ID,
+		Created:   time.Now(),
+		Files:     make(map[string]types.ReleaseFile, len(files)),
+	}
+	for _, file := range files {
+		release.Files[file.Path] = types.ReleaseFile{
+			MD5:         file.MD5,
+			ContentType: file.ContentType,
+		}
+	}
+
+	// Reference the blobs before the release exists, so that they can't
+	// be collected out from under it.  If we fail after this the blobs
+	// are leaked rather than broken.
+	err := ctx.DB().AddBlobRefs(release.ID, fileBlobs(files))
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.DB().AddRelease(release)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.DB().SetActiveRelease(p.ID, release.ID)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Info("now serving release %v", release.ID)
+
+	pruneReleases(ctx, p.ID, releaseRetain, release.ID)
+	return release, nil
+}
+
+// pruneReleases deletes all but the newest retain releases of a project,
+// except for the active one, and drops their references to blobs.
+func pruneReleases(ctx *hzhttp.Context, projectID types.ProjectID,
+	retain int, active string) {
+	releases, err := ctx.DB().GetReleases(projectID)
+	if err != nil {
+		ctx.Error("Couldn't list releases: %v", err)
+		return
+	}
+	kept := 0
+	for _, rel := range releases {
+		if rel.ID == active {
+			continue
+		}
+		if kept < retain {
+			kept++
+			continue
+		}
+		ctx.MaybeError(deleteRelease(ctx, rel.ID))
+	}
+}
+
+func deleteRelease(ctx *hzhttp.Context, id string) error {
+	release, err := ctx.DB().GetRelease(id)
+	if err != nil {
+		return err
+	}
+	ctx.Info("deleting release %v", id)
+	// Delete the release first; if we fail before dropping the
+	// references, the blobs are leaked rather than broken.
+	err = ctx.DB().DeleteRelease(id)
+	if err != nil {
+		return err
+	}
+	md5s := make([][]byte, 0, len(release.Files))
+	for _, file := range release.Files {
+		md5s = append(md5s, file.MD5)
+	}
+	return ctx.DB().RemoveBlobRefs(id, md5s, time.Now())
+}
+
+func listReleases(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListReleasesReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	releases, err := ctx.DB().GetReleases(project.ID)
+	if err != nil {
+		ctx.Error("Couldn't list releases: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.ListReleasesResp{
+		Releases:      releases,
+		ActiveRelease: project.ActiveRelease,
+	})
+}
+
+func setActiveRelease(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.SetActiveReleaseReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+
+	release, err := ctx.DB().GetRelease(r.ReleaseID)
+	if err != nil || release.ProjectID != project.ID {
+		ctx.UserError("No release %v for %v (%v)", r.ReleaseID, project.ID, err)
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("No release %v for this project", r.ReleaseID))
+		return
+	}
+
+	err = ctx.DB().SetActiveRelease(project.ID, release.ID)
+	if err != nil {
+		ctx.Error("Couldn't set active release: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	ctx.Info("now serving release %v", release.ID)
+	api.WriteJSON(rw, http.StatusOK, api.SetActiveReleaseResp{})
+}
+
+func getReleaseManifest(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.GetReleaseManifestReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	release, err := ctx.DB().GetRelease(r.ReleaseID)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.GetReleaseManifestResp{
+		Files: release.Files,
+	})
+}
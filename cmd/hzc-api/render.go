@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/rethinkdb/horizon-cloud/internal/db"
+	"github.com/rethinkdb/horizon-cloud/internal/hzlog"
+	"github.com/rethinkdb/horizon-cloud/internal/kube"
+	"github.com/rethinkdb/horizon-cloud/internal/manifest"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// renderVolume stands in for the data volumes of RethinkDB replicas, which
+// depend on the cloud provider and on which disks exist.
+const renderVolume = `{"name":"data","emptyDir":{}}`
+
+func init() {
+	RootCmd.AddCommand(renderCmd)
+}
+
+// openDB connects to RethinkDB for commands other than the server.
+func openDB() *db.DB {
+	rdbConn, err := db.New(viper.GetString("rethinkdb_addr"))
+	if err != nil {
+		log.Fatal("Unable to connect to RethinkDB: ", err)
+	}
+	return rdbConn.WithLogger(hzlog.BlankLogger())
+}
+
+// projectEnvNames returns the names of the variables in a project's env
+// Secret, without unsealing their values.
+func projectEnvNames(conf *types.Project) []string {
+	seen := make(map[string]bool)
+	for name := range conf.Env {
+		seen[name] = true
+	}
+	if conf.HorizonSettings != nil {
+		for name := range conf.HorizonSettings.Env() {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var renderCmd = &cobra.Command{
+	Use:   "render OWNER/NAME",
+	Short: "print the Kube manifests of a project without applying them",
+	Long: `Print the Kube manifests of a project as they would be rendered for
+it now, including any overrides in --template_path.  The data volumes of
+RethinkDB replicas are shown as emptyDir volumes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 || !strings.Contains(args[0], "/") {
+			log.Fatalf("render takes a project as OWNER/NAME")
+		}
+		parts := strings.SplitN(args[0], "/", 2)
+
+		var err error
+		versions, err = loadVersions(viper.GetString("versions_file"))
+		if err != nil {
+			log.Fatal("Unable to load versions: ", err)
+		}
+		conf, err := openDB().GetProject(types.NewProjectID(parts[0], parts[1]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		images, err := projectImages(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		params := kube.ManifestParams(conf.KubeName(), conf.KubeConfig,
+			projectEnvNames(conf), images)
+		r := manifest.NewRenderer(viper.GetString("template_path"))
+		show := func(name string) {
+			data, err := r.Render(name, params)
+			if err != nil {
+				log.Fatalf("Unable to render %v: %v", name, err)
+			}
+			fmt.Printf("---\n# %s\n%s", name, data)
+		}
+		show(manifest.Horizon)
+		show(manifest.RethinkDB)
+		for i := 0; i < conf.KubeConfig.NumRDB; i++ {
+			params.Replica = i
+			params.Volume = renderVolume
+			show(manifest.RethinkDBReplica)
+		}
+	},
+}
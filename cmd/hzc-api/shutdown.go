@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+)
+
+// stopping is closed when hzc-api starts shutting down.  From then on no
+// new work is started: the sync loop stops reading changes, projects
+// aren't queued, and the other background loops return.  Work that isn't
+// started is still pending in the database, so the next leader does it.
+var stopping = make(chan struct{})
+
+// workers counts the project workers, drift checks and backups in
+// progress, which shutdown waits for.
+var workers sync.WaitGroup
+var workersLock sync.Mutex
+
+func shuttingDown() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// startWork adds a worker, unless hzc-api is shutting down, in which case
+// it returns false.  The worker calls workers.Done when it's finished.
+func startWork() bool {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	if shuttingDown() {
+		return false
+	}
+	workers.Add(1)
+	return true
+}
+
+// stopWork closes stopping.
+func stopWork() {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	close(stopping)
+}
+
+// sleep waits for d, and reports whether it did; it returns false as soon
+// as hzc-api starts shutting down.
+func sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stopping:
+		return false
+	}
+}
+
+// waitUntil runs wait, and reports whether it returned before deadline.
+func waitUntil(deadline time.Time, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(deadline.Sub(time.Now())):
+		return false
+	}
+}
+
+// requestTracker counts the requests in progress so that shutdown can
+// wait for them, and turns away any that come in on open connections
+// after that.
+type requestTracker struct {
+	handler http.Handler
+
+	mu      sync.Mutex
+	stopped bool
+	active  sync.WaitGroup
+}
+
+func (t *requestTracker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		rw.Header().Set("Connection", "close")
+		api.WriteJSONError(rw, http.StatusServiceUnavailable,
+			errors.New("Shutting down"))
+		return
+	}
+	t.active.Add(1)
+	t.mu.Unlock()
+	defer t.active.Done()
+	t.handler.ServeHTTP(rw, req)
+}
+
+// wait turns away new requests and waits for those in progress.
+func (t *requestTracker) wait() {
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+	t.active.Wait()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+)
+
+func getProjectStatus(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.GetProjectStatusReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	resp := api.GetProjectStatusResp{
+		KubeConfigVersion:    project.KubeConfigVersion,
+		HorizonConfigVersion: project.HorizonConfigVersion,
+	}
+	components, err := ctx.Kube.ProjectStatus(project.KubeName(), project.KubeConfig)
+	if err != nil {
+		ctx.Error("Couldn't get project status: %v", err)
+		resp.Error = "error reading Kube objects"
+	}
+	resp.Components = components
+	if project.ActiveRelease != "" {
+		release, err := ctx.DB().GetRelease(project.ActiveRelease)
+		if err != nil {
+			ctx.Error("Couldn't get active release: %v", err)
+		} else {
+			release.Files = nil
+			resp.ActiveRelease = release
+		}
+	}
+	api.WriteJSON(rw, http.StatusOK, resp)
+}
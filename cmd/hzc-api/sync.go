@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/rethinkdb/horizon-cloud/internal/db"
 	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
@@ -15,13 +16,68 @@ import (
 var projects = make(map[string]*types.Project)
 var projectsLock sync.Mutex
 
+// retryPolicy says how failed config versions are retried.  Kube
+// ConfigErrors are about the config itself, so they aren't.
+var retryPolicy = types.RetryPolicy{
+	Retryable: func(err error) bool {
+		_, isConfigError := err.(*kube.ConfigError)
+		return !isConfigError
+	},
+}
+
+// retries holds the timer that will queue each project with a version
+// waiting for a retry.  It's protected by projectsLock.
+var retries = make(map[string]*time.Timer)
+
+// scheduleRetry queues a project again at the earliest NextAttempt of
+// its versions that are waiting for a retry, if any, replacing any retry
+// already scheduled.
+func scheduleRetry(ctx *hzhttp.Context, kubeName string, conf *types.Project) {
+	var next time.Time
+	for _, name := range types.ConfigVersionNames {
+		cv := conf.ConfigVersion(name)
+		if cv.Retrying() && (next.IsZero() || cv.NextAttempt.Before(next)) {
+			next = cv.NextAttempt
+		}
+	}
+
+	projectsLock.Lock()
+	defer projectsLock.Unlock()
+	if t := retries[kubeName]; t != nil {
+		t.Stop()
+		delete(retries, kubeName)
+	}
+	if next.IsZero() || shuttingDown() {
+		return
+	}
+	ctx.Info("retrying at %v", next)
+	var t *time.Timer
+	t = time.AfterFunc(next.Sub(time.Now()), func() {
+		projectsLock.Lock()
+		if retries[kubeName] == t {
+			delete(retries, kubeName)
+		}
+		projectsLock.Unlock()
+		conf, err := ctx.DB().GetProject(conf.ID)
+		if err != nil {
+			ctx.Error("Couldn't get project to retry: %v", err)
+			return
+		}
+		if conf.Deleting || conf.MovedTo != nil {
+			return
+		}
+		queueProject(ctx, conf)
+	})
+	retries[kubeName] = t
+}
+
 func applyHorizonConfig(
 	// Errors returned from this are shown to users.
 	k *kube.Kube, ctx *hzhttp.Context, conf *types.Project) error {
 	ctx.Info("Applying Horizon config: %#v", conf.HorizonConfig)
 
 	hzc := conf.HorizonConfig
-	pods, err := k.GetHorizonPodsForProject(conf.ID)
+	pods, err := k.GetHorizonPodsForProject(conf.KubeName())
 	if err != nil {
 		ctx.Error("%v", err)
 		return fmt.Errorf("unable to get horizon pods")
@@ -45,6 +101,14 @@ func applyHorizonConfig(
 			"\nError:\n%v\n", stdout, stderr, err)
 		return err
 	}
+	// `hz set-schema` may have created tables with a single replica.
+	if conf.KubeConfig.NumRDB > 1 {
+		err = k.ReconfigureRDB(conf.KubeName(), conf.KubeConfig.NumRDB)
+		if err != nil {
+			ctx.Error("%v", err)
+			return fmt.Errorf("unable to set RethinkDB replica counts")
+		}
+	}
 	return nil
 }
 
@@ -52,9 +116,21 @@ func applyKubeConfig(
 	// Errors returned from this are shown to users.
 	k *kube.Kube, ctx *hzhttp.Context, conf *types.Project) error {
 	ctx.Info("Applying Kube config: %#v", conf.KubeConfig)
-	project, err := k.EnsureProject(conf.ID.KubeName(), conf.KubeConfig)
+	env, err := projectEnv(conf)
 	if err != nil {
 		ctx.Error(err.Error())
+		return fmt.Errorf("error decrypting environment variables")
+	}
+	images, err := projectImages(conf)
+	if err != nil {
+		return err
+	}
+	project, err := k.EnsureProject(conf.KubeName(), conf.KubeConfig, env, images)
+	if err != nil {
+		ctx.Error(err.Error())
+		if cerr, ok := err.(*kube.ConfigError); ok {
+			return cerr
+		}
 		return fmt.Errorf("error applying Kube config")
 	}
 	ctx.Info("waiting for Kube config")
@@ -63,21 +139,47 @@ func applyKubeConfig(
 		ctx.Error(err.Error())
 		return fmt.Errorf("error waiting for Kube config")
 	}
+	err = k.ReconfigureRDB(conf.KubeName(), conf.KubeConfig.NumRDB)
+	if err != nil {
+		ctx.Error(err.Error())
+		return fmt.Errorf("error setting RethinkDB replica counts")
+	}
+	return nil
+}
+
+func applyHorizonSettings(
+	// Errors returned from this are shown to users.
+	k *kube.Kube, ctx *hzhttp.Context, conf *types.Project) error {
+	ctx.Info("Applying Horizon settings")
+	env, err := projectEnv(conf)
+	if err != nil {
+		ctx.Error(err.Error())
+		return fmt.Errorf("error decrypting Horizon settings")
+	}
+	err = k.EnsureHorizonEnv(conf.KubeName(), conf.KubeConfig, env)
+	if err != nil {
+		ctx.Error(err.Error())
+		return fmt.Errorf("error restarting Horizon with new settings")
+	}
 	return nil
 }
 
+// applyProjects is the worker for a project.  It applies the latest conf
+// queued for the project until there are none left, or hzc-api is
+// shutting down.  It must be started after startWork.
 func applyProjects(ctx *hzhttp.Context, trueName string) {
+	defer workers.Done()
 	ctx = ctx.WithLog(map[string]interface{}{"action": "applyProjects"})
 	for {
 		conf := func() *types.Project {
 			projectsLock.Lock()
 			defer projectsLock.Unlock()
 			conf := projects[trueName]
-			if conf == nil {
+			if conf == nil || shuttingDown() {
 				delete(projects, trueName)
-			} else {
-				projects[trueName] = nil
+				return nil
 			}
+			projects[trueName] = nil
 			return conf
 		}()
 		if conf == nil {
@@ -89,59 +191,139 @@ func applyProjects(ctx *hzhttp.Context, trueName string) {
 		k := ctx.Kube
 		if conf.Deleting {
 			ctx.Info("deleting project")
-			err := k.DeleteProject(conf.ID.KubeName())
+			err := k.DeleteProject(conf.KubeName())
 			ctx.MaybeError(err)
+			pruneReleases(ctx, conf.ID, 0, "")
+			ctx.MaybeError(ctx.DB().DeleteProjectDomains(conf.ID))
+			ctx.MaybeError(ctx.DB().DeleteOperations(conf.KubeName()))
 			err = ctx.DB().DeleteProject(conf.ID)
 			ctx.MaybeError(err)
 			continue
 		}
-		ctx.Info("KubeConfigVersion: %#v", conf.KubeConfigVersion)
-		kConfVer := conf.KubeConfigVersion.MaybeConfigure(func() error {
+		now := time.Now()
+		// Once hzc-api is shutting down, the steps that haven't started
+		// are left for the next leader, which sees them still pending.
+		configure := func(
+			name string, cv types.ConfigVersion, f func() error) types.ConfigVersion {
+			ctx.Info("%s: %#v", name, cv)
+			if shuttingDown() {
+				return cv
+			}
+			cv = cv.MaybeConfigure(now, retryPolicy, f)
+			ctx.Info("new %s: %#v", name, cv)
+			return cv
+		}
+		kConfVer := configure("KubeConfigVersion", conf.KubeConfigVersion, func() error {
 			return applyKubeConfig(k, ctx, conf)
 		})
-		ctx.Info("new KubeConfigVersion: %#v", kConfVer)
-		ctx.Info("HorizonConfigVersion: %#v", conf.HorizonConfigVersion)
-		hzConfVer := conf.HorizonConfigVersion.MaybeConfigure(func() error {
+		runtimeVer := configure("RuntimeVersion", conf.RuntimeVersion, func() error {
+			return applyRuntime(k, ctx, conf)
+		})
+		hzSettingsVer := configure("HorizonSettingsVersion", conf.HorizonSettingsVersion, func() error {
+			return applyHorizonSettings(k, ctx, conf)
+		})
+		hzConfVer := configure("HorizonConfigVersion", conf.HorizonConfigVersion, func() error {
 			return applyHorizonConfig(k, ctx, conf)
 		})
-		ctx.Info("new HorizonConfigVersion: %#v", hzConfVer)
-		_, err := ctx.DB().UpdateProject(types.Project{
-			ID:                   conf.ID,
-			KubeConfigVersion:    kConfVer,
-			HorizonConfigVersion: hzConfVer,
+		restoreVer := configure("RestoreVersion", conf.RestoreVersion, func() error {
+			return applyRestore(k, ctx, conf)
 		})
+		update := types.Project{
+			ID:                     conf.ID,
+			KubeConfigVersion:      kConfVer,
+			RuntimeVersion:         runtimeVer,
+			HorizonSettingsVersion: hzSettingsVer,
+			HorizonConfigVersion:   hzConfVer,
+			RestoreVersion:         restoreVer,
+		}
+		_, err := ctx.DB().UpdateProject(update)
 		ctx.MaybeError(err)
+		scheduleRetry(ctx, trueName, &update)
 		ctx.Info("done applying project")
 	}
 }
 
+// queueProject schedules conf to be applied, starting a worker for the
+// project if there isn't one already.
+func queueProject(ctx *hzhttp.Context, conf *types.Project) {
+	projectsLock.Lock()
+	defer projectsLock.Unlock()
+	kubeName := conf.KubeName()
+	_, workerRunning := projects[kubeName]
+	if !workerRunning && !startWork() {
+		// Shutting down; left for the next leader.
+		return
+	}
+	projects[kubeName] = conf
+	if !workerRunning {
+		go applyProjects(ctx, kubeName)
+	}
+}
+
+// sweepOrphanedProjects tears down Kube objects belonging to projects that
+// no longer have a row in the projects table.  It must run before any
+// workers are started.
+func sweepOrphanedProjects(ctx *hzhttp.Context) {
+	ctx = ctx.WithLog(map[string]interface{}{"action": "sweepOrphanedProjects"})
+
+	// List the cluster first, so that any project created in between shows
+	// up in the table.
+	inCluster, err := ctx.Kube.ProjectsInCluster()
+	if err != nil {
+		ctx.Error("Couldn't list projects in cluster: %v", err)
+		return
+	}
+	rows, err := ctx.DB().GetAllProjects()
+	if err != nil {
+		ctx.Error("Couldn't list projects: %v", err)
+		return
+	}
+	known := make(map[string]bool, len(rows))
+	for _, p := range rows {
+		known[p.KubeName()] = true
+	}
+
+	for _, kubeName := range inCluster {
+		if known[kubeName] {
+			continue
+		}
+		ctx.Info("tearing down %v, which has no project", kubeName)
+		ctx.MaybeError(ctx.Kube.DeleteProject(kubeName))
+	}
+}
+
 func projectSync(ctx *hzhttp.Context) {
 	ctx = ctx.WithLog(map[string]interface{}{"action": "projectSync"})
 
+	sweepOrphanedProjects(ctx)
+	rollBackOperations(ctx)
+
 	changeChan := make(chan db.ProjectChange)
-	ctx.DB().ProjectChanges(changeChan)
+	ctx.DB().ProjectChanges(changeChan, stopping)
 	for c := range changeChan {
+		if c.NewVal != nil && c.NewVal.MovedTo != nil {
+			// Being renamed; the new row takes over.
+			continue
+		}
 		if c.NewVal != nil {
 			if c.NewVal.KubeConfigVersion.Desired == c.NewVal.KubeConfigVersion.Applied &&
+				c.NewVal.RuntimeVersion.Desired == c.NewVal.RuntimeVersion.Applied &&
+				c.NewVal.HorizonSettingsVersion.Desired == c.NewVal.HorizonSettingsVersion.Applied &&
 				c.NewVal.HorizonConfigVersion.Desired == c.NewVal.HorizonConfigVersion.Applied &&
+				c.NewVal.RestoreVersion.Desired == c.NewVal.RestoreVersion.Applied &&
 				!c.NewVal.Deleting {
 				continue
 			}
-			func() {
-				projectsLock.Lock()
-				defer projectsLock.Unlock()
-				kubeName := c.NewVal.ID.KubeName()
-				_, workerRunning := projects[kubeName]
-				projects[kubeName] = c.NewVal
-				if !workerRunning {
-					go applyProjects(ctx, kubeName)
-				}
-			}()
-		} else {
-			if c.OldVal != nil {
-				// TODO: tear down cluster.
-			}
+			queueProject(ctx, c.NewVal)
+		} else if c.OldVal != nil && !c.OldVal.Deleting && c.OldVal.MovedTo == nil {
+			// The project was removed from the table directly instead of
+			// being marked as Deleting; tear it down the same way.  (If it
+			// was Deleting, the worker removed the row itself, and if it
+			// was MovedTo, it lives on under another ID.)
+			conf := *c.OldVal
+			conf.Deleting = true
+			queueProject(ctx, &conf)
 		}
 	}
-	panic("unreachable")
+	ctx.Info("stopped")
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// Changes made here take effect when hzc-ssh next issues a token, since it
+// looks up users by key each time.
+
+// recordAudit records a change for audit.  The change has already been
+// made, so failures are only logged.
+func recordAudit(ctx *hzhttp.Context, actors []string, rec types.AuditRecord) {
+	rec.Time = time.Now()
+	rec.Actors = actors
+	err := ctx.DB().AddAuditRecord(&rec)
+	if err != nil {
+		ctx.Error("Couldn't record %#v for audit: %v", rec, err)
+	}
+}
+
+// getAccountForToken verifies token and returns its users and the account
+// the request is about, which must be one of them.  user may be empty if
+// the token is for a single user.  On failure it writes an error response
+// and returns an empty account.
+func getAccountForToken(
+	ctx *hzhttp.Context, rw http.ResponseWriter,
+	token string, user string) (*api.TokenData, string) {
+
+	tokData, err := api.VerifyToken(token, tokenSecret)
+	if err != nil {
+		err = fmt.Errorf("bad token in request: %v", err)
+		ctx.UserError("%v", err)
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return nil, ""
+	}
+	if user == "" {
+		if len(tokData.Users) != 1 {
+			api.WriteJSONError(rw, http.StatusBadRequest,
+				fmt.Errorf("You are more than one user (%v).  "+
+					"Please specify which one.", tokData.Users))
+			return nil, ""
+		}
+		return tokData, tokData.Users[0]
+	}
+	if !hasUser(tokData.Users, user) {
+		ctx.UserError("User %v can't manage account %v", tokData.Users, user)
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("You can only manage the accounts of %v.", tokData.Users))
+		return nil, ""
+	}
+	return tokData, user
+}
+
+func addProjectUser(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.AddProjectUserReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getOwnedProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	exists, err := ctx.DB().UserExists(r.User)
+	if err != nil {
+		ctx.Error("Couldn't look up user %v: %v", r.User, err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	if !exists {
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("No such user %v.", r.User))
+		return
+	}
+
+	project, err = ctx.DB().AddProjectUser(project.ID, r.User)
+	if err != nil {
+		ctx.Error("Couldn't add user %v: %v", r.User, err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "addProjectUser",
+		ProjectID: &project.ID,
+		User:      r.User,
+	})
+	ctx.Info("added user %v", r.User)
+	api.WriteJSON(rw, http.StatusOK, api.AddProjectUserResp{Users: project.Users})
+}
+
+func removeProjectUser(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.RemoveProjectUserReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+	// Users can leave a project, but only the owner can remove others.
+	if !hasUser(tokData.Users, project.Owner()) && !hasUser(tokData.Users, r.User) {
+		ctx.UserError("User %v can't remove %v from %v",
+			tokData.Users, r.User, project.ID)
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("Only %v can remove other users from %v.",
+				project.Owner(), project.SlashName()))
+		return
+	}
+	if r.User == project.Owner() {
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("%v owns %v; transfer it to someone else first.",
+				r.User, project.SlashName()))
+		return
+	}
+
+	project, err = ctx.DB().RemoveProjectUser(project.ID, r.User)
+	if err != nil {
+		ctx.Error("Couldn't remove user %v: %v", r.User, err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "removeProjectUser",
+		ProjectID: &project.ID,
+		User:      r.User,
+	})
+	ctx.Info("removed user %v", r.User)
+	api.WriteJSON(rw, http.StatusOK, api.RemoveProjectUserResp{Users: project.Users})
+}
+
+func listUserKeys(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListUserKeysReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	_, user := getAccountForToken(ctx, rw, r.Token, r.User)
+	if user == "" {
+		return
+	}
+	keys, err := ctx.DB().GetUserKeys(user)
+	if err != nil {
+		ctx.Error("Couldn't get keys for %v: %v", user, err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	api.WriteJSON(rw, http.StatusOK, api.ListUserKeysResp{User: user, Keys: keys})
+}
+
+func addUserKey(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.AddUserKeyReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	tokData, user := getAccountForToken(ctx, rw, r.Token, r.User)
+	if user == "" {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"user": user})
+
+	err := ctx.DB().AddUserKey(user, r.PublicKey)
+	if err != nil {
+		ctx.Error("Couldn't add key: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "addUserKey",
+		User:      user,
+		PublicKey: r.PublicKey,
+	})
+	ctx.Info("added key %v", r.PublicKey)
+	api.WriteJSON(rw, http.StatusOK, api.AddUserKeyResp{})
+}
+
+func removeUserKey(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.RemoveUserKeyReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	tokData, user := getAccountForToken(ctx, rw, r.Token, r.User)
+	if user == "" {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"user": user})
+
+	keys, err := ctx.DB().GetUserKeys(user)
+	if err != nil {
+		ctx.Error("Couldn't get keys: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	if !hasUser(keys, r.PublicKey) {
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			fmt.Errorf("%v has no such key.", user))
+		return
+	}
+	if len(keys) == 1 {
+		api.WriteJSONError(rw, http.StatusBadRequest,
+			errors.New("That is the only key on the account; "+
+				"add another one before removing it."))
+		return
+	}
+
+	err = ctx.DB().RemoveUserKey(user, r.PublicKey)
+	if err != nil {
+		ctx.Error("Couldn't remove key: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "removeUserKey",
+		User:      user,
+		PublicKey: r.PublicKey,
+	})
+	ctx.Info("removed key %v", r.PublicKey)
+	api.WriteJSON(rw, http.StatusOK, api.RemoveUserKeyResp{})
+}
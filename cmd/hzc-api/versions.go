@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/kube"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// versions are the versions of Horizon and RethinkDB projects can run.
+var versions *types.VersionCatalog
+
+// loadVersions reads a JSON VersionCatalog from path.  With no path, the
+// only versions are $HORIZON_GCR_ID and $RETHINKDB_GCR_ID.
+func loadVersions(path string) (*types.VersionCatalog, error) {
+	if path == "" {
+		return &types.VersionCatalog{
+			Horizon: []types.RuntimeVersion{
+				{Name: "default", Image: os.Getenv("HORIZON_GCR_ID")},
+			},
+			RethinkDB: []types.RuntimeVersion{
+				{Name: "default", Image: os.Getenv("RETHINKDB_GCR_ID")},
+			},
+		}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var vc types.VersionCatalog
+	err = json.NewDecoder(f).Decode(&vc)
+	if err != nil {
+		return nil, err
+	}
+	err = vc.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &vc, nil
+}
+
+// projectImages returns the images of the versions a project runs.
+func projectImages(conf *types.Project) (kube.Images, error) {
+	horizon, rethinkdb, err := versions.Images(conf.Runtime)
+	if err != nil {
+		return kube.Images{}, err
+	}
+	return kube.Images{Horizon: horizon, RethinkDB: rethinkdb}, nil
+}
+
+func applyRuntime(
+	// Errors returned from this are shown to users.
+	k *kube.Kube, ctx *hzhttp.Context, conf *types.Project) error {
+	ctx.Info("Applying runtime: %#v", conf.Runtime)
+	images, err := projectImages(conf)
+	if err != nil {
+		return err
+	}
+	err = k.UpgradeProject(conf.KubeName(), conf.KubeConfig, images)
+	if err != nil {
+		ctx.Error(err.Error())
+		if rerr, ok := err.(*kube.UpgradeReverted); ok {
+			return revertRuntime(ctx, conf, rerr)
+		}
+		return fmt.Errorf("error upgrading project")
+	}
+	return nil
+}
+
+// revertRuntime records that a project is back on the versions it ran
+// before a failed upgrade, so that its config matches what's running.
+func revertRuntime(
+	ctx *hzhttp.Context, conf *types.Project, rerr *kube.UpgradeReverted) error {
+	rt, err := versions.Runtime(rerr.Images.Horizon, rerr.Images.RethinkDB)
+	if err == nil {
+		err = ctx.DB().RevertProjectRuntime(
+			conf.ID, conf.RuntimeVersion.Desired, rt)
+	}
+	if err != nil {
+		ctx.Error("Couldn't set runtime back to what's running: %v", err)
+		return &kube.ConfigError{Msg: rerr.Error()}
+	}
+	ctx.Info("runtime reverted to %#v", rt)
+	return &types.RevertedError{Err: rerr}
+}
+
+func listVersions(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.ListVersionsReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	_, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		err = fmt.Errorf("bad token in request: %v", err)
+		ctx.UserError("%v", err)
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	var resp api.ListVersionsResp
+	for _, v := range versions.Horizon {
+		resp.Horizon = append(resp.Horizon, v.Name)
+	}
+	for _, v := range versions.RethinkDB {
+		resp.RethinkDB = append(resp.RethinkDB, v.Name)
+	}
+	api.WriteJSON(rw, http.StatusOK, resp)
+}
+
+func setRuntime(
+	ctx *hzhttp.Context, rw http.ResponseWriter, req *http.Request) {
+	var r api.SetRuntimeReq
+	if !decode(rw, req.Body, &r) {
+		return
+	}
+	ctx = ctx.WithLog(map[string]interface{}{"project": r.ProjectID})
+
+	project := getProjectForToken(ctx, rw, r.Token, r.ProjectID)
+	if project == nil {
+		return
+	}
+	tokData, err := api.VerifyToken(r.Token, tokenSecret)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	_, _, err = versions.Images(r.Runtime)
+	if err != nil {
+		api.WriteJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if r.Runtime == project.Runtime {
+		api.WriteJSON(rw, http.StatusOK, api.SetRuntimeResp{})
+		return
+	}
+
+	_, err = ctx.DB().SetProjectRuntime(project.ID, r.Runtime)
+	if err != nil {
+		ctx.Error("Couldn't set runtime: %v", err)
+		api.WriteJSONError(rw, http.StatusInternalServerError,
+			errors.New("Internal error"))
+		return
+	}
+	recordAudit(ctx, tokData.Users, types.AuditRecord{
+		Action:    "setRuntime",
+		ProjectID: &project.ID,
+	})
+	ctx.Info("upgrading to %#v", r.Runtime)
+	api.WriteJSON(rw, http.StatusOK, api.SetRuntimeResp{})
+}
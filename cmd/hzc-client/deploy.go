@@ -74,6 +74,21 @@ func getToken() (string, error) {
 	return realResponse.Token, nil
 }
 
+// parseProjectName parses `OWNER/NAME` or just `NAME`, leaving the API
+// server to work out the owner.
+func parseProjectName(name string) (types.ProjectID, error) {
+	nameParts := strings.Split(name, "/")
+	switch len(nameParts) {
+	case 1:
+		return types.NewProjectID("", nameParts[0]), nil
+	case 2:
+		return types.NewProjectID(nameParts[0], nameParts[1]), nil
+	}
+	return types.ProjectID{}, fmt.Errorf(
+		"invalid project name `%s` (has %d parts, needs 1 or 2)",
+		name, len(nameParts))
+}
+
 var deployCmd = &cobra.Command{
 	Use:   "deploy",
 	Short: "deploy a project",
@@ -195,20 +210,12 @@ var deployCmd = &cobra.Command{
 
 			log.Printf("Checking local manifest against server...")
 
-			nameParts := strings.Split(name, "/")
-			userName := ""
-			projectName := ""
-			if len(nameParts) == 1 {
-				projectName = nameParts[0]
-			} else if len(nameParts) == 2 {
-				userName = nameParts[0]
-				projectName = nameParts[1]
-			} else {
-				log.Fatalf("invalid project name `%s` (has %d parts, needs 1 or 2)",
-					name, len(nameParts))
+			projectID, err := parseProjectName(name)
+			if err != nil {
+				log.Fatal(err)
 			}
 			resp, err := apiClient.UpdateProjectManifest(api.UpdateProjectManifestReq{
-				ProjectID:     types.NewProjectID(userName, projectName),
+				ProjectID:     projectID,
 				Files:         files,
 				Token:         token,
 				HorizonConfig: schema,
@@ -218,6 +225,9 @@ var deployCmd = &cobra.Command{
 			}
 
 			if len(resp.NeededRequests) == 0 {
+				if resp.ReleaseID != "" {
+					log.Printf("Serving release %s.", resp.ReleaseID)
+				}
 				break
 			}
 
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	domainsCmd.AddCommand(domainsListCmd)
+	domainsCmd.AddCommand(domainsAddCmd)
+	domainsCmd.AddCommand(domainsRemoveCmd)
+	RootCmd.AddCommand(domainsCmd)
+}
+
+// normalizeDomain lowercases domain and strips any trailing dot, since
+// that's the form the API server stores.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+var domainsCmd = &cobra.Command{
+	Use:   "domains",
+	Short: "manage the domains a project is served on",
+}
+
+var domainsListCmd = &cobra.Command{
+	Use:   "list [OWNER/]NAME",
+	Short: "list a project's domains",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("domains list takes exactly one project name")
+		}
+		projectID := mustParseProjectName(args[0])
+		token, apiClient := connect()
+		resp, err := apiClient.ListDomains(api.ListDomainsReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, domain := range resp.Domains {
+			fmt.Println(domain)
+		}
+		for _, claim := range resp.Claims {
+			fmt.Printf("%s (unverified)\n", claim.Domain)
+		}
+	},
+}
+
+var domainsAddCmd = &cobra.Command{
+	Use:   "add [OWNER/]NAME DOMAIN",
+	Short: "serve a project on a domain",
+	Long: `Serve a project on a domain you control.  The first time, this
+prints a token to publish, either in a TXT record or at a URL on the
+domain.  Once it's published, run the same command again to verify it.
+Only the owner of a project can add domains to it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalf("domains add takes a project name and a domain")
+		}
+		projectID := mustParseProjectName(args[0])
+		domain := normalizeDomain(args[1])
+		token, apiClient := connect()
+		resp, err := apiClient.AddDomain(api.AddDomainReq{
+			Token:     token,
+			ProjectID: projectID,
+			Domain:    domain,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if resp.Verified {
+			log.Printf("Serving %s on %s.", args[0], domain)
+			return
+		}
+		if resp.VerifyError != "" {
+			log.Printf("Couldn't verify %s: %s", domain, resp.VerifyError)
+		}
+		c := resp.Claim
+		fmt.Printf(`To prove you control %s, do one of these:
+  - Add a TXT record for %s containing %s
+  - Serve %s at %s
+Then run this command again.
+`, domain, c.TXTRecord(), c.ID, c.ID, c.WellKnownURL())
+	},
+}
+
+var domainsRemoveCmd = &cobra.Command{
+	Use:   "remove [OWNER/]NAME DOMAIN",
+	Short: "stop serving a project on a domain",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalf("domains remove takes a project name and a domain")
+		}
+		projectID := mustParseProjectName(args[0])
+		domain := normalizeDomain(args[1])
+		token, apiClient := connect()
+		_, err := apiClient.RemoveDomain(api.RemoveDomainReq{
+			Token:     token,
+			ProjectID: projectID,
+			Domain:    domain,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("No longer serving %s on %s.", args[0], domain)
+	},
+}
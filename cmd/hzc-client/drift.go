@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(driftCmd)
+}
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "show whether a project's servers match its config",
+	Long: `Show the result of the last time the project's servers and services
+were compared with its config, listing anything that was changed or removed
+by hand or isn't running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("drift takes no arguments")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+		resp, err := apiClient.GetProjectDrift(api.GetProjectDriftReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		s := resp.Status
+		if s == nil {
+			fmt.Printf("Not checked yet.\n")
+			return
+		}
+		fmt.Printf("Checked %v.\n", s.Checked.Local().Format("2006-01-02 15:04:05"))
+		if s.Error != "" {
+			fmt.Printf("Check failed: %s\n", s.Error)
+			return
+		}
+		if len(s.Drift) == 0 {
+			fmt.Printf("Everything matches the config.\n")
+			return
+		}
+		for _, d := range s.Drift {
+			fmt.Printf("%s\n", d)
+		}
+		if s.Corrected {
+			if s.CorrectError != "" {
+				fmt.Printf("Correcting failed: %s\n", s.CorrectError)
+			} else {
+				fmt.Printf("Corrected.\n")
+			}
+		}
+	},
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var envSecret bool
+
+func init() {
+	envSetCmd.Flags().BoolVarP(&envSecret, "secret", "s", false,
+		"encrypt the value and never show it again")
+	envCmd.AddCommand(envListCmd)
+	envCmd.AddCommand(envSetCmd)
+	envCmd.AddCommand(envUnsetCmd)
+	RootCmd.AddCommand(envCmd)
+}
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "manage the environment of a project's Horizon servers",
+	Long: `Manage the environment variables a project's Horizon servers run
+with, such as Horizon's HZ_* options or auth provider secrets.  Horizon is
+restarted whenever they change.`,
+}
+
+var envListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list a project's environment variables",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("env list takes no arguments")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+		resp, err := apiClient.ListEnv(api.ListEnvReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := make([]string, 0, len(resp.Env))
+		for name := range resp.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v := resp.Env[name]
+			if v.Secret {
+				fmt.Printf("%s (secret)\n", name)
+			} else {
+				fmt.Printf("%s=%s\n", name, v.Value)
+			}
+		}
+	},
+}
+
+var envSetCmd = &cobra.Command{
+	Use:   "set NAME VALUE",
+	Short: "set an environment variable",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalf("env set takes a name and a value")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+		_, err := apiClient.SetEnv(api.SetEnvReq{
+			Token:     token,
+			ProjectID: projectID,
+			Name:      args[0],
+			Value:     args[1],
+			Secret:    envSecret,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Set %s; Horizon will restart to pick it up.", args[0])
+	},
+}
+
+var envUnsetCmd = &cobra.Command{
+	Use:   "unset NAME",
+	Short: "remove an environment variable",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("env unset takes exactly one name")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+		_, err := apiClient.UnsetEnv(api.UnsetEnvReq{
+			Token:     token,
+			ProjectID: projectID,
+			Name:      args[0],
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Unset %s.", args[0])
+	},
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(operationsCmd)
+}
+
+var operationsCmd = &cobra.Command{
+	Use:   "operations",
+	Short: "show what was created for a project",
+	Long: `Show the project's most recent operations that created servers,
+services or disks, newest first, with the steps each one took.  Operations
+that failed part way were rolled back, deleting what they had created.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("operations takes no arguments")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+		resp, err := apiClient.ListOperations(api.ListOperationsReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(resp.Operations) == 0 {
+			fmt.Printf("No operations.\n")
+			return
+		}
+		for _, op := range resp.Operations {
+			fmt.Printf("%s  %s  %s\n",
+				op.Started.Local().Format("2006-01-02 15:04:05"), op.Name, op.State)
+			if op.Error != "" {
+				fmt.Printf("    %s\n", op.Error)
+			}
+			for _, s := range op.Steps {
+				fmt.Printf("    %-40s %s", s, s.State)
+				if s.Error != "" {
+					fmt.Printf(": %s", s.Error)
+				}
+				fmt.Printf("\n")
+			}
+		}
+	},
+}
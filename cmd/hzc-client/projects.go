@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var deleteYes bool
+
+func init() {
+	deleteCmd.Flags().BoolVarP(&deleteYes, "yes", "y", false,
+		"don't ask for confirmation")
+	RootCmd.AddCommand(createCmd)
+	RootCmd.AddCommand(deleteCmd)
+	RootCmd.AddCommand(transferCmd)
+	RootCmd.AddCommand(renameCmd)
+}
+
+// connect gets an API token and client, exiting on failure.
+func connect() (string, *api.Client) {
+	token, err := getToken()
+	if err != nil {
+		log.Fatalf("Couldn't get an API token: %v", err)
+	}
+	apiClient, err := api.NewClient(viper.GetString("api_server"), "")
+	if err != nil {
+		log.Fatalf("Couldn't create API client: %v", err)
+	}
+	return token, apiClient
+}
+
+func mustParseProjectName(name string) types.ProjectID {
+	projectID, err := parseProjectName(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return projectID
+}
+
+// mustConfiguredProject returns the project set with --name or in the
+// config file, which commands that act on the current project use.
+func mustConfiguredProject() types.ProjectID {
+	name := viper.GetString("name")
+	if name == "" {
+		log.Fatalf("No project name specified; set it with --name or in %s.",
+			configFile)
+	}
+	return mustParseProjectName(name)
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create [OWNER/]NAME",
+	Short: "create a project",
+	Long: `Create a new, empty project.  Project names may contain lowercase
+letters, digits and dashes, and must start with a letter.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("create takes exactly one project name")
+		}
+		projectID := mustParseProjectName(args[0])
+		token, apiClient := connect()
+		resp, err := apiClient.CreateProject(api.CreateProjectReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Created %s.", resp.Project.SlashName())
+	},
+}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete [OWNER/]NAME",
+	Short: "delete a project",
+	Long: `Delete a project, along with its database.  Only the owner of a
+project can delete it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("delete takes exactly one project name")
+		}
+		projectID := mustParseProjectName(args[0])
+		if !deleteYes {
+			fmt.Printf("This deletes %s and all of its data.  "+
+				"Type the project name to confirm: ", args[0])
+			answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				log.Fatalf("error reading confirmation: %v", err)
+			}
+			if strings.TrimSpace(answer) != args[0] {
+				log.Fatalf("Not deleting %s.", args[0])
+			}
+		}
+		token, apiClient := connect()
+		_, err := apiClient.DeleteProject(api.DeleteProjectReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Deleting %s.", args[0])
+	},
+}
+
+var transferCmd = &cobra.Command{
+	Use:   "transfer [OWNER/]NAME NEW_OWNER",
+	Short: "give a project to another user",
+	Long: `Make another user the owner of a project.  The project keeps its
+name, domains, database and releases, and its other users keep access.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalf("transfer takes a project name and a new owner")
+		}
+		projectID := mustParseProjectName(args[0])
+		token, apiClient := connect()
+		resp, err := apiClient.TransferProject(api.TransferProjectReq{
+			Token:     token,
+			ProjectID: projectID,
+			NewOwner:  args[1],
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is now %s.", args[0], resp.Project.SlashName())
+	},
+}
+
+var renameCmd = &cobra.Command{
+	Use:   "rename [OWNER/]NAME NEW_NAME",
+	Short: "rename a project",
+	Long: `Give a project a new name.  The project keeps its domains, database
+and releases.  Remember to update the name in your config file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalf("rename takes a project name and a new name")
+		}
+		projectID := mustParseProjectName(args[0])
+		token, apiClient := connect()
+		resp, err := apiClient.RenameProject(api.RenameProjectReq{
+			Token:     token,
+			ProjectID: projectID,
+			NewName:   args[1],
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is now %s.", args[0], resp.Project.SlashName())
+		if viper.GetString("name") == args[0] {
+			log.Printf("Update the name in %s to deploy to it.", configFile)
+		}
+	},
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var listReleases bool
+
+func init() {
+	rollbackCmd.Flags().BoolVarP(&listReleases, "list", "l", false,
+		"list releases instead of rolling back")
+	RootCmd.AddCommand(rollbackCmd)
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [RELEASE]",
+	Short: "serve an earlier deploy of a project",
+	Long: `Serve an earlier release of the project's static files.  With no
+RELEASE, roll back to the release deployed before the one being served.
+The Horizon schema is not rolled back.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			log.Fatalf("rollback takes at most one release")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+
+		resp, err := apiClient.ListReleases(api.ListReleasesReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if listReleases {
+			for _, rel := range resp.Releases {
+				marker := " "
+				if rel.ID == resp.ActiveRelease {
+					marker = "*"
+				}
+				fmt.Printf("%s %s  %s\n", marker, rel.ID,
+					rel.Created.Local().Format(time.RFC1123))
+			}
+			return
+		}
+
+		var target string
+		if len(args) == 1 {
+			target = args[0]
+		} else {
+			for i, rel := range resp.Releases {
+				if rel.ID == resp.ActiveRelease && i+1 < len(resp.Releases) {
+					target = resp.Releases[i+1].ID
+				}
+			}
+			if target == "" {
+				fmt.Println("There is no earlier release to roll back to.")
+				os.Exit(1)
+			}
+		}
+
+		_, err = apiClient.SetActiveRelease(api.SetActiveReleaseReq{
+			Token:     token,
+			ProjectID: projectID,
+			ReleaseID: target,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving release %s.", target)
+	},
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	f := settingsSetCmd.Flags()
+	f.Bool("permissions", false, "enforce Horizon permissions")
+	f.Bool("allow-anonymous", false, "let users connect without logging in")
+	f.Bool("allow-unauthenticated", false, "let clients connect without a token")
+	f.Bool("auto-create-collection", false, "create collections when first used")
+	f.Bool("auto-create-index", false, "create indexes when first used")
+	f.String("token-secret", "", "secret that signs Horizon's auth tokens")
+	f.String("auth-redirect", "", "where to send users after they log in")
+
+	settingsAuthCmd.AddCommand(settingsAuthAddCmd)
+	settingsAuthCmd.AddCommand(settingsAuthRemoveCmd)
+	settingsCmd.AddCommand(settingsShowCmd)
+	settingsCmd.AddCommand(settingsSetCmd)
+	settingsCmd.AddCommand(settingsAuthCmd)
+	RootCmd.AddCommand(settingsCmd)
+}
+
+// updateSettings applies f to the project's Horizon settings.  Secrets
+// that come back empty from the server are kept as they are.
+func updateSettings(f func(hs *types.HorizonSettings)) {
+	projectID := mustConfiguredProject()
+	token, apiClient := connect()
+	resp, err := apiClient.GetHorizonSettings(api.GetHorizonSettingsReq{
+		Token:     token,
+		ProjectID: projectID,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	f(&resp.Settings)
+	_, err = apiClient.SetHorizonSettings(api.SetHorizonSettingsReq{
+		Token:     token,
+		ProjectID: projectID,
+		Settings:  resp.Settings,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Updated settings; Horizon will restart to pick them up.")
+}
+
+var settingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "manage the options a project's Horizon servers run with",
+}
+
+var settingsShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "show a project's Horizon settings",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("settings show takes no arguments")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+		resp, err := apiClient.GetHorizonSettings(api.GetHorizonSettingsReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		hs := resp.Settings
+		fmt.Printf("permissions:            %v\n", hs.Permissions)
+		fmt.Printf("allow-anonymous:        %v\n", hs.AllowAnonymous)
+		fmt.Printf("allow-unauthenticated:  %v\n", hs.AllowUnauthenticated)
+		fmt.Printf("auto-create-collection: %v\n", hs.AutoCreateCollection)
+		fmt.Printf("auto-create-index:      %v\n", hs.AutoCreateIndex)
+		if hs.AuthRedirect != "" {
+			fmt.Printf("auth-redirect:          %v\n", hs.AuthRedirect)
+		}
+		for _, p := range hs.AuthProviders {
+			fmt.Printf("auth provider:          %v (ID %v)\n", p.Name, p.ID)
+		}
+		v := resp.Version
+		if v.Desired != v.Applied {
+			if v.Desired == v.Error {
+				fmt.Printf("Couldn't apply these settings: %v\n", v.LastError)
+			} else if v.Retrying() {
+				fmt.Printf("Applying these settings has failed %d times "+
+					"and will be retried: %v\n", v.Attempts, v.LastError)
+			} else {
+				fmt.Printf("These settings haven't been applied yet.\n")
+			}
+		}
+	},
+}
+
+var settingsSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "change a project's Horizon settings",
+	Long: `Change the settings given as flags, leaving the rest alone.  For
+example, to stop users connecting without logging in:
+
+  hzc-client settings set --allow-anonymous=false`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("settings set takes only flags")
+		}
+		f := cmd.Flags()
+		if f.NFlag() == 0 {
+			log.Fatalf("No settings given; see `settings set --help`.")
+		}
+		updateSettings(func(hs *types.HorizonSettings) {
+			bools := map[string]*bool{
+				"permissions":            &hs.Permissions,
+				"allow-anonymous":        &hs.AllowAnonymous,
+				"allow-unauthenticated":  &hs.AllowUnauthenticated,
+				"auto-create-collection": &hs.AutoCreateCollection,
+				"auto-create-index":      &hs.AutoCreateIndex,
+			}
+			for name, b := range bools {
+				if f.Changed(name) {
+					*b, _ = f.GetBool(name)
+				}
+			}
+			if f.Changed("token-secret") {
+				hs.TokenSecret, _ = f.GetString("token-secret")
+			}
+			if f.Changed("auth-redirect") {
+				hs.AuthRedirect, _ = f.GetString("auth-redirect")
+			}
+		})
+	},
+}
+
+var settingsAuthCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "manage the OAuth providers users can log in with",
+	Long: "Manage the OAuth providers users can log in with.  Supported " +
+		"providers are " + strings.Join(types.AuthProviders, ", ") + ".",
+}
+
+var settingsAuthAddCmd = &cobra.Command{
+	Use:   "add PROVIDER CLIENT_ID CLIENT_SECRET",
+	Short: "add or update an OAuth provider",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			log.Fatalf("settings auth add takes a provider, client ID and secret")
+		}
+		provider := types.AuthProvider{
+			Name:   strings.ToLower(args[0]),
+			ID:     args[1],
+			Secret: args[2],
+		}
+		updateSettings(func(hs *types.HorizonSettings) {
+			for i, p := range hs.AuthProviders {
+				if p.Name == provider.Name {
+					hs.AuthProviders[i] = provider
+					return
+				}
+			}
+			hs.AuthProviders = append(hs.AuthProviders, provider)
+		})
+	},
+}
+
+var settingsAuthRemoveCmd = &cobra.Command{
+	Use:   "remove PROVIDER",
+	Short: "remove an OAuth provider",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("settings auth remove takes exactly one provider")
+		}
+		name := strings.ToLower(args[0])
+		updateSettings(func(hs *types.HorizonSettings) {
+			var kept []types.AuthProvider
+			for _, p := range hs.AuthProviders {
+				if p.Name != name {
+					kept = append(kept, p)
+				}
+			}
+			if len(kept) == len(hs.AuthProviders) {
+				log.Fatalf("%s isn't set up", name)
+			}
+			hs.AuthProviders = kept
+		})
+	},
+}
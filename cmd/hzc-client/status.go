@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+}
+
+const timeFormat = "2006-01-02 15:04:05"
+
+// versionState describes how far a config version has been applied.
+func versionState(v types.ConfigVersion) string {
+	switch {
+	case v.Desired == v.Applied:
+		return "applied"
+	case v.Desired == v.Error:
+		return "failed: " + v.LastError
+	case v.Retrying():
+		return fmt.Sprintf("failed %d times, retrying at %v: %v",
+			v.Attempts, v.NextAttempt.Local().Format(timeFormat), v.LastError)
+	default:
+		return "being applied"
+	}
+}
+
+func printComponent(c types.ComponentStatus) {
+	ready := 0
+	for _, pod := range c.Pods {
+		if pod.Ready {
+			ready++
+		}
+	}
+	fmt.Printf("%s: %d/%d ready\n", c.Object, ready, c.Replicas)
+	if c.Error != "" {
+		fmt.Printf("  error: %s\n", c.Error)
+	}
+	if d := c.Disk; d != nil {
+		fmt.Printf("  disk %s: %d GB", d.Name, d.SizeGB)
+		if d.UsedBytes+d.AvailableBytes > 0 {
+			fmt.Printf(", %.1f GB used, %.1f GB available",
+				float64(d.UsedBytes)/(1<<30), float64(d.AvailableBytes)/(1<<30))
+		}
+		fmt.Printf("\n")
+	}
+	for _, pod := range c.Pods {
+		state := pod.Phase
+		if pod.Terminating {
+			state = "Terminating"
+		} else if pod.Waiting != "" {
+			state += " (" + pod.Waiting + ")"
+		}
+		readiness := "not ready"
+		if pod.Ready {
+			readiness = "ready"
+		}
+		fmt.Printf("  pod %s: %s, %s, %d restarts\n",
+			pod.Name, state, readiness, pod.Restarts)
+		for _, e := range pod.Events {
+			fmt.Printf("    %s %s", e.Time.Local().Format(timeFormat), e.Reason)
+			if e.Count > 1 {
+				fmt.Printf(" (x%d)", e.Count)
+			}
+			fmt.Printf(": %s\n", e.Message)
+		}
+	}
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the state of a project's servers",
+	Long: `Show whether the project's config has been applied, which release
+is being served, and the state of its RethinkDB and Horizon servers: their
+pods, how many are ready, how often they have restarted, their most recent
+events, and how full the RethinkDB disks are.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("status takes no arguments")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+		resp, err := apiClient.GetProjectStatus(api.GetProjectStatusReq{
+			Token:     token,
+			ProjectID: projectID,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Kube config: %s\n", versionState(resp.KubeConfigVersion))
+		fmt.Printf("Horizon config: %s\n", versionState(resp.HorizonConfigVersion))
+		if rel := resp.ActiveRelease; rel != nil {
+			fmt.Printf("Release: %s, deployed %s\n",
+				rel.ID, rel.Created.Local().Format(timeFormat))
+		} else {
+			fmt.Printf("Release: none\n")
+		}
+		if resp.Error != "" {
+			fmt.Printf("Couldn't get server status: %s\n", resp.Error)
+		}
+		for _, c := range resp.Components {
+			fmt.Printf("\n")
+			printComponent(c)
+		}
+	},
+}
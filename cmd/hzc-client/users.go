@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/ssh"
+	"github.com/spf13/cobra"
+)
+
+var keysUser string
+
+func init() {
+	usersCmd.AddCommand(usersListCmd)
+	usersCmd.AddCommand(usersAddCmd)
+	usersCmd.AddCommand(usersRemoveCmd)
+	RootCmd.AddCommand(usersCmd)
+
+	keysCmd.PersistentFlags().StringVarP(&keysUser, "user", "u", "",
+		"account to manage, if your key is on more than one")
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysAddCmd)
+	keysCmd.AddCommand(keysRemoveCmd)
+	RootCmd.AddCommand(keysCmd)
+}
+
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "manage who can deploy to a project",
+}
+
+var usersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the users of your projects",
+	Run: func(cmd *cobra.Command, args []string) {
+		token, apiClient := connect()
+		resp, err := apiClient.GetProjectsByToken(api.GetProjectsByTokenReq{token})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range resp.Projects {
+			fmt.Printf("%s: %s\n", p.SlashName(), strings.Join(p.Users, ", "))
+		}
+	},
+}
+
+var usersAddCmd = &cobra.Command{
+	Use:   "add [OWNER/]NAME USER",
+	Short: "let another user deploy to a project",
+	Long: `Let another user deploy to a project.  Only the owner of a project
+can add users to it.  The user can deploy once they get a new token.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalf("users add takes a project name and a user")
+		}
+		projectID := mustParseProjectName(args[0])
+		token, apiClient := connect()
+		resp, err := apiClient.AddProjectUser(api.AddProjectUserReq{
+			Token:     token,
+			ProjectID: projectID,
+			User:      args[1],
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Users of %s: %s", args[0], strings.Join(resp.Users, ", "))
+	},
+}
+
+var usersRemoveCmd = &cobra.Command{
+	Use:   "remove [OWNER/]NAME USER",
+	Short: "stop a user from deploying to a project",
+	Long: `Stop a user from deploying to a project.  The owner can remove
+anyone else, and other users can remove themselves.  Tokens the user
+already has stay valid until they expire.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			log.Fatalf("users remove takes a project name and a user")
+		}
+		projectID := mustParseProjectName(args[0])
+		token, apiClient := connect()
+		resp, err := apiClient.RemoveProjectUser(api.RemoveProjectUserReq{
+			Token:     token,
+			ProjectID: projectID,
+			User:      args[1],
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Users of %s: %s", args[0], strings.Join(resp.Users, ", "))
+	},
+}
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "manage the SSH keys on your account",
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the SSH keys on your account",
+	Run: func(cmd *cobra.Command, args []string) {
+		token, apiClient := connect()
+		resp, err := apiClient.ListUserKeys(api.ListUserKeysReq{
+			Token: token,
+			User:  keysUser,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Keys for %s:\n", resp.User)
+		for _, key := range resp.Keys {
+			fp, err := ssh.Fingerprint(key)
+			if err != nil {
+				fp = "(invalid key)"
+			}
+			fmt.Printf("  %s\n", fp)
+		}
+	},
+}
+
+// readKey reads a public key from a file in authorized_keys format, such
+// as ~/.ssh/id_rsa.pub, or from stdin if path is "-".
+func readKey(path string) string {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		log.Fatalf("Couldn't read key: %v", err)
+	}
+	key, err := ssh.KeyFromAuthorizedKey(string(data))
+	if err != nil {
+		log.Fatalf("Couldn't parse key in %s: %v", path, err)
+	}
+	return key
+}
+
+var keysAddCmd = &cobra.Command{
+	Use:   "add KEYFILE",
+	Short: "add an SSH key to your account",
+	Long: `Add an SSH public key, such as ~/.ssh/id_rsa.pub, to your account.
+Use - to read the key from stdin.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("keys add takes one key file")
+		}
+		key := readKey(args[0])
+		token, apiClient := connect()
+		_, err := apiClient.AddUserKey(api.AddUserKeyReq{
+			Token:     token,
+			User:      keysUser,
+			PublicKey: key,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fp, _ := ssh.Fingerprint(key)
+		log.Printf("Added key %s.", fp)
+	},
+}
+
+var keysRemoveCmd = &cobra.Command{
+	Use:   "remove KEYFILE|FINGERPRINT",
+	Short: "revoke an SSH key",
+	Long: `Remove an SSH public key from your account, given either the key
+file or its fingerprint as shown by 'keys list'.  Tokens already issued
+for the key stay valid until they expire.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("keys remove takes one key file or fingerprint")
+		}
+		token, apiClient := connect()
+
+		var key string
+		if _, err := os.Stat(args[0]); err == nil || args[0] == "-" {
+			key = readKey(args[0])
+		} else {
+			resp, err := apiClient.ListUserKeys(api.ListUserKeysReq{
+				Token: token,
+				User:  keysUser,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, k := range resp.Keys {
+				if fp, err := ssh.Fingerprint(k); err == nil && fp == args[0] {
+					key = k
+				}
+			}
+			if key == "" {
+				log.Fatalf("%s has no key with fingerprint %s.", resp.User, args[0])
+			}
+		}
+
+		_, err := apiClient.RemoveUserKey(api.RemoveUserKeyReq{
+			Token:     token,
+			User:      keysUser,
+			PublicKey: key,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fp, _ := ssh.Fingerprint(key)
+		log.Printf("Removed key %s.", fp)
+	},
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	upgradeHorizon   string
+	upgradeRethinkDB string
+)
+
+func init() {
+	upgradeCmd.Flags().StringVar(&upgradeHorizon, "horizon", "",
+		"Horizon version to run")
+	upgradeCmd.Flags().StringVar(&upgradeRethinkDB, "rethinkdb", "",
+		"RethinkDB version to run")
+	RootCmd.AddCommand(versionsCmd)
+	RootCmd.AddCommand(upgradeCmd)
+}
+
+var versionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "list the versions of Horizon and RethinkDB projects can run",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("versions takes no arguments")
+		}
+		token, apiClient := connect()
+		resp, err := apiClient.ListVersions(api.ListVersionsReq{Token: token})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i, v := range resp.Horizon {
+			if i == 0 {
+				v += " (default)"
+			}
+			fmt.Printf("horizon   %s\n", v)
+		}
+		for i, v := range resp.RethinkDB {
+			if i == 0 {
+				v += " (default)"
+			}
+			fmt.Printf("rethinkdb %s\n", v)
+		}
+	},
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "change the versions of Horizon and RethinkDB a project runs",
+	Long: `Change the versions of Horizon and RethinkDB the project runs.  Its
+servers are replaced one at a time.  If the new versions don't come up,
+the project is put back on its old versions the same way.  See "versions"
+for what's available.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 {
+			log.Fatalf("upgrade takes only flags")
+		}
+		if upgradeHorizon == "" && upgradeRethinkDB == "" {
+			log.Fatalf("Give a version with --horizon or --rethinkdb.")
+		}
+		projectID := mustConfiguredProject()
+		token, apiClient := connect()
+
+		// Keep whichever version isn't being changed.
+		resp, err := apiClient.GetProjectsByToken(
+			api.GetProjectsByTokenReq{Token: token})
+		if err != nil {
+			log.Fatal(err)
+		}
+		var project *types.Project
+		for _, p := range resp.Projects {
+			if p.Name() == projectID.Name() &&
+				(projectID.Owner() == "" || p.Owner() == projectID.Owner()) {
+				if project != nil {
+					log.Fatalf("More than one project is named %s; "+
+						"use OWNER/NAME.", projectID.Name())
+				}
+				project = p
+			}
+		}
+		if project == nil {
+			log.Fatalf("You don't have access to a project named %s.",
+				projectID.Name())
+		}
+
+		rt := project.Runtime
+		if upgradeHorizon != "" {
+			rt.Horizon = upgradeHorizon
+		}
+		if upgradeRethinkDB != "" {
+			rt.RethinkDB = upgradeRethinkDB
+		}
+		_, err = apiClient.SetRuntime(api.SetRuntimeReq{
+			Token:     token,
+			ProjectID: project.ID,
+			Runtime:   rt,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Upgrading %s.", project.SlashName())
+	},
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/encryptio/go-meetup"
+	"golang.org/x/crypto/acme"
+
+	"github.com/rethinkdb/horizon-cloud/internal/api"
+	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// Certificates for every domain are obtained from an ACME certificate
+// authority, using HTTP-01 challenges answered by whichever hzc-http server
+// the authority reaches.  Certificates, challenges and the account key are
+// stored through the API server, so every hzc-http server shares them.
+//
+// To try this against a local test CA such as Pebble
+// (https://github.com/letsencrypt/pebble), run hzc-http with
+// --acme_directory https://localhost:14000/dir --acme_insecure, and
+// configure Pebble to validate HTTP-01 challenges on hzc-http's port.
+
+const (
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+	certCheckInterval = 10 * time.Minute
+	certLockDuration  = 10 * time.Minute
+	certOrderTimeout  = 5 * time.Minute
+	// certRetryDelay keeps failing domains from using up the authority's
+	// rate limits.
+	certRetryDelay = time.Hour
+)
+
+var errNoCertificate = errors.New("no certificate for that domain yet")
+
+type certManager struct {
+	conf        *config
+	ctx         *hzhttp.Context
+	client      *acme.Client
+	email       string
+	renewBefore time.Duration
+	// holder identifies this server when locking certificates.
+	holder string
+
+	certCache *meetup.Cache
+	kick      chan struct{}
+	// failed holds when obtaining each certificate last failed.  Only
+	// renewLoop uses it.
+	failed map[string]time.Time
+}
+
+func newCertManager(
+	conf *config, ctx *hzhttp.Context, directoryURL string, email string,
+	insecure bool, renewBefore time.Duration) *certManager {
+
+	ctx = ctx.WithLog(map[string]interface{}{"action": "acme"})
+	client := &acme.Client{DirectoryURL: directoryURL}
+	if insecure {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	var nonce [8]byte
+	rand.Read(nonce[:])
+
+	m := &certManager{
+		conf:        conf,
+		ctx:         ctx,
+		client:      client,
+		email:       email,
+		renewBefore: renewBefore,
+		holder:      hostname + "-" + hex.EncodeToString(nonce[:]),
+		kick:        make(chan struct{}, 1),
+		failed:      make(map[string]time.Time),
+	}
+	m.certCache = meetup.New(meetup.Options{
+		Get: func(domain string) (interface{}, error) {
+			resp, err := conf.APIClient.GetCertificate(api.GetCertificateReq{
+				Domain: domain,
+			})
+			if err != nil {
+				ctx.Error("API server gave no certificate for `%v` (%v)", domain, err)
+				return nil, err
+			}
+			if resp.Certificate == nil {
+				return nil, errNoCertificate
+			}
+			cert, err := tls.X509KeyPair(
+				resp.Certificate.CertPEM, resp.Certificate.KeyPEM)
+			if err != nil {
+				ctx.Error("Bad certificate for `%v`: %v", domain, err)
+				return nil, err
+			}
+			return &cert, nil
+		},
+		Concurrency: 20,
+		ErrorAge:    time.Minute,
+		// Certificates are renewed long before they expire, so there's no
+		// hurry to pick up new ones.
+		RevalidateAge: time.Hour,
+		MaxSize:       10000,
+	})
+	return m
+}
+
+// GetCertificate is for tls.Config.GetCertificate.
+func (m *certManager) GetCertificate(
+	hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	domain := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if domain == "" {
+		return nil, errors.New("client didn't send a server name")
+	}
+	v, err := m.certCache.Get(domain)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*tls.Certificate), nil
+}
+
+// serveChallenge answers an HTTP-01 challenge for the certificate
+// authority.
+func (m *certManager) serveChallenge(
+	ctx *hzhttp.Context, w http.ResponseWriter, token string) {
+	resp, err := m.conf.APIClient.GetACMEChallenge(api.GetACMEChallengeReq{
+		Token: token,
+	})
+	if err != nil {
+		ctx.Error("Couldn't get ACME challenge %v: %v", token, err)
+		http.Error(w, "Couldn't get challenge", http.StatusInternalServerError)
+		return
+	}
+	if resp.KeyAuth == "" {
+		http.Error(w, "no such challenge", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(resp.KeyAuth))
+}
+
+// Kick makes the renewal loop check for missing certificates now, rather
+// than waiting for its next pass.  It's called when domains change.
+func (m *certManager) Kick() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (m *certManager) renewLoop() {
+	for {
+		m.renewAll()
+		select {
+		case <-time.After(certCheckInterval):
+		case <-m.kick:
+		}
+	}
+}
+
+// setupAccount loads or creates the shared account key and registers it
+// with the certificate authority.
+func (m *certManager) setupAccount(ctx context.Context) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := m.conf.APIClient.GetACMEAccount(api.GetACMEAccountReq{
+		Account: types.ACMEAccount{
+			DirectoryURL: m.client.DirectoryURL,
+			KeyPEM:       pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(resp.Account.KeyPEM)
+	if block == nil {
+		return errors.New("stored ACME account key is not PEM")
+	}
+	key, err = x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	m.client.Key = key
+	acct := &acme.Account{}
+	if m.email != "" {
+		acct.Contact = []string{"mailto:" + m.email}
+	}
+	_, err = m.client.Register(ctx, acct, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		m.client.Key = nil
+		return err
+	}
+	return nil
+}
+
+// renewAll obtains certificates for domains that have none or whose
+// certificates expire within renewBefore.
+func (m *certManager) renewAll() {
+	if m.client.Key == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), certOrderTimeout)
+		err := m.setupAccount(ctx)
+		cancel()
+		if err != nil {
+			m.ctx.Error("Couldn't set up ACME account: %v", err)
+			return
+		}
+	}
+
+	resp, err := m.conf.APIClient.ListCertificates(api.ListCertificatesReq{})
+	if err != nil {
+		m.ctx.Error("Couldn't list certificates: %v", err)
+		return
+	}
+	now := time.Now()
+	for domain, notAfter := range resp.Domains {
+		if notAfter.Sub(now) > m.renewBefore {
+			continue
+		}
+		failedAt, failed := m.failed[domain]
+		if failed && now.Sub(failedAt) < certRetryDelay {
+			continue
+		}
+		ctx := m.ctx.WithLog(map[string]interface{}{"domain": domain})
+
+		lock, err := m.conf.APIClient.LockCertificate(api.LockCertificateReq{
+			Domain:   domain,
+			Holder:   m.holder,
+			Duration: certLockDuration,
+		})
+		if err != nil {
+			ctx.Error("Couldn't lock certificate: %v", err)
+			continue
+		}
+		if !lock.Locked {
+			ctx.Info("another server is obtaining a certificate")
+			continue
+		}
+
+		ctx.Info("obtaining certificate (old one expires %v)", notAfter)
+		cert, err := m.obtain(domain)
+		if err == nil {
+			_, err = m.conf.APIClient.SetCertificate(
+				api.SetCertificateReq{Certificate: *cert})
+		}
+		if err != nil {
+			ctx.Error("Couldn't obtain certificate: %v", err)
+			m.failed[domain] = time.Now()
+			continue
+		}
+		delete(m.failed, domain)
+		ctx.Info("obtained certificate expiring %v", cert.NotAfter)
+	}
+}
+
+// obtain gets a new certificate for domain.
+func (m *certManager) obtain(domain string) (*types.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), certOrderTimeout)
+	defer cancel()
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, err
+	}
+	for _, authzURL := range order.AuthzURLs {
+		err := m.authorize(ctx, authzURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("certificate authority returned no certificates")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM,
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Certificate{
+		Domain:   domain,
+		CertPEM:  certPEM,
+		KeyPEM:   pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		NotAfter: leaf.NotAfter,
+	}, nil
+}
+
+// authorize completes an HTTP-01 challenge for one authorization of an
+// order.
+func (m *certManager) authorize(ctx context.Context, authzURL string) error {
+	authz, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no http-01 challenge offered for %v",
+			authz.Identifier.Value)
+	}
+
+	keyAuth, err := m.client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+	_, err = m.conf.APIClient.SetACMEChallenge(api.SetACMEChallengeReq{
+		Token:   chal.Token,
+		KeyAuth: keyAuth,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, err := m.conf.APIClient.SetACMEChallenge(api.SetACMEChallengeReq{
+			Token: chal.Token,
+		})
+		m.ctx.MaybeError(err)
+	}()
+
+	_, err = m.client.Accept(ctx, chal)
+	if err != nil {
+		return err
+	}
+	_, err = m.client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/encryptio/go-meetup"
@@ -13,6 +16,12 @@ import (
 	"github.com/rethinkdb/horizon-cloud/internal/types"
 )
 
+// defaultStorageURL is used for ProjectAddrs from API servers that predate
+// ProjectAddr.StorageURL.
+const defaultStorageURL = "https://storage.googleapis.com/"
+
+const domainWatchRetry = 5 * time.Second
+
 type NoHostMappingError struct {
 	Host string
 }
@@ -22,21 +31,30 @@ func (e *NoHostMappingError) Error() string {
 }
 
 type Handler struct {
-	conf        *config
-	targetCache *meetup.Cache
-	ctx         *hzhttp.Context
-	proxy       *httputil.ReverseProxy
+	conf          *config
+	targetCache   *meetup.Cache
+	manifestCache *meetup.Cache
+	ctx           *hzhttp.Context
+	proxy         *httputil.ReverseProxy
+
+	// targetCache is keyed by targetKey, so that bumping a generation
+	// drops the cached ProjectAddrs it covers.
+	genMu    sync.Mutex
+	gen      int
+	hostGens map[string]int
 }
 
 func NewHandler(conf *config, ctx *hzhttp.Context) *Handler {
 	h := &Handler{
-		conf: conf,
-		ctx:  ctx,
+		conf:     conf,
+		ctx:      ctx,
+		hostGens: make(map[string]int),
 		proxy: &httputil.ReverseProxy{
 			Director: func(r *http.Request) {},
 		},
 		targetCache: meetup.New(meetup.Options{
-			Get: func(host string) (interface{}, error) {
+			Get: func(key string) (interface{}, error) {
+				host := strings.SplitN(key, ":", 3)[2]
 				resp, err := conf.APIClient.GetProjectAddrByDomain(api.GetProjectAddrByDomainReq{
 					Domain: host,
 				})
@@ -49,24 +67,126 @@ func NewHandler(conf *config, ctx *hzhttp.Context) *Handler {
 				}
 				return resp.ProjectAddr, nil
 			},
-			Concurrency:   20,
-			ErrorAge:      time.Second,
+			Concurrency: 20,
+			ErrorAge:    time.Second,
+			// Changes are normally picked up by watchDomains; this is
+			// in case it misses some.
 			RevalidateAge: time.Minute,
 			MaxSize:       100000, // very roughly 64MB of stuff (TODO: more precisely derive this)
 		}),
+		// Releases never change, so manifests only leave the cache when it
+		// fills up.
+		manifestCache: meetup.New(meetup.Options{
+			Get: func(releaseID string) (interface{}, error) {
+				resp, err := conf.APIClient.GetReleaseManifest(api.GetReleaseManifestReq{
+					ReleaseID: releaseID,
+				})
+				if err != nil {
+					ctx.Error("API server gave no manifest for release `%v` (%v)",
+						releaseID, err)
+					return nil, err
+				}
+				return resp.Files, nil
+			},
+			Concurrency: 20,
+			ErrorAge:    time.Second,
+			MaxSize:     1000,
+		}),
 	}
 
+	go h.watchDomains()
 	return h
 }
 
+func (h *Handler) targetKey(host string) string {
+	h.genMu.Lock()
+	defer h.genMu.Unlock()
+	return fmt.Sprintf("%d:%d:%s", h.gen, h.hostGens[host], host)
+}
+
+func (h *Handler) invalidateHost(host string) {
+	h.genMu.Lock()
+	defer h.genMu.Unlock()
+	h.hostGens[host]++
+}
+
+func (h *Handler) invalidateAll() {
+	h.genMu.Lock()
+	defer h.genMu.Unlock()
+	h.gen++
+	h.hostGens = make(map[string]int)
+}
+
+// watchDomains invalidates cached targets as the API server reports
+// changes to them, reconnecting whenever the stream breaks.  Everything is
+// invalidated each time it (re)connects, since changes may have been
+// missed in between.
+func (h *Handler) watchDomains() {
+	for {
+		first := true
+		err := h.conf.APIClient.WatchDomains(api.WatchDomainsReq{},
+			func(c api.DomainChange) bool {
+				if first {
+					first = false
+					h.invalidateAll()
+				} else if c.Domain != "" {
+					h.invalidateHost(c.Domain)
+				}
+				if h.conf.Certs != nil {
+					h.conf.Certs.Kick()
+				}
+				return true
+			})
+		h.ctx.Error("Lost domain watch (%v), reconnecting", err)
+		time.Sleep(domainWatchRetry)
+	}
+}
+
 func (h *Handler) getCachedTarget(host string) (*types.ProjectAddr, error) {
-	v, err := h.targetCache.Get(host)
+	v, err := h.targetCache.Get(h.targetKey(host))
 	if err != nil {
 		return nil, err
 	}
 	return v.(*types.ProjectAddr), nil
 }
 
+func (h *Handler) getCachedManifest(
+	releaseID string) (map[string]types.ReleaseFile, error) {
+	v, err := h.manifestCache.Get(releaseID)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]types.ReleaseFile), nil
+}
+
+// contentTypeWriter replaces the Content-Type of a successful response.
+type contentTypeWriter struct {
+	http.ResponseWriter
+	contentType string
+}
+
+func (w *contentTypeWriter) WriteHeader(code int) {
+	if code == http.StatusOK {
+		w.Header().Set("Content-Type", w.contentType)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// hostInPath serves requests that come straight from clients rather than
+// through the front-end proxies, which put the host at the start of the
+// path.
+func hostInPath(h hzhttp.Handler) hzhttp.Handler {
+	return hzhttp.HandlerFunc(func(
+		ctx *hzhttp.Context, w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		r.URL.Path = "/" + strings.ToLower(host) + r.URL.Path
+		h.ServeHTTPContext(ctx, w, r)
+	})
+}
+
 func (h *Handler) ServeHTTPContext(
 	ctx *hzhttp.Context, w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/")
@@ -77,6 +197,13 @@ func (h *Handler) ServeHTTPContext(
 	}
 	host := path[:slashIndex]
 	r.URL.Path = path[slashIndex:]
+
+	if h.conf.Certs != nil && strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+		h.conf.Certs.serveChallenge(ctx, w,
+			strings.TrimPrefix(r.URL.Path, acmeChallengePrefix))
+		return
+	}
+
 	target, err := h.getCachedTarget(host)
 	if err != nil {
 		if _, ok := err.(*NoHostMappingError); ok {
@@ -105,12 +232,43 @@ func (h *Handler) ServeHTTPContext(
 		return
 	}
 
-	// All other requests proxied with HTTP to GCS
-	r.URL.Scheme = "https"
-	r.URL.Host = "storage.googleapis.com"
-	r.URL.Path = target.GCSPrefix + strings.TrimPrefix(r.URL.Path, "/")
-	if strings.HasSuffix(r.URL.Path, "/") {
-		r.URL.Path += "index.html"
+	// All other requests proxied with HTTP to the object store
+	storageURL := target.StorageURL
+	if storageURL == "" {
+		storageURL = defaultStorageURL
+	}
+	base, err := url.Parse(storageURL)
+	if err != nil {
+		ctx.Error("Bad storage URL %#v for %s: %v", storageURL, host, err)
+		http.Error(w, "Couldn't get proxy information for "+host,
+			http.StatusInternalServerError)
+		return
+	}
+	filePath := strings.TrimPrefix(r.URL.Path, "/")
+	if filePath == "" || strings.HasSuffix(filePath, "/") {
+		filePath += "index.html"
+	}
+	if target.ReleaseID != "" {
+		files, err := h.getCachedManifest(target.ReleaseID)
+		if err != nil {
+			ctx.Error("Couldn't get manifest for %s: %v", host, err)
+			http.Error(w, "Couldn't get proxy information for "+host,
+				http.StatusInternalServerError)
+			return
+		}
+		file, ok := files[filePath]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// The blob may have been uploaded with another file's content type.
+		w = &contentTypeWriter{w, file.ContentType}
+		filePath = types.BlobName(file.MD5)
 	}
+	r.URL.Scheme = base.Scheme
+	r.URL.Host = base.Host
+	r.Host = base.Host
+	r.URL.Path = base.Path + target.GCSPrefix + filePath
+	r.URL.RawPath = ""
 	h.proxy.ServeHTTP(w, r)
 }
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/rethinkdb/horizon-cloud/internal/api"
 	"github.com/rethinkdb/horizon-cloud/internal/hzhttp"
 	"github.com/rethinkdb/horizon-cloud/internal/hzlog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
 )
 
 func main() {
@@ -22,6 +26,8 @@ func main() {
 
 type config struct {
 	APIClient *api.Client
+	// Certs is nil unless TLS is enabled.
+	Certs *certManager
 }
 
 var cfgFile string
@@ -32,6 +38,20 @@ func init() {
 
 	pf.StringP("listen", "l", ":80", "Address to listen for HTTP connections on.")
 	pf.StringP("api_server", "a", "http://api-server:8000", "API server base URL.")
+	pf.String("http_secret", "",
+		"Location of the secret for the API's certificate endpoints, which is needed for TLS.")
+
+	pf.String("tls_listen", "",
+		"Address to listen for HTTPS connections on, with certificates from ACME.  "+
+			"If empty, only HTTP is served.")
+	pf.String("acme_directory", acme.LetsEncryptURL,
+		"ACME directory URL of the certificate authority.")
+	pf.String("acme_email", "",
+		"Contact address to register with the certificate authority.")
+	pf.Bool("acme_insecure", false,
+		"Don't verify the certificate authority's certificate, for testing.")
+	pf.Duration("acme_renew_before", 30*24*time.Hour,
+		"How long before they expire to renew certificates.")
 
 	viper.BindPFlags(pf)
 }
@@ -60,12 +80,47 @@ var RootCmd = &cobra.Command{
 
 		baseCtx := hzhttp.NewContext(logger)
 
-		conf.APIClient, err = api.NewClient(viper.GetString("api_server"), "")
+		httpSecret := ""
+		if path := viper.GetString("http_secret"); path != "" {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Fatal("Unable to read secret file: ", err)
+			}
+			httpSecret = string(data)
+		}
+
+		conf.APIClient, err = api.NewClient(viper.GetString("api_server"), httpSecret)
 		if err != nil {
 			log.Fatalf("Couldn't create API client: %v", err)
 		}
 
+		tlsListen := viper.GetString("tls_listen")
+		if tlsListen != "" {
+			if httpSecret == "" {
+				log.Fatal("TLS needs the secret for the API's certificate endpoints")
+			}
+			conf.Certs = newCertManager(conf, baseCtx,
+				viper.GetString("acme_directory"),
+				viper.GetString("acme_email"),
+				viper.GetBool("acme_insecure"),
+				viper.GetDuration("acme_renew_before"))
+			go conf.Certs.renewLoop()
+		}
+
 		var handler hzhttp.Handler = NewHandler(conf, baseCtx)
+
+		if tlsListen != "" {
+			tlsServer := &http.Server{
+				Addr: tlsListen,
+				Handler: hzhttp.BaseContext(baseCtx,
+					hzhttp.LogHTTPRequests(hostInPath(handler))),
+				TLSConfig: &tls.Config{GetCertificate: conf.Certs.GetCertificate},
+			}
+			go func() {
+				log.Fatal(tlsServer.ListenAndServeTLS("", ""))
+			}()
+		}
+
 		handler = hzhttp.LogHTTPRequests(handler)
 
 		plainHandler := hzhttp.BaseContext(baseCtx, handler)
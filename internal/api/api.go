@@ -2,6 +2,8 @@ package api
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/rethinkdb/horizon-cloud/internal/ssh"
 	"github.com/rethinkdb/horizon-cloud/internal/types"
@@ -67,6 +69,187 @@ type GetProjectAddrByDomainResp struct {
 	ProjectAddr *types.ProjectAddr
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// GetReleaseManifest
+
+var GetReleaseManifestPath = "/v1/releases/getManifest"
+
+type GetReleaseManifestReq struct {
+	ReleaseID string
+}
+
+func (r *GetReleaseManifestReq) Validate() error {
+	if r.ReleaseID == "" {
+		return errors.New("ReleaseID must be set")
+	}
+	return nil
+}
+
+type GetReleaseManifestResp struct {
+	Files map[string]types.ReleaseFile
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListCertificates
+
+var ListCertificatesPath = "/v1/certificates/list"
+
+type ListCertificatesReq struct {
+}
+
+func (r *ListCertificatesReq) Validate() error {
+	return nil
+}
+
+type ListCertificatesResp struct {
+	// Domains maps every domain a project is served on to the time its
+	// certificate expires, which is zero if it has none.
+	Domains map[string]time.Time
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GetCertificate
+
+var GetCertificatePath = "/v1/certificates/get"
+
+type GetCertificateReq struct {
+	Domain string
+}
+
+func (r *GetCertificateReq) Validate() error {
+	return util.ValidateDomainName(r.Domain, "Domain")
+}
+
+type GetCertificateResp struct {
+	// Certificate is nil if the domain has no certificate yet.
+	Certificate *types.Certificate
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SetCertificate
+
+var SetCertificatePath = "/v1/certificates/set"
+
+// SetCertificateReq stores a new certificate and releases the lock on it.
+type SetCertificateReq struct {
+	Certificate types.Certificate
+}
+
+func (r *SetCertificateReq) Validate() error {
+	err := util.ValidateDomainName(r.Certificate.Domain, "Certificate.Domain")
+	if err != nil {
+		return err
+	}
+	if len(r.Certificate.CertPEM) == 0 || len(r.Certificate.KeyPEM) == 0 {
+		return errors.New("Certificate.CertPEM and Certificate.KeyPEM must be set")
+	}
+	return nil
+}
+
+type SetCertificateResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// LockCertificate
+
+var LockCertificatePath = "/v1/certificates/lock"
+
+// MaxCertificateLock is the longest a certificate can be locked for at
+// once.
+const MaxCertificateLock = time.Hour
+
+// LockCertificateReq asks for the lock on a domain's certificate, which
+// Holder needs before obtaining a new one.
+type LockCertificateReq struct {
+	Domain   string
+	Holder   string
+	Duration time.Duration
+}
+
+func (r *LockCertificateReq) Validate() error {
+	err := util.ValidateDomainName(r.Domain, "Domain")
+	if err != nil {
+		return err
+	}
+	if r.Holder == "" {
+		return errors.New("Holder must be set")
+	}
+	if r.Duration <= 0 || r.Duration > MaxCertificateLock {
+		return fmt.Errorf("Duration must be positive and at most %v",
+			MaxCertificateLock)
+	}
+	return nil
+}
+
+type LockCertificateResp struct {
+	Locked bool
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GetACMEChallenge
+
+var GetACMEChallengePath = "/v1/acme/getChallenge"
+
+type GetACMEChallengeReq struct {
+	Token string
+}
+
+func (r *GetACMEChallengeReq) Validate() error {
+	if r.Token == "" {
+		return errors.New("Token must be set")
+	}
+	return nil
+}
+
+type GetACMEChallengeResp struct {
+	// KeyAuth is empty if there's no such challenge.
+	KeyAuth string
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SetACMEChallenge
+
+var SetACMEChallengePath = "/v1/acme/setChallenge"
+
+// SetACMEChallengeReq sets the response to a challenge, or removes the
+// challenge if KeyAuth is empty.
+type SetACMEChallengeReq struct {
+	Token   string
+	KeyAuth string
+}
+
+func (r *SetACMEChallengeReq) Validate() error {
+	if r.Token == "" {
+		return errors.New("Token must be set")
+	}
+	return nil
+}
+
+type SetACMEChallengeResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GetACMEAccount
+
+var GetACMEAccountPath = "/v1/acme/getAccount"
+
+// GetACMEAccountReq gets the account for Account.DirectoryURL, storing
+// Account as that account if there isn't one yet.
+type GetACMEAccountReq struct {
+	Account types.ACMEAccount
+}
+
+func (r *GetACMEAccountReq) Validate() error {
+	if r.Account.DirectoryURL == "" || len(r.Account.KeyPEM) == 0 {
+		return errors.New("Account.DirectoryURL and Account.KeyPEM must be set")
+	}
+	return nil
+}
+
+type GetACMEAccountResp struct {
+	Account *types.ACMEAccount
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // UpdateProjectManifest
 
@@ -103,6 +286,9 @@ func (r *UpdateProjectManifestReq) Validate() error {
 
 type UpdateProjectManifestResp struct {
 	NeededRequests []types.FileUploadRequest
+	// ReleaseID is set once all files are uploaded and the new release
+	// is being served.
+	ReleaseID string `json:",omitempty"`
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -124,3 +310,811 @@ func (r *GetProjectsByTokenReq) Validate() error {
 type GetProjectsByTokenResp struct {
 	Projects []*types.Project
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// CreateProject
+
+var CreateProjectPath = "/v1/projects/create"
+
+type CreateProjectReq struct {
+	Token string
+	// The owner may be left empty if the token is for a single user.
+	ProjectID types.ProjectID
+}
+
+func (r *CreateProjectReq) Validate() error {
+	if r.ProjectID.Owner() != "" {
+		err := util.ValidateUserName(r.ProjectID.Owner())
+		if err != nil {
+			return err
+		}
+	}
+	err := util.ValidateProjectName(r.ProjectID.Name(), "ProjectID")
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type CreateProjectResp struct {
+	Project *types.Project
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// DeleteProject
+
+var DeleteProjectPath = "/v1/projects/delete"
+
+type DeleteProjectReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *DeleteProjectReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type DeleteProjectResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// TransferProject
+
+var TransferProjectPath = "/v1/projects/transfer"
+
+type TransferProjectReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	NewOwner  string
+}
+
+func (r *TransferProjectReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = util.ValidateUserName(r.NewOwner)
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type TransferProjectResp struct {
+	Project *types.Project
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RenameProject
+
+var RenameProjectPath = "/v1/projects/rename"
+
+type RenameProjectReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	NewName   string
+}
+
+func (r *RenameProjectReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = util.ValidateProjectName(r.NewName, "NewName")
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type RenameProjectResp struct {
+	Project *types.Project
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// AddProjectUser
+
+var AddProjectUserPath = "/v1/projects/addUser"
+
+type AddProjectUserReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	User      string
+}
+
+func (r *AddProjectUserReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = util.ValidateUserName(r.User)
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type AddProjectUserResp struct {
+	Users []string
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RemoveProjectUser
+
+var RemoveProjectUserPath = "/v1/projects/removeUser"
+
+type RemoveProjectUserReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	User      string
+}
+
+func (r *RemoveProjectUserReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = util.ValidateUserName(r.User)
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type RemoveProjectUserResp struct {
+	Users []string
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListUserKeys
+
+var ListUserKeysPath = "/v1/users/listKeys"
+
+type ListUserKeysReq struct {
+	Token string
+	// User may be left empty if the token is for a single user.
+	User string
+}
+
+func (r *ListUserKeysReq) Validate() error {
+	if r.User != "" {
+		err := util.ValidateUserName(r.User)
+		if err != nil {
+			return err
+		}
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type ListUserKeysResp struct {
+	User string
+	Keys []string
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// AddUserKey
+
+var AddUserKeyPath = "/v1/users/addKey"
+
+type AddUserKeyReq struct {
+	Token string
+	// User may be left empty if the token is for a single user.
+	User      string
+	PublicKey string
+}
+
+func (r *AddUserKeyReq) Validate() error {
+	if r.User != "" {
+		err := util.ValidateUserName(r.User)
+		if err != nil {
+			return err
+		}
+	}
+	if !ssh.ValidKey(r.PublicKey) {
+		return errors.New("invalid public key format")
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type AddUserKeyResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RemoveUserKey
+
+var RemoveUserKeyPath = "/v1/users/removeKey"
+
+type RemoveUserKeyReq struct {
+	Token string
+	// User may be left empty if the token is for a single user.
+	User      string
+	PublicKey string
+}
+
+func (r *RemoveUserKeyReq) Validate() error {
+	if r.User != "" {
+		err := util.ValidateUserName(r.User)
+		if err != nil {
+			return err
+		}
+	}
+	if !ssh.ValidKey(r.PublicKey) {
+		return errors.New("invalid public key format")
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type RemoveUserKeyResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// AddDomain
+
+var AddDomainPath = "/v1/domains/add"
+
+// AddDomainReq asks to serve a project on a domain.  The first request
+// returns a claim to publish; once it has been published, repeating the
+// request verifies it and starts serving the project on the domain.
+type AddDomainReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	Domain    string
+}
+
+func (r *AddDomainReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = util.ValidateHostname(r.Domain, "Domain")
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type AddDomainResp struct {
+	Verified bool
+	// Claim is set if the domain isn't verified yet, and VerifyError says
+	// why if a previous claim couldn't be verified.
+	Claim       *types.DomainClaim `json:",omitempty"`
+	VerifyError string             `json:",omitempty"`
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RemoveDomain
+
+var RemoveDomainPath = "/v1/domains/remove"
+
+type RemoveDomainReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	Domain    string
+}
+
+func (r *RemoveDomainReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = util.ValidateDomainName(r.Domain, "Domain")
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type RemoveDomainResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListDomains
+
+var ListDomainsPath = "/v1/domains/list"
+
+type ListDomainsReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *ListDomainsReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type ListDomainsResp struct {
+	Domains []string
+	Claims  []*types.DomainClaim
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// WatchDomains
+
+var WatchDomainsPath = "/v1/domains/watch"
+
+// WatchDomainsReq starts a stream of DomainChanges, one JSON object per
+// line, that lasts until either side closes the connection.
+type WatchDomainsReq struct {
+}
+
+func (r *WatchDomainsReq) Validate() error {
+	return nil
+}
+
+// A DomainChange says that the ProjectAddr of Domain may have changed.
+// The first DomainChange in a stream and periodic keepalives have an
+// empty Domain; after the first one, no changes are missed.
+type DomainChange struct {
+	Domain string `json:",omitempty"`
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SetBackupConfig
+
+var SetBackupConfigPath = "/v1/projects/setBackupConfig"
+
+type SetBackupConfigReq struct {
+	Token        string
+	ProjectID    types.ProjectID
+	BackupConfig types.BackupConfig
+}
+
+func (r *SetBackupConfigReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return r.BackupConfig.Validate()
+}
+
+type SetBackupConfigResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListVersions
+
+var ListVersionsPath = "/v1/versions/list"
+
+type ListVersionsReq struct {
+	Token string
+}
+
+func (r *ListVersionsReq) Validate() error {
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+// ListVersionsResp has the names of the versions of Horizon and RethinkDB
+// projects can run.  The first of each is the default.
+type ListVersionsResp struct {
+	Horizon   []string
+	RethinkDB []string
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SetRuntime
+
+var SetRuntimePath = "/v1/projects/setRuntime"
+
+// SetRuntimeReq changes the versions of Horizon and RethinkDB a project
+// runs.  The project is upgraded one server at a time.  If the new
+// versions don't come up, the project is put back on its old versions the
+// same way, and its Runtime is changed back to name them.
+type SetRuntimeReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	Runtime   types.Runtime
+}
+
+func (r *SetRuntimeReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type SetRuntimeResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SetHorizonSettings
+
+var SetHorizonSettingsPath = "/v1/projects/setHorizonSettings"
+
+// SetHorizonSettingsReq replaces the settings a project's Horizon servers
+// run with.  An empty TokenSecret or auth provider Secret keeps the
+// current one, so settings from GetHorizonSettings can be sent back with
+// changes.
+type SetHorizonSettingsReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	Settings  types.HorizonSettings
+}
+
+func (r *SetHorizonSettingsReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return r.Settings.Validate()
+}
+
+type SetHorizonSettingsResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GetHorizonSettings
+
+var GetHorizonSettingsPath = "/v1/projects/getHorizonSettings"
+
+type GetHorizonSettingsReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *GetHorizonSettingsReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+// GetHorizonSettingsResp has a project's settings with TokenSecret and
+// auth provider Secrets left empty.  Version says whether they've been
+// applied yet.
+type GetHorizonSettingsResp struct {
+	Settings types.HorizonSettings
+	Version  types.ConfigVersion
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GetProjectDrift
+
+var GetProjectDriftPath = "/v1/projects/getDrift"
+
+type GetProjectDriftReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *GetProjectDriftReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+// GetProjectDriftResp has the result of the last time the project's Kube
+// objects were compared with its config, or a nil Status if they haven't
+// been yet.
+type GetProjectDriftResp struct {
+	Status *types.DriftStatus
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// GetProjectStatus
+
+var GetProjectStatusPath = "/v1/projects/status"
+
+type GetProjectStatusReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *GetProjectStatusReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+// GetProjectStatusResp has the live state of the project's servers along
+// with how far its config has been applied.  Error is set if the servers
+// couldn't be read, and ActiveRelease is nil if nothing has been deployed.
+type GetProjectStatusResp struct {
+	Components           []types.ComponentStatus
+	Error                string `json:",omitempty"`
+	KubeConfigVersion    types.ConfigVersion
+	HorizonConfigVersion types.ConfigVersion
+	ActiveRelease        *types.Release
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListOperations
+
+var ListOperationsPath = "/v1/projects/listOperations"
+
+type ListOperationsReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *ListOperationsReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+// ListOperationsResp has the project's most recent operations that created
+// Kube objects or disks, newest first.
+type ListOperationsResp struct {
+	Operations []*types.Operation
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListBackups
+
+var ListBackupsPath = "/v1/projects/listBackups"
+
+type ListBackupsReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *ListBackupsReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type ListBackupsResp struct {
+	Backups []*types.Backup
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// RestoreBackup
+
+var RestoreBackupPath = "/v1/projects/restoreBackup"
+
+type RestoreBackupReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	BackupID  string
+}
+
+func (r *RestoreBackupReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	if r.BackupID == "" {
+		return errors.New("BackupID must be set")
+	}
+	return nil
+}
+
+type RestoreBackupResp struct {
+	// The restore happens asynchronously; RestoreVersion is the version
+	// that will show up in the project's RestoreVersion.Applied once it
+	// is done.
+	RestoreVersion int64
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListReleases
+
+var ListReleasesPath = "/v1/projects/listReleases"
+
+type ListReleasesReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *ListReleasesReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type ListReleasesResp struct {
+	// Releases are ordered newest first.
+	Releases      []*types.Release
+	ActiveRelease string
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SetActiveRelease
+
+var SetActiveReleasePath = "/v1/projects/setActiveRelease"
+
+type SetActiveReleaseReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	ReleaseID string
+}
+
+func (r *SetActiveReleaseReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	if r.ReleaseID == "" {
+		return errors.New("ReleaseID must be set")
+	}
+	return nil
+}
+
+type SetActiveReleaseResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// SetEnv
+
+var SetEnvPath = "/v1/env/set"
+
+// SetEnvReq sets an environment variable in a project's Horizon servers,
+// which are restarted to pick it up.  The values of secret variables are
+// encrypted at rest and never returned.
+type SetEnvReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	Name      string
+	Value     string
+	Secret    bool
+}
+
+func (r *SetEnvReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = types.ValidateEnvName(r.Name, "Name")
+	if err != nil {
+		return err
+	}
+	if len(r.Value) > types.MaxEnvValueLength {
+		return fmt.Errorf("Value is too long (%v > %v)",
+			len(r.Value), types.MaxEnvValueLength)
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type SetEnvResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// UnsetEnv
+
+var UnsetEnvPath = "/v1/env/unset"
+
+type UnsetEnvReq struct {
+	Token     string
+	ProjectID types.ProjectID
+	Name      string
+}
+
+func (r *UnsetEnvReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	err = types.ValidateEnvName(r.Name, "Name")
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+type UnsetEnvResp struct {
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// ListEnv
+
+var ListEnvPath = "/v1/env/list"
+
+type ListEnvReq struct {
+	Token     string
+	ProjectID types.ProjectID
+}
+
+func (r *ListEnvReq) Validate() error {
+	err := r.ProjectID.Validate()
+	if err != nil {
+		return err
+	}
+	if !util.ReasonableToken(r.Token) {
+		return errors.New("Token is not of the correct form")
+	}
+	return nil
+}
+
+// ListEnvResp has a project's environment variables.  Secret variables
+// have empty Values.
+type ListEnvResp struct {
+	Env map[string]types.EnvVar
+}
@@ -24,7 +24,8 @@ type Client struct {
 // baseURL must be the prefix of the API URL; for example, "https://horizon" if
 // the calls should be "https://horizon/v1/configs/...".
 //
-// sharedSecret should be the shared secret for accessing protected APIs.
+// sharedSecret should be the secret for accessing protected APIs: the
+// shared secret, or hzc-http's own secret for the certificate endpoints.
 func NewClient(baseURL string, sharedSecret string) (*Client, error) {
 	return &Client{
 		baseURL:      baseURL,
@@ -64,6 +65,16 @@ func (c *Client) GetProjectAddrByDomain(
 	return &ret, nil
 }
 
+func (c *Client) GetReleaseManifest(
+	opts GetReleaseManifestReq) (*GetReleaseManifestResp, error) {
+	var ret GetReleaseManifestResp
+	err := c.jsonRoundTrip(GetReleaseManifestPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
 func (c *Client) GetProjectsByToken(
 	opts GetProjectsByTokenReq) (*GetProjectsByTokenResp, error) {
 	var ret GetProjectsByTokenResp
@@ -84,15 +95,388 @@ func (c *Client) UpdateProjectManifest(
 	return &ret, nil
 }
 
+func (c *Client) SetBackupConfig(
+	opts SetBackupConfigReq) (*SetBackupConfigResp, error) {
+	var ret SetBackupConfigResp
+	err := c.jsonRoundTrip(SetBackupConfigPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListVersions(
+	opts ListVersionsReq) (*ListVersionsResp, error) {
+	var ret ListVersionsResp
+	err := c.jsonRoundTrip(ListVersionsPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) SetRuntime(
+	opts SetRuntimeReq) (*SetRuntimeResp, error) {
+	var ret SetRuntimeResp
+	err := c.jsonRoundTrip(SetRuntimePath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) SetHorizonSettings(
+	opts SetHorizonSettingsReq) (*SetHorizonSettingsResp, error) {
+	var ret SetHorizonSettingsResp
+	err := c.jsonRoundTrip(SetHorizonSettingsPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetHorizonSettings(
+	opts GetHorizonSettingsReq) (*GetHorizonSettingsResp, error) {
+	var ret GetHorizonSettingsResp
+	err := c.jsonRoundTrip(GetHorizonSettingsPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetProjectDrift(
+	opts GetProjectDriftReq) (*GetProjectDriftResp, error) {
+	var ret GetProjectDriftResp
+	err := c.jsonRoundTrip(GetProjectDriftPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetProjectStatus(
+	opts GetProjectStatusReq) (*GetProjectStatusResp, error) {
+	var ret GetProjectStatusResp
+	err := c.jsonRoundTrip(GetProjectStatusPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListOperations(
+	opts ListOperationsReq) (*ListOperationsResp, error) {
+	var ret ListOperationsResp
+	err := c.jsonRoundTrip(ListOperationsPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListBackups(
+	opts ListBackupsReq) (*ListBackupsResp, error) {
+	var ret ListBackupsResp
+	err := c.jsonRoundTrip(ListBackupsPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) RestoreBackup(
+	opts RestoreBackupReq) (*RestoreBackupResp, error) {
+	var ret RestoreBackupResp
+	err := c.jsonRoundTrip(RestoreBackupPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListReleases(
+	opts ListReleasesReq) (*ListReleasesResp, error) {
+	var ret ListReleasesResp
+	err := c.jsonRoundTrip(ListReleasesPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) SetActiveRelease(
+	opts SetActiveReleaseReq) (*SetActiveReleaseResp, error) {
+	var ret SetActiveReleaseResp
+	err := c.jsonRoundTrip(SetActiveReleasePath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) CreateProject(
+	opts CreateProjectReq) (*CreateProjectResp, error) {
+	var ret CreateProjectResp
+	err := c.jsonRoundTrip(CreateProjectPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) DeleteProject(
+	opts DeleteProjectReq) (*DeleteProjectResp, error) {
+	var ret DeleteProjectResp
+	err := c.jsonRoundTrip(DeleteProjectPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) TransferProject(
+	opts TransferProjectReq) (*TransferProjectResp, error) {
+	var ret TransferProjectResp
+	err := c.jsonRoundTrip(TransferProjectPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) RenameProject(
+	opts RenameProjectReq) (*RenameProjectResp, error) {
+	var ret RenameProjectResp
+	err := c.jsonRoundTrip(RenameProjectPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) AddProjectUser(
+	opts AddProjectUserReq) (*AddProjectUserResp, error) {
+	var ret AddProjectUserResp
+	err := c.jsonRoundTrip(AddProjectUserPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) RemoveProjectUser(
+	opts RemoveProjectUserReq) (*RemoveProjectUserResp, error) {
+	var ret RemoveProjectUserResp
+	err := c.jsonRoundTrip(RemoveProjectUserPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListUserKeys(
+	opts ListUserKeysReq) (*ListUserKeysResp, error) {
+	var ret ListUserKeysResp
+	err := c.jsonRoundTrip(ListUserKeysPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) AddUserKey(
+	opts AddUserKeyReq) (*AddUserKeyResp, error) {
+	var ret AddUserKeyResp
+	err := c.jsonRoundTrip(AddUserKeyPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) RemoveUserKey(
+	opts RemoveUserKeyReq) (*RemoveUserKeyResp, error) {
+	var ret RemoveUserKeyResp
+	err := c.jsonRoundTrip(RemoveUserKeyPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) AddDomain(
+	opts AddDomainReq) (*AddDomainResp, error) {
+	var ret AddDomainResp
+	err := c.jsonRoundTrip(AddDomainPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) RemoveDomain(
+	opts RemoveDomainReq) (*RemoveDomainResp, error) {
+	var ret RemoveDomainResp
+	err := c.jsonRoundTrip(RemoveDomainPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListDomains(
+	opts ListDomainsReq) (*ListDomainsResp, error) {
+	var ret ListDomainsResp
+	err := c.jsonRoundTrip(ListDomainsPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) SetEnv(
+	opts SetEnvReq) (*SetEnvResp, error) {
+	var ret SetEnvResp
+	err := c.jsonRoundTrip(SetEnvPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) UnsetEnv(
+	opts UnsetEnvReq) (*UnsetEnvResp, error) {
+	var ret UnsetEnvResp
+	err := c.jsonRoundTrip(UnsetEnvPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListEnv(
+	opts ListEnvReq) (*ListEnvResp, error) {
+	var ret ListEnvResp
+	err := c.jsonRoundTrip(ListEnvPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) ListCertificates(
+	opts ListCertificatesReq) (*ListCertificatesResp, error) {
+	var ret ListCertificatesResp
+	err := c.jsonRoundTrip(ListCertificatesPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetCertificate(
+	opts GetCertificateReq) (*GetCertificateResp, error) {
+	var ret GetCertificateResp
+	err := c.jsonRoundTrip(GetCertificatePath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) SetCertificate(
+	opts SetCertificateReq) (*SetCertificateResp, error) {
+	var ret SetCertificateResp
+	err := c.jsonRoundTrip(SetCertificatePath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) LockCertificate(
+	opts LockCertificateReq) (*LockCertificateResp, error) {
+	var ret LockCertificateResp
+	err := c.jsonRoundTrip(LockCertificatePath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetACMEChallenge(
+	opts GetACMEChallengeReq) (*GetACMEChallengeResp, error) {
+	var ret GetACMEChallengeResp
+	err := c.jsonRoundTrip(GetACMEChallengePath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) SetACMEChallenge(
+	opts SetACMEChallengeReq) (*SetACMEChallengeResp, error) {
+	var ret SetACMEChallengeResp
+	err := c.jsonRoundTrip(SetACMEChallengePath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetACMEAccount(
+	opts GetACMEAccountReq) (*GetACMEAccountResp, error) {
+	var ret GetACMEAccountResp
+	err := c.jsonRoundTrip(GetACMEAccountPath, opts, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// WatchDomains calls f with each DomainChange from the server until the
+// stream fails or f returns false.
+func (c *Client) WatchDomains(
+	opts WatchDomainsReq, f func(DomainChange) bool) error {
+	resp, err := c.post(WatchDomainsPath, opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var change DomainChange
+		err := dec.Decode(&change)
+		if err != nil {
+			return err
+		}
+		if !f(change) {
+			return nil
+		}
+	}
+}
+
 func (c *Client) jsonRoundTrip(path string, body interface{}, out interface{}) error {
-	buf, err := json.Marshal(body)
+	resp, err := c.post(path, body)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// post sends body to path and returns the response if it was successful.
+func (c *Client) post(path string, body interface{}) (*http.Response, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
 
 	req, err := http.NewRequest("POST", c.baseURL+path, bytes.NewReader(buf))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", jsonMIMEType)
@@ -102,11 +486,12 @@ func (c *Client) jsonRoundTrip(path string, body interface{}, out interface{}) e
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
 		// Try to decode a JSON error response object out of the response body.
 
 		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
@@ -114,14 +499,14 @@ func (c *Client) jsonRoundTrip(path string, body interface{}, out interface{}) e
 			Error string `json:"error"`
 		}
 		if json.Unmarshal(body, &errBody) == nil {
-			return fmt.Errorf("couldn't %v %v: %v",
+			return nil, fmt.Errorf("couldn't %v %v: %v",
 				req.Method, req.URL, errBody.Error)
 		}
 
 		// Couldn't decode the body as JSON; just return a generic HTTP error.
-		return fmt.Errorf("couldn't %v %v: response code %v, body %#v",
+		return nil, fmt.Errorf("couldn't %v %v: response code %v, body %#v",
 			req.Method, req.URL, resp.StatusCode, string(body))
 	}
 
-	return json.NewDecoder(resp.Body).Decode(out)
+	return resp, nil
 }
@@ -0,0 +1,94 @@
+package db
+
+import (
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func (d *DB) SetBackupConfig(
+	projectID types.ProjectID, conf types.BackupConfig) error {
+	// r.Literal so that clearing Mode actually disables backups.
+	q := projects.Get(projectID).Update(map[string]interface{}{
+		"BackupConfig": r.Literal(conf),
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	_, err := d.runProjectWrite(q)
+	return err
+}
+
+// GetProjectsWithBackups returns all projects that have backups enabled.
+func (d *DB) GetProjectsWithBackups() ([]*types.Project, error) {
+	q := projects.Filter(
+		r.Row.Field("BackupConfig").Field("Mode").Default("").Ne(""))
+	cursor, err := q.Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get projects with backups: %v", err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var projects []*types.Project
+	var p *types.Project
+	for cursor.Next(&p) {
+		projects = append(projects, p)
+		p = nil
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (d *DB) AddBackup(b *types.Backup) error {
+	_, err := backups.Insert(b).RunWrite(d.session)
+	return err
+}
+
+func (d *DB) GetBackup(id string) (*types.Backup, error) {
+	var b types.Backup
+	err := d.getBasicType(backups, "backup", id, &b)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetBackups returns the backups of a project, newest first.
+func (d *DB) GetBackups(projectID types.ProjectID) ([]*types.Backup, error) {
+	q := backups.GetAllByIndex("ProjectID", projectID).OrderBy(r.Desc("Created"))
+	cursor, err := q.Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get backups for %v: %v", projectID, err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret []*types.Backup
+	err = cursor.All(&ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (d *DB) DeleteBackup(id string) error {
+	_, err := backups.Get(id).Delete().RunWrite(d.session)
+	return err
+}
+
+// RequestRestore asks the sync loop to restore a project from a backup.  It
+// returns the RestoreVersion that will be applied.
+func (d *DB) RequestRestore(
+	projectID types.ProjectID, backupID string) (int64, error) {
+	rv := "RestoreVersion"
+	q := projects.Get(projectID).Update(func(project r.Term) r.Term {
+		return r.Expr(map[string]interface{}{
+			"RestoreBackupID": backupID,
+			rv: map[string]interface{}{
+				"Desired": project.Field(rv).Field("Desired").Default(0).Add(1),
+			},
+		})
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	project, err := d.runProjectWrite(q)
+	if err != nil {
+		return 0, err
+	}
+	return project.RestoreVersion.Desired, nil
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"encoding/hex"
+	"time"
+
+	r "github.com/dancannon/gorethink"
+)
+
+// A blob records which releases use the object types.BlobName(md5).  The
+// set of releases is its reference count; a blob nothing refers to is
+// deleted once it hasn't been touched for a while.  Deleting is set while
+// the object is being deleted, and the record is removed once it's gone.
+type blob struct {
+	Hash     string   `gorethink:"id"`
+	Releases []string `gorethink:",omitempty"`
+	Touched  time.Time
+	Deleting bool `gorethink:",omitempty"`
+}
+
+func blobIDs(md5s [][]byte) []interface{} {
+	ids := make([]interface{}, len(md5s))
+	for i, md5 := range md5s {
+		ids[i] = hex.EncodeToString(md5)
+	}
+	return ids
+}
+
+// TouchBlobs records that the blobs are about to be used, so that they
+// aren't collected while a deploy is uploading them.  Blobs that are
+// already being deleted stay that way; see GetDeletingBlobs.
+func (d *DB) TouchBlobs(md5s [][]byte, now time.Time) error {
+	if len(md5s) == 0 {
+		return nil
+	}
+	docs := make([]blob, len(md5s))
+	for i, md5 := range md5s {
+		docs[i] = blob{Hash: hex.EncodeToString(md5), Touched: now}
+	}
+	_, err := blobs.Insert(docs, r.InsertOpts{Conflict: "update"}).RunWrite(d.session)
+	return err
+}
+
+// AddBlobRefs adds releaseID to the references of the blobs, which must
+// have been touched first.
+func (d *DB) AddBlobRefs(releaseID string, md5s [][]byte) error {
+	if len(md5s) == 0 {
+		return nil
+	}
+	_, err := blobs.GetAll(blobIDs(md5s)...).Update(map[string]interface{}{
+		"Releases": r.Row.Field("Releases").Default([]string{}).SetInsert(releaseID),
+	}).RunWrite(d.session)
+	return err
+}
+
+// RemoveBlobRefs removes releaseID from the references of the blobs.  They
+// count as touched, so a blob freed this way is kept for a grace period.
+func (d *DB) RemoveBlobRefs(releaseID string, md5s [][]byte, now time.Time) error {
+	if len(md5s) == 0 {
+		return nil
+	}
+	_, err := blobs.GetAll(blobIDs(md5s)...).Update(map[string]interface{}{
+		"Releases": r.Row.Field("Releases").Default([]string{}).
+			SetDifference([]string{releaseID}),
+		"Touched": now,
+	}).RunWrite(d.session)
+	return err
+}
+
+func unreferencedBefore(b r.Term, before time.Time) r.Term {
+	return b.Field("Releases").Default([]string{}).IsEmpty().
+		And(b.Field("Touched").Lt(before))
+}
+
+// GetUnreferencedBlobs returns the MD5 sums of blobs with no references
+// that haven't been touched since before.
+func (d *DB) GetUnreferencedBlobs(before time.Time) ([][]byte, error) {
+	q := blobs.Filter(func(b r.Term) r.Term {
+		return unreferencedBefore(b, before)
+	})
+	cursor, err := q.Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get unreferenced blobs: %v", err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret [][]byte
+	var b blob
+	for cursor.Next(&b) {
+		md5, err := hex.DecodeString(b.Hash)
+		if err != nil {
+			d.log.Error("Bad blob hash %#v", b.Hash)
+			continue
+		}
+		ret = append(ret, md5)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// MarkBlobDeleting marks a blob as being deleted if it is still
+// unreferenced and untouched since before, and reports whether it did.
+// The check and the mark are a single atomic write, so a blob is never
+// marked while a deploy starts using it, and a deploy that touches it
+// afterwards sees the mark.
+func (d *DB) MarkBlobDeleting(md5 []byte, before time.Time) (bool, error) {
+	q := blobs.Get(hex.EncodeToString(md5)).Update(func(b r.Term) interface{} {
+		return r.Branch(
+			b.Field("Deleting").Default(false).Not().And(unreferencedBefore(b, before)),
+			map[string]interface{}{"Deleting": true},
+			map[string]interface{}{})
+	})
+	res, err := q.RunWrite(d.session)
+	if err != nil {
+		return false, err
+	}
+	return res.Replaced == 1, nil
+}
+
+// GetDeletingBlobs returns the MD5 sums of the blobs among md5s that are
+// being deleted, or of all of them if md5s is nil.
+func (d *DB) GetDeletingBlobs(md5s [][]byte) ([][]byte, error) {
+	q := blobs
+	if md5s != nil {
+		if len(md5s) == 0 {
+			return nil, nil
+		}
+		q = blobs.GetAll(blobIDs(md5s)...)
+	}
+	cursor, err := q.Filter(r.Row.Field("Deleting").Default(false)).Run(d.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret [][]byte
+	var b blob
+	for cursor.Next(&b) {
+		md5, err := hex.DecodeString(b.Hash)
+		if err != nil {
+			d.log.Error("Bad blob hash %#v", b.Hash)
+			continue
+		}
+		ret = append(ret, md5)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ForgetBlob removes the record of a blob marked by MarkBlobDeleting, once
+// its object has been deleted.
+func (d *DB) ForgetBlob(md5 []byte) error {
+	q := blobs.Get(hex.EncodeToString(md5)).Replace(func(b r.Term) interface{} {
+		return r.Branch(b.Field("Deleting").Default(false), nil, b)
+	})
+	_, err := q.RunWrite(d.session)
+	return err
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"time"
+
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// GetAllDomains returns every domain a project is served on.
+func (d *DB) GetAllDomains() ([]string, error) {
+	cursor, err := domains.Field("id").Run(d.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret []string
+	err = cursor.All(&ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetCertificateExpiries returns when the certificate for each domain
+// expires.  Domains without certificates aren't included.
+func (d *DB) GetCertificateExpiries() (map[string]time.Time, error) {
+	cursor, err := certificates.HasFields("CertPEM").
+		Pluck("id", "NotAfter").Run(d.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	ret := make(map[string]time.Time)
+	var cert types.Certificate
+	for cursor.Next(&cert) {
+		ret[cert.Domain] = cert.NotAfter
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetCertificate returns the certificate for domain, or nil if there
+// isn't one.
+func (d *DB) GetCertificate(domain string) (*types.Certificate, error) {
+	var cert types.Certificate
+	err := runOne(certificates.Get(domain), d.session, &cert)
+	if err == r.ErrEmptyResult {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cert.CertPEM == nil {
+		return nil, nil
+	}
+	return &cert, nil
+}
+
+// SetCertificate stores a new certificate for its domain and releases the
+// lock on it.
+func (d *DB) SetCertificate(cert *types.Certificate) error {
+	_, err := certificates.Get(cert.Domain).Replace(map[string]interface{}{
+		"id":       cert.Domain,
+		"CertPEM":  cert.CertPEM,
+		"KeyPEM":   cert.KeyPEM,
+		"NotAfter": cert.NotAfter,
+	}).RunWrite(d.session)
+	return err
+}
+
+// LockCertificate tries to give holder the lock on domain's certificate
+// until the given time, and reports whether it did.  holder gets the lock
+// if nobody holds it, the lock has expired, or holder already has it.
+func (d *DB) LockCertificate(
+	domain string, holder string, until time.Time) (bool, error) {
+	now := time.Now()
+	lock := map[string]interface{}{
+		"LockedBy":    holder,
+		"LockedUntil": until,
+	}
+	q := certificates.Get(domain).Replace(func(c r.Term) interface{} {
+		return r.Branch(
+			c.Eq(nil),
+			r.Expr(lock).Merge(map[string]interface{}{"id": domain}),
+			c.Field("LockedUntil").Default(r.EpochTime(0)).Lt(now).Or(
+				c.Field("LockedBy").Default("").Eq(holder)),
+			c.Merge(lock),
+			c)
+	})
+	res, err := q.RunWrite(d.session)
+	if err != nil {
+		return false, err
+	}
+	return res.Inserted == 1 || res.Replaced == 1, nil
+}
+
+// DeleteUnusedCertificates deletes the certificates of domains that no
+// project is served on any more.
+func (d *DB) DeleteUnusedCertificates() error {
+	_, err := certificates.Filter(func(c r.Term) interface{} {
+		return domains.Get(c.Field("id")).Eq(nil)
+	}).Delete().RunWrite(d.session)
+	return err
+}
+
+// GetACMEChallenge returns the key authorization for token, or "" if
+// there's no such challenge.
+func (d *DB) GetACMEChallenge(token string) (string, error) {
+	var c types.ACMEChallenge
+	err := runOne(challenges.Get(token), d.session, &c)
+	if err == r.ErrEmptyResult {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.KeyAuth, nil
+}
+
+func (d *DB) SetACMEChallenge(c *types.ACMEChallenge) error {
+	_, err := challenges.Insert(c, r.InsertOpts{Conflict: "replace"}).
+		RunWrite(d.session)
+	return err
+}
+
+func (d *DB) RemoveACMEChallenge(token string) error {
+	_, err := challenges.Get(token).Delete().RunWrite(d.session)
+	return err
+}
+
+// GetOrAddACMEAccount returns the stored account for acct.DirectoryURL,
+// first storing acct if there isn't one.
+func (d *DB) GetOrAddACMEAccount(
+	acct *types.ACMEAccount) (*types.ACMEAccount, error) {
+	q := acmeAccounts.Get(acct.DirectoryURL).Replace(func(a r.Term) interface{} {
+		return r.Branch(a.Eq(nil), acct, a)
+	})
+	_, err := q.RunWrite(d.session)
+	if err != nil {
+		return nil, err
+	}
+	var ret types.ACMEAccount
+	err = runOne(acmeAccounts.Get(acct.DirectoryURL), d.session, &ret)
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
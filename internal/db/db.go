@@ -19,7 +19,20 @@ var (
 
 	projects = r.DB("web_backend").Table("projects")
 	domains  = r.DB("web_backend").Table("domains")
+	claims   = r.DB("web_backend").Table("domain_claims")
+	backups  = r.DB("web_backend").Table("backups")
+	releases = r.DB("web_backend").Table("releases")
 	users    = r.DB("web_backend_internal").Table("users")
+
+	orphanedDisks = r.DB("web_backend_internal").Table("orphaned_disks")
+	blobs         = r.DB("web_backend_internal").Table("blobs")
+	audit         = r.DB("web_backend_internal").Table("audit")
+	certificates  = r.DB("web_backend_internal").Table("certificates")
+	challenges    = r.DB("web_backend_internal").Table("acme_challenges")
+	acmeAccounts  = r.DB("web_backend_internal").Table("acme_accounts")
+	drift         = r.DB("web_backend_internal").Table("drift")
+	leases        = r.DB("web_backend_internal").Table("leases")
+	operations    = r.DB("web_backend_internal").Table("operations")
 )
 
 type hzUser struct {
@@ -107,8 +120,13 @@ func (d *DB) UpdateProject(projectPatch types.Project) (bool, error) {
 	return res.Replaced == 1, nil
 }
 
+// DeleteProject removes a project's row once its cluster has been torn
+// down.  Only rows marked Deleting are removed, in case the project was
+// removed and recreated in the meantime.
 func (d *DB) DeleteProject(projectID types.ProjectID) error {
-	q := projects.Get(projectID).Delete()
+	q := projects.GetAll(projectID).Filter(map[string]interface{}{
+		"Deleting": true,
+	}).Delete()
 	_, err := q.RunWrite(d.session)
 	return err
 }
@@ -258,6 +276,37 @@ func (d *DB) GetProjectsByUsers(users []string) ([]*types.Project, error) {
 	return projects, nil
 }
 
+func (d *DB) GetAllProjects() ([]*types.Project, error) {
+	cursor, err := projects.Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get all projects: %v", err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var projects []*types.Project
+	var p *types.Project
+	for cursor.Next(&p) {
+		projects = append(projects, p)
+		p = nil
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (d *DB) GetProject(projectID types.ProjectID) (*types.Project, error) {
+	var p types.Project
+	err := runOne(projects.Get(projectID), d.session, &p)
+	if err != nil {
+		if err != r.ErrEmptyResult {
+			return nil, err
+		}
+		return nil, fmt.Errorf("No such project.")
+	}
+	return &p, nil
+}
+
 func (d *DB) GetProjectIDByDomain(domainName string) (*types.ProjectID, error) {
 	var domain types.Domain
 	err := runOne(domains.Get(domainName), d.session, &domain)
@@ -275,27 +324,58 @@ type ProjectChange struct {
 	NewVal *types.Project `gorethink:"new_val"`
 }
 
-func (d *DB) projectChangesLoop(out chan<- ProjectChange) {
+func (d *DB) projectChangesLoop(
+	out chan<- ProjectChange, stop <-chan struct{}) {
+	defer close(out)
 	query := projects.Changes(r.ChangesOpts{IncludeInitial: true})
 	for {
 		ch := make(chan ProjectChange)
 		cur, err := query.Run(d.session)
 		if err != nil {
 			d.log.Error("Couldn't query for config changes: %s", err)
-			time.Sleep(time.Second * 5)
-			continue
+			select {
+			case <-time.After(time.Second * 5):
+				continue
+			case <-stop:
+				return
+			}
 		}
 		cur.Listen(ch)
-		for el := range ch {
-			out <- el
+		closeCursor := func() {
+			// Listen closes ch once the cursor is closed, but only after
+			// any pending send goes through.
+			cur.Close()
+			for range ch {
+			}
+		}
+	changes:
+		for {
+			select {
+			case el, ok := <-ch:
+				if !ok {
+					break changes
+				}
+				select {
+				case out <- el:
+				case <-stop:
+					closeCursor()
+					return
+				}
+			case <-stop:
+				closeCursor()
+				return
+			}
 		}
 		err = cur.Err()
 		d.log.Error("Config changes loop ended: %v", err)
 	}
 }
 
-func (d *DB) ProjectChanges(out chan<- ProjectChange) {
-	go d.projectChangesLoop(out)
+// ProjectChanges sends every project, and then every change to the
+// projects table, to out until stop is closed, at which point it closes
+// out.
+func (d *DB) ProjectChanges(out chan<- ProjectChange, stop <-chan struct{}) {
+	go d.projectChangesLoop(out, stop)
 }
 
 func runOne(query r.Term, session *r.Session, out interface{}) error {
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"time"
+
+	r "github.com/dancannon/gorethink"
+)
+
+type orphanedDisk struct {
+	Name      string `gorethink:"id"`
+	FirstSeen time.Time
+}
+
+// GetOrphanedDisks returns the disks previously recorded as orphaned,
+// mapped to the time they were first seen that way.
+func (d *DB) GetOrphanedDisks() (map[string]time.Time, error) {
+	cursor, err := orphanedDisks.Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get orphaned disks: %v", err)
+		return nil, err
+	}
+	defer cursor.Close()
+	ret := make(map[string]time.Time)
+	var od orphanedDisk
+	for cursor.Next(&od) {
+		ret[od.Name] = od.FirstSeen
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (d *DB) AddOrphanedDisk(name string, firstSeen time.Time) error {
+	_, err := orphanedDisks.Insert(orphanedDisk{name, firstSeen},
+		r.InsertOpts{Conflict: "replace"}).RunWrite(d.session)
+	return err
+}
+
+func (d *DB) RemoveOrphanedDisk(name string) error {
+	_, err := orphanedDisks.Get(name).Delete().RunWrite(d.session)
+	return err
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func byProject(projectID types.ProjectID) r.Term {
+	return r.Row.Field("ProjectID").Eq(projectID)
+}
+
+// GetProjectDomains returns the domains a project is served on.
+func (d *DB) GetProjectDomains(projectID types.ProjectID) ([]string, error) {
+	cursor, err := domains.Filter(byProject(projectID)).Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get domains for %v: %v", projectID, err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret []string
+	var domain types.Domain
+	for cursor.Next(&domain) {
+		ret = append(ret, domain.Domain)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SetDomain serves a project on a domain, replacing any other project the
+// domain pointed to, and drops the project's claim on it.
+func (d *DB) SetDomain(domain string, projectID types.ProjectID) error {
+	_, err := domains.Insert(types.Domain{Domain: domain, ProjectID: projectID},
+		r.InsertOpts{Conflict: "replace"}).RunWrite(d.session)
+	if err != nil {
+		return err
+	}
+	return d.RemoveDomainClaim(domain, projectID)
+}
+
+// RemoveDomain stops serving a project on a domain, and drops any claim
+// the project has on it and the domain's certificate.  It doesn't touch
+// domains pointing to other projects.
+func (d *DB) RemoveDomain(domain string, projectID types.ProjectID) error {
+	_, err := domains.GetAll(domain).Filter(byProject(projectID)).
+		Delete().RunWrite(d.session)
+	if err != nil {
+		return err
+	}
+	err = d.RemoveDomainClaim(domain, projectID)
+	if err != nil {
+		return err
+	}
+	return d.DeleteUnusedCertificates()
+}
+
+// GetDomainClaims returns the claims a project has on domains it isn't
+// served on yet.
+func (d *DB) GetDomainClaims(
+	projectID types.ProjectID) ([]*types.DomainClaim, error) {
+	cursor, err := claims.GetAllByIndex("ProjectID", projectID).
+		OrderBy("Domain").Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get domain claims for %v: %v", projectID, err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret []*types.DomainClaim
+	err = cursor.All(&ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetDomainClaim returns a project's claim on domain, or nil if there
+// isn't one.
+func (d *DB) GetDomainClaim(
+	domain string, projectID types.ProjectID) (*types.DomainClaim, error) {
+	all, err := d.GetDomainClaims(projectID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range all {
+		if c.Domain == domain {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *DB) AddDomainClaim(c *types.DomainClaim) error {
+	_, err := claims.Insert(c).RunWrite(d.session)
+	return err
+}
+
+func (d *DB) RemoveDomainClaim(domain string, projectID types.ProjectID) error {
+	_, err := claims.GetAllByIndex("ProjectID", projectID).
+		Filter(map[string]interface{}{"Domain": domain}).
+		Delete().RunWrite(d.session)
+	return err
+}
+
+// DeleteProjectDomains removes all of a project's domains, claims and
+// certificates.
+func (d *DB) DeleteProjectDomains(projectID types.ProjectID) error {
+	_, err := domains.Filter(byProject(projectID)).Delete().RunWrite(d.session)
+	if err != nil {
+		return err
+	}
+	_, err = claims.GetAllByIndex("ProjectID", projectID).
+		Delete().RunWrite(d.session)
+	if err != nil {
+		return err
+	}
+	return d.DeleteUnusedCertificates()
+}
+
+type domainChange struct {
+	OldVal *types.Domain `gorethink:"old_val"`
+	NewVal *types.Domain `gorethink:"new_val"`
+}
+
+// WatchDomains sends to out the names of domains whose ProjectAddr may
+// have changed: domains that were added, removed or pointed elsewhere,
+// and the domains of projects whose active release changed.  It sends ""
+// once it is watching, so that callers can tell which changes they might
+// have missed.  It returns when stop is closed or a changefeed fails.
+func (d *DB) WatchDomains(out chan<- string, stop <-chan struct{}) error {
+	domainCursor, err := domains.Changes().Run(d.session)
+	if err != nil {
+		return err
+	}
+	projectCursor, err := projects.Changes().Filter(
+		r.Row.Field("old_val").Field("ActiveRelease").Default(nil).Ne(
+			r.Row.Field("new_val").Field("ActiveRelease").Default(nil)),
+	).Run(d.session)
+	if err != nil {
+		domainCursor.Close()
+		return err
+	}
+
+	domainCh := make(chan domainChange)
+	domainCursor.Listen(domainCh)
+	projectCh := make(chan ProjectChange)
+	projectCursor.Listen(projectCh)
+	defer func() {
+		// Listen closes the channels once the cursors are closed, but
+		// only after any pending sends go through.
+		domainCursor.Close()
+		projectCursor.Close()
+		go func() {
+			for range domainCh {
+			}
+		}()
+		go func() {
+			for range projectCh {
+			}
+		}()
+	}()
+
+	send := func(domain string) bool {
+		select {
+		case out <- domain:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	if !send("") {
+		return nil
+	}
+	for {
+		select {
+		case c, ok := <-domainCh:
+			if !ok {
+				return domainCursor.Err()
+			}
+			for _, v := range []*types.Domain{c.OldVal, c.NewVal} {
+				if v != nil && !send(v.Domain) {
+					return nil
+				}
+			}
+		case c, ok := <-projectCh:
+			if !ok {
+				return projectCursor.Err()
+			}
+			if c.NewVal == nil {
+				continue
+			}
+			names, err := d.GetProjectDomains(c.NewVal.ID)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				if !send(name) {
+					return nil
+				}
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func (d *DB) SetDriftStatus(s *types.DriftStatus) error {
+	_, err := drift.Insert(s,
+		r.InsertOpts{Conflict: "replace"}).RunWrite(d.session)
+	return err
+}
+
+// GetDriftStatus returns the last drift status of the project with kube
+// name kubeName, or nil if it hasn't been checked yet.
+func (d *DB) GetDriftStatus(kubeName string) (*types.DriftStatus, error) {
+	var s types.DriftStatus
+	err := runOne(drift.Get(kubeName), d.session, &s)
+	if err == r.ErrEmptyResult {
+		return nil, nil
+	}
+	if err != nil {
+		d.log.Error("Couldn't get drift status of %v: %v", kubeName, err)
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DeleteDriftStatuses removes the drift status of every project whose kube
+// name isn't in keep.
+func (d *DB) DeleteDriftStatuses(keep []string) error {
+	_, err := drift.Filter(func(s r.Term) r.Term {
+		return r.Expr(keep).Contains(s.Field("id")).Not()
+	}).Delete().RunWrite(d.session)
+	return err
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// updateProjectEnv sets (or, with r.Literal(), removes) one of a project's
+// environment variables and bumps KubeConfigVersion so that the sync loop
+// applies it.
+func (d *DB) updateProjectEnv(
+	projectID types.ProjectID, name string, val r.Term) (*types.Project, error) {
+	kv := "KubeConfigVersion"
+	q := projects.Get(projectID).Update(func(project r.Term) r.Term {
+		return r.Expr(map[string]interface{}{
+			"Env": map[string]interface{}{name: val},
+			kv: map[string]interface{}{
+				"Desired": project.Field(kv).Field("Desired").Default(0).Add(1),
+			},
+		})
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	return d.runProjectWrite(q)
+}
+
+// SetProjectEnv sets one of a project's environment variables.  Secret
+// values must already be sealed.
+func (d *DB) SetProjectEnv(projectID types.ProjectID,
+	name string, v types.EnvVar) (*types.Project, error) {
+	return d.updateProjectEnv(projectID, name, r.Literal(v))
+}
+
+// UnsetProjectEnv removes one of a project's environment variables.
+func (d *DB) UnsetProjectEnv(
+	projectID types.ProjectID, name string) (*types.Project, error) {
+	return d.updateProjectEnv(projectID, name, r.Literal())
+}
+
+// SetHorizonSettings replaces a project's HorizonSettings and bumps
+// HorizonSettingsVersion so that the sync loop applies them.  Secrets must
+// already be sealed.
+func (d *DB) SetHorizonSettings(projectID types.ProjectID,
+	settings types.HorizonSettings) (*types.Project, error) {
+	hsv := "HorizonSettingsVersion"
+	q := projects.Get(projectID).Update(func(project r.Term) r.Term {
+		return r.Expr(map[string]interface{}{
+			"HorizonSettings": r.Literal(settings),
+			hsv: map[string]interface{}{
+				"Desired": project.Field(hsv).Field("Desired").Default(0).Add(1),
+			},
+		})
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	return d.runProjectWrite(q)
+}
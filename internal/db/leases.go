@@ -0,0 +1,56 @@
+package db
+
+import (
+	"time"
+
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// AcquireLease tries to give holder the named lease for ttl, and reports
+// whether it did.  holder gets the lease if nobody holds it, it has
+// expired, or holder already has it, in which case it is renewed.  Expiry
+// goes by the database server's clock, so the holders' clocks don't have
+// to agree.
+func (d *DB) AcquireLease(
+	name string, holder string, ttl time.Duration) (bool, error) {
+	lease := map[string]interface{}{
+		"Holder":  holder,
+		"Expires": r.Now().Add(ttl.Seconds()),
+	}
+	q := leases.Get(name).Replace(func(l r.Term) interface{} {
+		return r.Branch(
+			l.Eq(nil),
+			r.Expr(lease).Merge(map[string]interface{}{"id": name}),
+			l.Field("Expires").Lt(r.Now()).Or(l.Field("Holder").Eq(holder)),
+			l.Merge(lease),
+			l)
+	})
+	res, err := q.RunWrite(d.session)
+	if err != nil {
+		return false, err
+	}
+	return res.Inserted == 1 || res.Replaced == 1, nil
+}
+
+// GetLease returns the named lease, or nil if nobody has ever held it.
+func (d *DB) GetLease(name string) (*types.Lease, error) {
+	var l types.Lease
+	err := runOne(leases.Get(name), d.session, &l)
+	if err == r.ErrEmptyResult {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// ReleaseLease gives up the named lease if holder has it, so that another
+// holder can take it without waiting for it to expire.
+func (d *DB) ReleaseLease(name string, holder string) error {
+	_, err := leases.Get(name).Replace(func(l r.Term) interface{} {
+		return r.Branch(l.Field("Holder").Default(nil).Eq(holder), nil, l)
+	}).RunWrite(d.session)
+	return err
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func (d *DB) SetOperation(op *types.Operation) error {
+	_, err := operations.Insert(op,
+		r.InsertOpts{Conflict: "replace"}).RunWrite(d.session)
+	return err
+}
+
+func (d *DB) getOperations(q r.Term) ([]*types.Operation, error) {
+	cursor, err := q.Run(d.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret []*types.Operation
+	err = cursor.All(&ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetOperations returns the newest limit operations of the project with
+// kube name kubeName, newest first.
+func (d *DB) GetOperations(
+	kubeName string, limit int) ([]*types.Operation, error) {
+	q := operations.Filter(r.Row.Field("Kube").Eq(kubeName)).
+		OrderBy(r.Desc("Started")).Limit(limit)
+	ops, err := d.getOperations(q)
+	if err != nil {
+		d.log.Error("Couldn't get operations of %v: %v", kubeName, err)
+		return nil, err
+	}
+	return ops, nil
+}
+
+// GetRunningOperations returns the operations that haven't finished, oldest
+// first.
+func (d *DB) GetRunningOperations() ([]*types.Operation, error) {
+	return d.getOperations(operations.Filter(
+		r.Row.Field("State").Eq(types.OperationRunning)).OrderBy("Started"))
+}
+
+// DeleteOperations forgets the operations of the project with kube name
+// kubeName.
+func (d *DB) DeleteOperations(kubeName string) error {
+	_, err := operations.Filter(r.Row.Field("Kube").Eq(kubeName)).
+		Delete().RunWrite(d.session)
+	return err
+}
@@ -0,0 +1,315 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+var (
+	ErrProjectExists   = errors.New("A project with that name already exists.")
+	ErrProjectDeleting = errors.New("That project is being deleted.")
+	ErrProjectMoving   = errors.New("That project is already being renamed or transferred.")
+)
+
+func (d *DB) UserExists(name string) (bool, error) {
+	var u hzUser
+	err := runOne(users.Get(name), d.session, &u)
+	if err == r.ErrEmptyResult {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *DB) insertProject(p *types.Project) error {
+	res, err := projects.Insert(p).RunWrite(d.session)
+	if err == nil && res.Errors != 0 {
+		err = errors.New(res.FirstError)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate primary key") {
+			return ErrProjectExists
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateProject adds a new project, returning ErrProjectExists if its ID
+// is taken (including by a project that is still being deleted).
+func (d *DB) CreateProject(p *types.Project) error {
+	return d.insertProject(p)
+}
+
+// MarkProjectDeleting asks the sync loop to tear a project down and then
+// remove its row.
+func (d *DB) MarkProjectDeleting(projectID types.ProjectID) error {
+	q := projects.Get(projectID).Update(map[string]interface{}{
+		"Deleting": true,
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	_, err := d.runProjectWrite(q)
+	return err
+}
+
+// SetProjectRuntime changes the versions of Horizon and RethinkDB a
+// project runs and bumps RuntimeVersion so that the sync loop upgrades it.
+func (d *DB) SetProjectRuntime(
+	projectID types.ProjectID, rt types.Runtime) (*types.Project, error) {
+	rv := "RuntimeVersion"
+	q := projects.Get(projectID).Update(func(project r.Term) r.Term {
+		return r.Expr(map[string]interface{}{
+			"Runtime": r.Literal(rt),
+			rv: map[string]interface{}{
+				"Desired": project.Field(rv).Field("Desired").Default(0).Add(1),
+			},
+		})
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	return d.runProjectWrite(q)
+}
+
+// RevertProjectRuntime sets the versions a project runs back to rt, after
+// the upgrade to RuntimeVersion desired failed and was undone.  It fails if
+// the project's runtime has been changed again since.
+func (d *DB) RevertProjectRuntime(
+	projectID types.ProjectID, desired int64, rt types.Runtime) error {
+	q := projects.Get(projectID).Update(func(project r.Term) r.Term {
+		return r.Branch(
+			project.Field("RuntimeVersion").Field("Desired").Default(0).Eq(desired),
+			map[string]interface{}{"Runtime": r.Literal(rt)},
+			r.Error("the runtime has been changed since"))
+	})
+	res, err := q.RunWrite(d.session)
+	if err == nil && res.Errors != 0 {
+		err = errors.New(res.FirstError)
+	}
+	return err
+}
+
+// failedVersion is true of the named ConfigVersion of project if it was
+// given up on.
+func failedVersion(project r.Term, name string) r.Term {
+	cv := project.Field(name)
+	desired := cv.Field("Desired").Default(0)
+	return cv.Field("Error").Default(0).Eq(desired).And(
+		cv.Field("Applied").Default(0).Ne(desired)).Default(false)
+}
+
+// GetFailedProjects returns the projects with a ConfigVersion that the
+// sync loop has given up on.
+func (d *DB) GetFailedProjects() ([]*types.Project, error) {
+	q := projects.Filter(func(project r.Term) r.Term {
+		failed := r.Expr(false)
+		for _, name := range types.ConfigVersionNames {
+			failed = failed.Or(failedVersion(project, name))
+		}
+		return failed
+	})
+	cursor, err := q.Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get failed projects: %v", err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var projects []*types.Project
+	var p *types.Project
+	for cursor.Next(&p) {
+		projects = append(projects, p)
+		p = nil
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// RequeueConfigVersion gives the named ConfigVersion of a project, which
+// must have been given up on, a fresh set of attempts.
+func (d *DB) RequeueConfigVersion(
+	projectID types.ProjectID, name string) (*types.Project, error) {
+	q := projects.Get(projectID).Update(func(project r.Term) r.Term {
+		return r.Branch(
+			failedVersion(project, name),
+			map[string]interface{}{
+				name: map[string]interface{}{
+					"Error":     0,
+					"Attempted": 0,
+					"Attempts":  0,
+				},
+			},
+			r.Error(name+" hasn't failed"))
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	return d.runProjectWrite(q)
+}
+
+// movableErr returns why p can't be renamed or transferred, or nil if it
+// can be.
+func movableErr(p *types.Project) error {
+	if p.Deleting {
+		return ErrProjectDeleting
+	}
+	if p.MovedTo != nil {
+		return ErrProjectMoving
+	}
+	return nil
+}
+
+// A moveStep is one of the writes MoveProject makes, and the write that
+// undoes it.
+type moveStep struct {
+	do   func() error
+	undo func() error
+}
+
+// runSteps runs steps in order.  If one fails, the ones before it are
+// undone, last first, and its error is returned, along with any from
+// undoing them.
+func runSteps(steps []moveStep) error {
+	for i, step := range steps {
+		err := step.do()
+		if err == nil {
+			continue
+		}
+		var undoErrs []string
+		for j := i - 1; j >= 0; j-- {
+			if steps[j].undo == nil {
+				continue
+			}
+			if undoErr := steps[j].undo(); undoErr != nil {
+				undoErrs = append(undoErrs, undoErr.Error())
+			}
+		}
+		if len(undoErrs) != 0 {
+			return fmt.Errorf("%v (and couldn't undo it: %s)",
+				err, strings.Join(undoErrs, ", "))
+		}
+		return err
+	}
+	return nil
+}
+
+// markMoving sets MovedTo on a project's row, unless it is being deleted
+// or moved already.
+func (d *DB) markMoving(id types.ProjectID, newID types.ProjectID) error {
+	q := projects.Get(id).Update(func(project r.Term) r.Term {
+		return r.Branch(
+			project.Field("Deleting").Default(false),
+			r.Error(ErrProjectDeleting.Error()),
+			r.Branch(
+				project.Field("MovedTo").Default(nil).Ne(nil),
+				r.Error(ErrProjectMoving.Error()),
+				map[string]interface{}{"MovedTo": newID}))
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	_, err := d.runProjectWrite(q)
+	if err != nil {
+		for _, known := range []error{ErrProjectDeleting, ErrProjectMoving} {
+			if strings.Contains(err.Error(), known.Error()) {
+				return known
+			}
+		}
+	}
+	return err
+}
+
+func (d *DB) unmarkMoving(id types.ProjectID) error {
+	res, err := projects.Get(id).Replace(r.Row.Without("MovedTo")).
+		RunWrite(d.session)
+	if err == nil && res.Errors != 0 {
+		err = errors.New(res.FirstError)
+	}
+	return err
+}
+
+// retireProject removes the row of a project that lives on under another
+// ID.  It's marked MovedTo first, so that the sync loop doesn't tear down
+// the cluster they share.
+func (d *DB) retireProject(id types.ProjectID, movedTo types.ProjectID) error {
+	res, err := projects.Get(id).Update(map[string]interface{}{
+		"MovedTo": movedTo,
+	}).RunWrite(d.session)
+	if err == nil && res.Errors != 0 {
+		err = errors.New(res.FirstError)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = projects.Get(id).Delete().RunWrite(d.session)
+	return err
+}
+
+// repointProject moves a project's domains, domain claims, releases and
+// backups from one ID to another.
+func (d *DB) repointProject(from types.ProjectID, to types.ProjectID) error {
+	moved := map[string]interface{}{"ProjectID": to}
+	_, err := domains.Filter(r.Row.Field("ProjectID").Eq(from)).
+		Update(moved).RunWrite(d.session)
+	if err != nil {
+		return err
+	}
+	for _, table := range []r.Term{releases, backups, claims} {
+		_, err = table.GetAllByIndex("ProjectID", from).
+			Update(moved).RunWrite(d.session)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveProject gives a project a new ID and set of users, carrying its
+// domains, domain claims, releases and backups along, and returns the moved
+// project.  Projects being deleted or moved already can't be moved.
+//
+// Primary keys can't change in place, so the old row is marked MovedTo,
+// the project is copied to a new row, and the old row is removed.  The
+// Kube name is stored in the new row, so the project keeps its cluster and
+// disks.  If any of that fails, what was done is undone.
+func (d *DB) MoveProject(old *types.Project,
+	newID types.ProjectID, newUsers []string) (*types.Project, error) {
+	if err := movableErr(old); err != nil {
+		return nil, err
+	}
+	p := *old
+	p.ID = newID
+	p.Users = newUsers
+	p.Kube = old.KubeName()
+
+	err := runSteps([]moveStep{
+		{
+			do:   func() error { return d.markMoving(old.ID, newID) },
+			undo: func() error { return d.unmarkMoving(old.ID) },
+		},
+		{
+			do:   func() error { return d.insertProject(&p) },
+			undo: func() error { return d.retireProject(newID, old.ID) },
+		},
+		{
+			do: func() error {
+				err := d.repointProject(old.ID, newID)
+				if err != nil {
+					// Some of the rows may have been moved already.
+					if undoErr := d.repointProject(newID, old.ID); undoErr != nil {
+						return fmt.Errorf("%v (and couldn't undo it: %v)", err, undoErr)
+					}
+				}
+				return err
+			},
+			undo: func() error { return d.repointProject(newID, old.ID) },
+		},
+		{
+			do: func() error {
+				_, err := projects.Get(old.ID).Delete().RunWrite(d.session)
+				return err
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func TestMovableErr(t *testing.T) {
+	movedTo := types.NewProjectID("bob", "app")
+	tests := []struct {
+		Project types.Project
+		Err     error
+	}{
+		{types.Project{}, nil},
+		{types.Project{Deleting: true}, ErrProjectDeleting},
+		{types.Project{MovedTo: &movedTo}, ErrProjectMoving},
+	}
+	for i, test := range tests {
+		if err := movableErr(&test.Project); err != test.Err {
+			t.Errorf("%d: got %v, want %v", i, err, test.Err)
+		}
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	errStep := errors.New("step failed")
+	errUndo := errors.New("undo failed")
+	tests := []struct {
+		Name     string
+		Fail     int // index of the step that fails, or -1
+		FailUndo int // index of the step whose undo fails, or -1
+		Undone   []int
+		Err      string
+	}{
+		{"success", -1, -1, nil, ""},
+		{"first step", 0, -1, nil, "step failed"},
+		{"second step", 1, -1, []int{0}, "step failed"},
+		{"last step", 3, -1, []int{2, 1, 0}, "step failed"},
+		{"undo fails", 3, 1, []int{2, 1, 0},
+			"step failed (and couldn't undo it: undo failed)"},
+	}
+	for _, test := range tests {
+		var ran, undone []int
+		var steps []moveStep
+		for i := 0; i < 4; i++ {
+			i := i
+			step := moveStep{
+				do: func() error {
+					ran = append(ran, i)
+					if i == test.Fail {
+						return errStep
+					}
+					return nil
+				},
+				undo: func() error {
+					undone = append(undone, i)
+					if i == test.FailUndo {
+						return errUndo
+					}
+					return nil
+				},
+			}
+			if i == 3 {
+				// Like removing the old row, the last step has no undo.
+				step.undo = nil
+			}
+			steps = append(steps, step)
+		}
+
+		err := runSteps(steps)
+		if test.Err == "" {
+			if err != nil {
+				t.Errorf("%s: got error %v", test.Name, err)
+			}
+			if len(ran) != len(steps) || undone != nil {
+				t.Errorf("%s: ran %v and undid %v", test.Name, ran, undone)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.Err {
+			t.Errorf("%s: got error %v, want %q", test.Name, err, test.Err)
+		}
+		if test.FailUndo < 0 && err != errStep {
+			t.Errorf("%s: error %v isn't the step's own", test.Name, err)
+		}
+		if len(ran) != test.Fail+1 {
+			t.Errorf("%s: ran %v, want steps up to %d", test.Name, ran, test.Fail)
+		}
+		if !reflect.DeepEqual(undone, test.Undone) {
+			t.Errorf("%s: undid %v, want %v", test.Name, undone, test.Undone)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func (d *DB) AddRelease(rel *types.Release) error {
+	_, err := releases.Insert(rel).RunWrite(d.session)
+	return err
+}
+
+func (d *DB) GetRelease(id string) (*types.Release, error) {
+	var rel types.Release
+	err := d.getBasicType(releases, "release", id, &rel)
+	if err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// GetReleases returns the releases of a project, newest first, without
+// their Files.
+func (d *DB) GetReleases(projectID types.ProjectID) ([]*types.Release, error) {
+	q := releases.GetAllByIndex("ProjectID", projectID).
+		OrderBy(r.Desc("Created")).Without("Files")
+	cursor, err := q.Run(d.session)
+	if err != nil {
+		d.log.Error("Couldn't get releases for %v: %v", projectID, err)
+		return nil, err
+	}
+	defer cursor.Close()
+	var ret []*types.Release
+	err = cursor.All(&ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SetActiveRelease points a project at one of its releases.  This is a
+// single document write, so visitors see either the old release or the
+// new one and never a mix.
+func (d *DB) SetActiveRelease(projectID types.ProjectID, releaseID string) error {
+	q := projects.Get(projectID).Update(map[string]interface{}{
+		"ActiveRelease": releaseID,
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	_, err := d.runProjectWrite(q)
+	return err
+}
+
+func (d *DB) DeleteRelease(id string) error {
+	_, err := releases.Get(id).Delete().RunWrite(d.session)
+	return err
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"strings"
+
+	r "github.com/dancannon/gorethink"
+)
+
+type tableSchema struct {
+	DB      string
+	Table   string
+	Indexes []string
+}
+
+// schema lists the tables hzc-api and hzc-http use beyond those of the
+// web_backend Horizon app, and their secondary indexes.
+var schema = []tableSchema{
+	{"web_backend", "backups", []string{"ProjectID"}},
+	{"web_backend_internal", "orphaned_disks", nil},
+	{"web_backend", "releases", []string{"ProjectID"}},
+	{"web_backend_internal", "blobs", nil},
+	{"web_backend_internal", "audit", nil},
+	{"web_backend", "domain_claims", []string{"ProjectID"}},
+	{"web_backend_internal", "certificates", nil},
+	{"web_backend_internal", "acme_challenges", nil},
+	{"web_backend_internal", "acme_accounts", nil},
+	{"web_backend_internal", "drift", nil},
+	{"web_backend_internal", "leases", nil},
+	{"web_backend_internal", "operations", nil},
+}
+
+func isAlreadyExists(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "already exists")
+}
+
+// EnsureSchema creates whichever of the tables and indexes in schema are
+// missing, and waits for the indexes to be ready.  It's safe to run from
+// several processes at once.
+func (d *DBConnection) EnsureSchema() error {
+	for _, ts := range schema {
+		var tables []string
+		cursor, err := r.DB(ts.DB).TableList().Run(d.session)
+		if err != nil {
+			return err
+		}
+		err = cursor.All(&tables)
+		cursor.Close()
+		if err != nil {
+			return err
+		}
+		if !contains(tables, ts.Table) {
+			_, err = r.DB(ts.DB).TableCreate(ts.Table).RunWrite(d.session)
+			if err != nil && !isAlreadyExists(err) {
+				return err
+			}
+		}
+
+		table := r.DB(ts.DB).Table(ts.Table)
+		var indexes []string
+		cursor, err = table.IndexList().Run(d.session)
+		if err != nil {
+			return err
+		}
+		err = cursor.All(&indexes)
+		cursor.Close()
+		if err != nil {
+			return err
+		}
+		for _, index := range ts.Indexes {
+			if contains(indexes, index) {
+				continue
+			}
+			_, err = table.IndexCreate(index).RunWrite(d.session)
+			if err != nil && !isAlreadyExists(err) {
+				return err
+			}
+		}
+		if len(ts.Indexes) > 0 {
+			cursor, err = table.IndexWait().Run(d.session)
+			if err != nil {
+				return err
+			}
+			cursor.Close()
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
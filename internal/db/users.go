@@ -0,0 +1,74 @@
+package db
+
+import (
+	"errors"
+
+	r "github.com/dancannon/gorethink"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// GetUserKeys returns the SSH keys of a user.
+func (d *DB) GetUserKeys(user string) ([]string, error) {
+	var u hzUser
+	err := d.getBasicType(users, "user", user, &u)
+	if err != nil {
+		return nil, err
+	}
+	return u.Data.PublicSSHKeys, nil
+}
+
+func (d *DB) AddUserKey(user string, key string) error {
+	q := users.Get(user).Update(map[string]interface{}{
+		"data": map[string]interface{}{
+			"keys": r.Row.Field("data").Field("keys").Default([]string{}).
+				SetInsert(key),
+		},
+	})
+	res, err := q.RunWrite(d.session)
+	if err != nil {
+		return err
+	}
+	if res.Skipped != 0 {
+		return errors.New("No such user.")
+	}
+	return nil
+}
+
+func (d *DB) RemoveUserKey(user string, key string) error {
+	q := users.Get(user).Update(map[string]interface{}{
+		"data": map[string]interface{}{
+			"keys": r.Row.Field("data").Field("keys").Default([]string{}).
+				SetDifference([]string{key}),
+		},
+	})
+	_, err := q.RunWrite(d.session)
+	return err
+}
+
+// AddProjectUser gives user access to a project and returns the project.
+func (d *DB) AddProjectUser(
+	projectID types.ProjectID, user string) (*types.Project, error) {
+	q := projects.Get(projectID).Update(map[string]interface{}{
+		"Users": r.Row.Field("Users").Default([]string{}).SetInsert(user),
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	return d.runProjectWrite(q)
+}
+
+// RemoveProjectUser takes away user's access to a project and returns the
+// project.
+func (d *DB) RemoveProjectUser(
+	projectID types.ProjectID, user string) (*types.Project, error) {
+	q := projects.Get(projectID).Update(map[string]interface{}{
+		"Users": r.Row.Field("Users").Default([]string{}).
+			SetDifference([]string{user}),
+	}, r.UpdateOpts{ReturnChanges: "always"})
+	return d.runProjectWrite(q)
+}
+
+func (d *DB) AddAuditRecord(rec *types.AuditRecord) error {
+	_, err := audit.Insert(rec).RunWrite(d.session)
+	if err != nil {
+		d.log.Error("Couldn't record %v for audit: %v", rec, err)
+	}
+	return err
+}
@@ -4,31 +4,39 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pborman/uuid"
 
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/jwt"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/cloud"
 	"google.golang.org/cloud/storage"
+	kapi "k8s.io/kubernetes/pkg/api"
 )
 
-type DiskType string
+var _ provider.Provider = &GCloud{}
 
-var (
-	DiskTypeStandard DiskType = "pd-standard"
-	DiskTypeSSD      DiskType = "pd-ssd"
-)
+var diskTypes = map[provider.DiskType]string{
+	provider.DiskTypeStandard: "pd-standard",
+	provider.DiskTypeSSD:      "pd-ssd",
+}
 
-type Disk struct {
-	Name   string
-	SizeGB int64
+func newDisk(d *compute.Disk) *provider.Disk {
+	return &provider.Disk{
+		Name:        d.Name,
+		SizeGB:      d.SizeGb,
+		Description: d.Description,
+	}
 }
 
 type GCloud struct {
+	account      *jwt.Config
 	client       *http.Client
 	compute      *compute.Service
 	storage      *storage.Client
@@ -59,23 +67,47 @@ func New(serviceAccount *jwt.Config, project string, zone string) (*GCloud, erro
 		return nil, err
 	}
 
-	return &GCloud{client, computeSv, storageClient, storageAdminClient,
+	return &GCloud{serviceAccount, client, computeSv, storageClient, storageAdminClient,
 		project, zone}, nil
 }
 
-func (g *GCloud) CreateDisk(sizeGB int64, disktype DiskType) (*Disk, error) {
-	name := "uuid-" + uuid.New()
+func (g *GCloud) diskTypeURL(disktype provider.DiskType) string {
+	return "https://www.googleapis.com/compute/v1/projects/" +
+		g.project + "/zones/" + g.zone + "/diskTypes/" + diskTypes[disktype]
+}
 
-	diskTypeURL := "https://www.googleapis.com/compute/v1/projects/" +
-		g.project + "/zones/" + g.zone + "/diskTypes/" + string(disktype)
+// CreateDisk creates a new disk named `uuid-<random uuid>`.  The
+// description is stored on the disk and returned by GetDisk and ListDisks.
+func (g *GCloud) CreateDisk(
+	sizeGB int64, disktype provider.DiskType, description string) (*provider.Disk, error) {
+	return g.createDisk(&compute.Disk{
+		Name:        "uuid-" + uuid.New(),
+		SizeGb:      sizeGB,
+		Type:        g.diskTypeURL(disktype),
+		Description: description,
+	})
+}
+
+// CreateDiskFromSnapshot creates a new disk holding the contents of the
+// given snapshot.  sizeGB must be at least the size of the snapshotted disk.
+func (g *GCloud) CreateDiskFromSnapshot(
+	snapshot string, sizeGB int64, disktype provider.DiskType, description string) (*provider.Disk, error) {
+	return g.createDisk(&compute.Disk{
+		Name:        "uuid-" + uuid.New(),
+		SizeGb:      sizeGB,
+		Type:        g.diskTypeURL(disktype),
+		Description: description,
+		SourceSnapshot: "https://www.googleapis.com/compute/v1/projects/" +
+			g.project + "/global/snapshots/" + snapshot,
+	})
+}
+
+func (g *GCloud) createDisk(d *compute.Disk) (*provider.Disk, error) {
+	name := d.Name
 
 	log.Printf("Creating disk %v", name)
 
-	_, err := g.compute.Disks.Insert(g.project, g.zone, &compute.Disk{
-		Name:   name,
-		SizeGb: sizeGB,
-		Type:   diskTypeURL,
-	}).Do()
+	_, err := g.compute.Disks.Insert(g.project, g.zone, d).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -88,27 +120,157 @@ func (g *GCloud) CreateDisk(sizeGB int64, disktype DiskType) (*Disk, error) {
 		}
 
 		switch disk.Status {
-		case "CREATING":
+		case "CREATING", "RESTORING":
 			// do nothing, repeat
 		case "FAILED":
 			return nil, fmt.Errorf("disk failed to create")
 		case "READY":
-			return &Disk{
-				Name:   disk.Name,
-				SizeGB: disk.SizeGb,
-			}, nil
+			return newDisk(disk), nil
 		default:
 			return nil, fmt.Errorf("disk entered unexpected status %#v", disk.Status)
 		}
 	}
 }
 
+func (g *GCloud) GetDisk(name string) (*provider.Disk, error) {
+	disk, err := g.compute.Disks.Get(g.project, g.zone, name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return newDisk(disk), nil
+}
+
+// ListDisks returns all disks in the zone whose names start with prefix.
+func (g *GCloud) ListDisks(prefix string) ([]*provider.Disk, error) {
+	var ret []*provider.Disk
+	pageToken := ""
+	for {
+		call := g.compute.Disks.List(g.project, g.zone)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		list, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, disk := range list.Items {
+			if strings.HasPrefix(disk.Name, prefix) {
+				ret = append(ret, newDisk(disk))
+			}
+		}
+		if list.NextPageToken == "" {
+			return ret, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// ResizeDisk grows a disk to sizeGB and waits for the resize to finish.
+// GCE does not support shrinking disks.  The filesystem on the disk is not
+// touched; it has to be grown separately.
+func (g *GCloud) ResizeDisk(name string, sizeGB int64) error {
+	log.Printf("Resizing disk %v to %vGB", name, sizeGB)
+
+	_, err := g.compute.Disks.Resize(g.project, g.zone, name, &compute.DisksResizeRequest{
+		SizeGb: sizeGB,
+	}).Do()
+	if err != nil {
+		return err
+	}
+
+	for {
+		time.Sleep(time.Second)
+		disk, err := g.compute.Disks.Get(g.project, g.zone, name).Do()
+		if err != nil {
+			return err
+		}
+
+		switch disk.Status {
+		case "READY":
+			if disk.SizeGb >= sizeGB {
+				return nil
+			}
+			// the resize hasn't been picked up yet, repeat
+		case "CREATING", "RESTORING":
+			// do nothing, repeat
+		case "FAILED":
+			return fmt.Errorf("disk failed to resize")
+		default:
+			return fmt.Errorf("disk entered unexpected status %#v", disk.Status)
+		}
+	}
+}
+
 func (g *GCloud) DeleteDisk(name string) error {
 	log.Printf("Deleting disk %v", name)
 	_, err := g.compute.Disks.Delete(g.project, g.zone, name).Do()
 	return err
 }
 
+// CreateSnapshot takes a snapshot of the named disk and waits for it to be
+// uploaded.  The disk may be in use; the snapshot is crash-consistent.
+func (g *GCloud) CreateSnapshot(diskName string) (*provider.Snapshot, error) {
+	name := "snap-" + uuid.New()
+
+	log.Printf("Creating snapshot %v of disk %v", name, diskName)
+
+	_, err := g.compute.Disks.CreateSnapshot(g.project, g.zone, diskName, &compute.Snapshot{
+		Name: name,
+	}).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		time.Sleep(time.Second)
+		snap, err := g.compute.Snapshots.Get(g.project, name).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		switch snap.Status {
+		case "CREATING", "UPLOADING":
+			// do nothing, repeat
+		case "FAILED":
+			return nil, fmt.Errorf("snapshot failed to create")
+		case "READY":
+			return &provider.Snapshot{
+				Name:       snap.Name,
+				SourceDisk: diskName,
+				SizeGB:     snap.DiskSizeGb,
+			}, nil
+		default:
+			return nil, fmt.Errorf("snapshot entered unexpected status %#v", snap.Status)
+		}
+	}
+}
+
+func (g *GCloud) DeleteSnapshot(name string) error {
+	log.Printf("Deleting snapshot %v", name)
+	_, err := g.compute.Snapshots.Delete(g.project, name).Do()
+	return err
+}
+
+func (g *GCloud) VolumeSource(diskName string) kapi.VolumeSource {
+	return kapi.VolumeSource{
+		GCEPersistentDisk: &kapi.GCEPersistentDiskVolumeSource{
+			PDName: diskName,
+			FSType: "ext4",
+		},
+	}
+}
+
+func (g *GCloud) VolumeDisk(vol *kapi.VolumeSource) string {
+	if vol.GCEPersistentDisk == nil {
+		return ""
+	}
+	return vol.GCEPersistentDisk.PDName
+}
+
+func (g *GCloud) Objects() provider.ObjectStore {
+	return &GCS{g.storage, g.account}
+}
+
 func (g *GCloud) StorageClient() *storage.Client {
 	return g.storage
 }
@@ -0,0 +1,125 @@
+package gcloud
+
+import (
+	"encoding/base64"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/cloud/storage"
+
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+)
+
+var _ provider.ObjectStore = &GCS{}
+
+// GCS is an object store backed by Google Cloud Storage.  The service
+// account is used to sign upload URLs.
+type GCS struct {
+	client  *storage.Client
+	account *jwt.Config
+}
+
+func newObjectAttrs(attrs *storage.ObjectAttrs) *provider.ObjectAttrs {
+	return &provider.ObjectAttrs{
+		Name:        attrs.Name,
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		MD5:         attrs.MD5,
+	}
+}
+
+func (s *GCS) List(bucket, prefix string) ([]*provider.ObjectAttrs, error) {
+	var ret []*provider.ObjectAttrs
+	listQ := &storage.Query{Prefix: prefix}
+	for listQ != nil {
+		list, err := s.client.Bucket(bucket).List(nil, listQ)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Results {
+			ret = append(ret, newObjectAttrs(item))
+		}
+		listQ = list.Next
+	}
+	return ret, nil
+}
+
+func (s *GCS) Stat(bucket, name string) (*provider.ObjectAttrs, error) {
+	attrs, err := s.client.Bucket(bucket).Object(name).Attrs(nil)
+	if err == storage.ErrObjectNotExist {
+		return nil, provider.ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newObjectAttrs(attrs), nil
+}
+
+func (s *GCS) NewReader(bucket, name string) (io.ReadCloser, error) {
+	rd, err := s.client.Bucket(bucket).Object(name).NewReader(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return nil, provider.ErrObjectNotExist
+	}
+	return rd, err
+}
+
+func (s *GCS) NewWriter(bucket, name, contentType string) provider.ObjectWriter {
+	w := s.client.Bucket(bucket).Object(name).NewWriter(context.Background())
+	w.ContentType = contentType
+	return w
+}
+
+func (s *GCS) Copy(srcBucket, srcName, dstBucket, dstName string) error {
+	src := s.client.Bucket(srcBucket).Object(srcName)
+	attrs, err := src.Attrs(nil)
+	if err != nil {
+		return err
+	}
+	_, err = src.CopyTo(nil, s.client.Bucket(dstBucket).Object(dstName), attrs)
+	return err
+}
+
+func (s *GCS) Delete(bucket, name string) error {
+	err := s.client.Bucket(bucket).Object(name).Delete(nil)
+	if err == storage.ErrObjectNotExist {
+		return provider.ErrObjectNotExist
+	}
+	return err
+}
+
+func (s *GCS) SignPut(bucket, name, contentType string, md5 []byte,
+	expires time.Time) (*provider.SignedRequest, error) {
+	md5base64 := base64.StdEncoding.EncodeToString(md5)
+
+	signedURL, err := storage.SignedURL(bucket, name, &storage.SignedURLOptions{
+		GoogleAccessID: s.account.Email,
+		PrivateKey:     s.account.PrivateKey,
+		ContentType:    contentType,
+		Method:         "PUT",
+		Expires:        expires,
+		MD5:            []byte(md5base64),
+		Headers: []string{
+			"x-goog-acl:public-read\n",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider.SignedRequest{
+		Method: "PUT",
+		URL:    signedURL,
+		Headers: map[string]string{
+			"Content-Type":  contentType,
+			"Cache-Control": "private,no-cache",
+			"Content-MD5":   md5base64,
+			"x-goog-acl":    "public-read",
+		},
+	}, nil
+}
+
+func (s *GCS) PublicURL() string {
+	return "https://storage.googleapis.com/"
+}
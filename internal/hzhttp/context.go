@@ -2,9 +2,9 @@ package hzhttp
 
 import (
 	"github.com/rethinkdb/horizon-cloud/internal/db"
-	"github.com/rethinkdb/horizon-cloud/internal/gcloud"
 	"github.com/rethinkdb/horizon-cloud/internal/hzlog"
 	"github.com/rethinkdb/horizon-cloud/internal/kube"
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
 	"golang.org/x/oauth2/jwt"
 )
 
@@ -13,7 +13,8 @@ type Context struct {
 	LogContext     *hzlog.Logger
 	DBConn         *db.DBConnection
 	ServiceAccount *jwt.Config
-	GCloud         *gcloud.GCloud
+	Provider       provider.Provider
+	Objects        provider.ObjectStore
 	Kube           *kube.Kube
 }
 
@@ -82,8 +83,11 @@ func (c *Context) WithParts(cpart *Context) *Context {
 	if cpart.Kube != nil {
 		out.Kube = cpart.Kube
 	}
-	if cpart.GCloud != nil {
-		out.GCloud = cpart.GCloud
+	if cpart.Provider != nil {
+		out.Provider = cpart.Provider
+	}
+	if cpart.Objects != nil {
+		out.Objects = cpart.Objects
 	}
 	return &out
 }
@@ -0,0 +1,185 @@
+package kube
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// Dumps are staged on the data disk rather than in the container, which
+// may not have room for them.
+const rdbDumpPath = "/data/hzc-dump.tar.gz"
+
+func (k *Kube) rdbPod(trueName string) (string, error) {
+	rc, err := k.getRC(rdbRCName(trueName, 0))
+	if err != nil {
+		return "", err
+	}
+	if rc == nil {
+		return "", fmt.Errorf("no RethinkDB replica for %s", trueName)
+	}
+	pods, err := k.runningPods(rc.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	if len(pods) == 0 {
+		return "", fmt.Errorf("no running RethinkDB pods for %s", trueName)
+	}
+	return pods[0], nil
+}
+
+// SnapshotRDB snapshots the disk behind the project's first RethinkDB
+// replica and returns the name of the snapshot.
+func (k *Kube) SnapshotRDB(trueName string) (string, error) {
+	rc, err := k.getRC(rdbRCName(trueName, 0))
+	if err != nil {
+		return "", err
+	}
+	if rc == nil {
+		return "", fmt.Errorf("no RethinkDB replica for %s", trueName)
+	}
+	volName, err := k.rcVolume(rc)
+	if err != nil {
+		return "", err
+	}
+	snap, err := k.P.CreateSnapshot(volName)
+	if err != nil {
+		return "", err
+	}
+	return snap.Name, nil
+}
+
+// DumpRDB runs `rethinkdb-dump` against the project's database and writes
+// the resulting archive to w.
+func (k *Kube) DumpRDB(trueName string, w io.Writer) error {
+	pod, err := k.rdbPod(trueName)
+	if err != nil {
+		return err
+	}
+	_, stderr, err := k.Exec(ExecOptions{
+		PodName: pod,
+		Out:     w,
+		Command: []string{"/bin/sh", "-c",
+			"rm -f " + rdbDumpPath +
+				" && rethinkdb-dump -c localhost:28015 -f " + rdbDumpPath + " >&2" +
+				" && cat " + rdbDumpPath +
+				"; status=$?; rm -f " + rdbDumpPath + "; exit $status"},
+	})
+	if err != nil {
+		return fmt.Errorf("rethinkdb-dump failed: %v\nStderr:\n%v", err, stderr)
+	}
+	return nil
+}
+
+// RestoreRDBFromSnapshot replaces the project's RethinkDB replica with one
+// running on a new disk created from snapshot.  The snapshot may be of a
+// smaller disk, so the filesystem is grown to fill the new one before the
+// replica starts.
+func (k *Kube) RestoreRDBFromSnapshot(
+	trueName string, conf types.KubeConfig, snapshot string) error {
+	return k.replaceRDB(trueName, conf,
+		func() (*provider.Disk, error) {
+			disk, err := k.P.CreateDiskFromSnapshot(snapshot, int64(conf.SizeRDB),
+				provider.DiskTypeSSD, k.diskDescription(trueName))
+			if err != nil {
+				return nil, err
+			}
+			err = k.resizeFilesystem(trueName, conf, 0, disk.Name, "")
+			if err != nil {
+				return nil, compositeErr(err, k.P.DeleteDisk(disk.Name))
+			}
+			return disk, nil
+		}, nil)
+}
+
+// RestoreRDBFromDump replaces the project's RethinkDB replica with one
+// running on a new, empty disk and loads the archive read from dump into
+// it.
+func (k *Kube) RestoreRDBFromDump(
+	trueName string, conf types.KubeConfig, dump io.Reader) error {
+	return k.replaceRDB(trueName, conf,
+		func() (*provider.Disk, error) {
+			return k.P.CreateDisk(int64(conf.SizeRDB),
+				provider.DiskTypeSSD, k.diskDescription(trueName))
+		},
+		func(pod string) error {
+			_, stderr, err := k.Exec(ExecOptions{
+				PodName: pod,
+				In:      dump,
+				Command: []string{"/bin/sh", "-c",
+					"rm -f " + rdbDumpPath +
+						" && cat > " + rdbDumpPath +
+						" && rethinkdb-restore -c localhost:28015 --force " + rdbDumpPath + " >&2" +
+						"; status=$?; rm -f " + rdbDumpPath + "; exit $status"},
+			})
+			if err != nil {
+				return fmt.Errorf("rethinkdb-restore failed: %v\nStderr:\n%v", err, stderr)
+			}
+			return nil
+		})
+}
+
+// replaceRDB rebuilds the `r0-` RC on a disk returned by newDisk, then calls
+// load (if non-nil) with the name of the new pod.  The old disk is left in
+// place so that a bad restore can be undone by hand.
+func (k *Kube) replaceRDB(
+	trueName string,
+	conf types.KubeConfig,
+	newDisk func() (*provider.Disk, error),
+	load func(pod string) error) error {
+
+	// The other replicas would still hold the old data and their own idea
+	// of the cluster's configuration.
+	if conf.NumRDB != 1 {
+		return &ConfigError{
+			"Restoring a backup is only supported for projects with NumRDB = 1."}
+	}
+
+	name := rdbRCName(trueName, 0)
+	old, err := k.getRC(name)
+	if err != nil {
+		return err
+	}
+
+	vol, err := newDisk()
+	if err != nil {
+		return err
+	}
+
+	// The new replica runs the same RethinkDB as the one it replaces.
+	var images Images
+	if old != nil {
+		images.RethinkDB = old.Spec.Template.Spec.Containers[0].Image
+		oldVol, err := k.rcVolume(old)
+		if err != nil {
+			return compositeErr(err, k.P.DeleteDisk(vol.Name))
+		}
+		log.Printf("replacing %s, leaving volume %s in place", name, oldVol)
+		err = k.DeleteRC(old)
+		if err != nil {
+			return compositeErr(err, k.P.DeleteDisk(vol.Name))
+		}
+	}
+
+	replica, err := k.CreateRDBReplica(trueName, conf, 0, vol.Name, images)
+	if err != nil {
+		return compositeErr(err, k.P.DeleteDisk(vol.Name))
+	}
+
+	err = k.waitRC(replica.RC)
+	if err != nil {
+		return err
+	}
+
+	if load == nil {
+		return nil
+	}
+	pod, err := k.rdbPod(trueName)
+	if err != nil {
+		return err
+	}
+	return load(pod)
+}
@@ -0,0 +1,323 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/rethinkdb/horizon-cloud/internal/manifest"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// containerDrift compares the parts of live that manifests set, other than
+// the image, with want.  Fields the API server fills in with defaults
+// aren't compared, so that a container created from want has no drift.
+func containerDrift(live, want *kapi.Container) []string {
+	var ret []string
+	if !kapi.Semantic.DeepEqual(live.Command, want.Command) {
+		ret = append(ret, fmt.Sprintf("container %s runs %q instead of %q",
+			want.Name, live.Command, want.Command))
+	}
+	if !kapi.Semantic.DeepEqual(live.Env, want.Env) {
+		ret = append(ret, fmt.Sprintf(
+			"container %s has a different environment", want.Name))
+	}
+	if !kapi.Semantic.DeepEqual(live.Resources.Limits, want.Resources.Limits) {
+		ret = append(ret, fmt.Sprintf("container %s has limits %v instead of %v",
+			want.Name, live.Resources.Limits, want.Resources.Limits))
+	}
+	if !kapi.Semantic.DeepEqual(live.VolumeMounts, want.VolumeMounts) {
+		ret = append(ret, fmt.Sprintf(
+			"container %s has different volume mounts", want.Name))
+	}
+	if !kapi.Semantic.DeepEqual(live.Ports, want.Ports) {
+		ret = append(ret, fmt.Sprintf(
+			"container %s has different ports", want.Name))
+	}
+	return ret
+}
+
+// podTemplateDrift describes how live differs from want, which must have
+// been rendered with the same image.  An empty result means that updating
+// live to want would change nothing that matters.
+func podTemplateDrift(live, want *kapi.PodTemplateSpec) []string {
+	var ret []string
+	if live.Annotations[specHashAnnotation] != want.Annotations[specHashAnnotation] {
+		ret = append(ret, "pod template is out of date with the manifest")
+	}
+	for i := range want.Spec.Containers {
+		w := &want.Spec.Containers[i]
+		var l *kapi.Container
+		for j := range live.Spec.Containers {
+			if live.Spec.Containers[j].Name == w.Name {
+				l = &live.Spec.Containers[j]
+			}
+		}
+		if l == nil {
+			ret = append(ret, fmt.Sprintf("container %s is missing", w.Name))
+			continue
+		}
+		ret = append(ret, containerDrift(l, w)...)
+	}
+	return ret
+}
+
+func podReady(pod *kapi.Pod) bool {
+	if pod.Status.Phase != kapi.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == kapi.PodReady {
+			return condition.Status == kapi.ConditionTrue
+		}
+	}
+	return false
+}
+
+// readyPods returns the number of pods matching selector that are running
+// and ready.
+func (k *Kube) readyPods(selector map[string]string) (int, error) {
+	podlist, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector),
+	})
+	if err != nil {
+		return 0, err
+	}
+	ready := 0
+	for i := range podlist.Items {
+		if podReady(&podlist.Items[i]) {
+			ready++
+		}
+	}
+	return ready, nil
+}
+
+// serviceDrift compares the live service want.Name with want.
+func (k *Kube) serviceDrift(want *kapi.Service) ([]types.Drift, error) {
+	object := "service/" + want.Name
+	svc, err := k.getService(want.Name)
+	if err != nil {
+		return nil, err
+	}
+	if svc == nil {
+		return []types.Drift{{Object: object, Problem: "is missing"}}, nil
+	}
+	var drift []types.Drift
+	if !kapi.Semantic.DeepEqual(svc.Spec.Selector, want.Spec.Selector) {
+		drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+			"selects %v instead of %v", svc.Spec.Selector, want.Spec.Selector)})
+	}
+	for _, port := range want.Spec.Ports {
+		found := false
+		for _, p := range svc.Spec.Ports {
+			found = found || p.Port == port.Port
+		}
+		if !found {
+			drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+				"doesn't expose port %d (%s)", port.Port, port.Name)})
+		}
+	}
+	return drift, nil
+}
+
+// rdbDrift compares a project's RethinkDB service and replicas with conf.
+// The schema job isn't compared, since it only has to have run once.
+func (k *Kube) rdbDrift(trueName string,
+	conf types.KubeConfig, images Images) ([]types.Drift, error) {
+	objs, err := k.renderManifest(manifest.RethinkDB,
+		ManifestParams(trueName, conf, nil, images))
+	if err != nil {
+		return nil, err
+	}
+	var drift []types.Drift
+	for _, o := range objs {
+		if svc, ok := o.(*kapi.Service); ok {
+			d, err := k.serviceDrift(svc)
+			if err != nil {
+				return nil, err
+			}
+			drift = append(drift, d...)
+		}
+	}
+
+	rcs, err := k.C.ReplicationControllers(k.userNamespace).List(kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			"app":     "rethinkdb",
+			"project": trueName,
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]*kapi.ReplicationController, len(rcs.Items))
+	for i := range rcs.Items {
+		live[rcs.Items[i].Name] = &rcs.Items[i]
+	}
+
+	for i := 0; i < conf.NumRDB; i++ {
+		name := rdbRCName(trueName, i)
+		object := "rc/" + name
+		rc := live[name]
+		delete(live, name)
+		if rc == nil {
+			drift = append(drift, types.Drift{Object: object, Problem: "is missing"})
+			continue
+		}
+		volName, err := k.rcVolume(rc)
+		if err != nil {
+			return nil, err
+		}
+		want, err := k.renderRDBReplica(trueName, conf, i, volName, images)
+		if err != nil {
+			return nil, err
+		}
+		if rc.Spec.Replicas != want.Spec.Replicas {
+			drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+				"has %d replicas instead of %d", rc.Spec.Replicas, want.Spec.Replicas)})
+		}
+		image := rc.Spec.Template.Spec.Containers[0].Image
+		wantImage := want.Spec.Template.Spec.Containers[0].Image
+		if image != wantImage {
+			drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+				"runs %s instead of %s", image, wantImage)})
+		}
+		want.Spec.Template.Spec.Containers[0].Image = image
+		for _, problem := range podTemplateDrift(rc.Spec.Template, want.Spec.Template) {
+			drift = append(drift, types.Drift{Object: object, Problem: problem})
+		}
+		ready, err := k.readyPods(rc.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if ready < int(want.Spec.Replicas) {
+			drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+				"has %d of %d pods ready", ready, want.Spec.Replicas)})
+		}
+	}
+	for name := range live {
+		drift = append(drift, types.Drift{Object: "rc/" + name, Problem: fmt.Sprintf(
+			"isn't in the config, which has %d replicas", conf.NumRDB)})
+	}
+	return drift, nil
+}
+
+// horizonDrift compares a project's Horizon Deployment, service and env
+// Secret with conf and env.
+func (k *Kube) horizonDrift(trueName string, conf types.KubeConfig,
+	env map[string]string, images Images) ([]types.Drift, error) {
+	want, wantSVC, err := k.renderHorizon(trueName, conf, images, env)
+	if err != nil {
+		return nil, err
+	}
+	drift, err := k.serviceDrift(wantSVC)
+	if err != nil {
+		return nil, err
+	}
+
+	object := "deployment/" + want.Name
+	d, err := k.getDeployment(want.Name)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		problem := "is missing"
+		legacy, err := k.getRC(legacyHorizonRCName(trueName))
+		if err != nil {
+			return nil, err
+		}
+		if legacy != nil {
+			problem = "is missing; Horizon still runs as rc/" + legacy.Name
+		}
+		drift = append(drift, types.Drift{Object: object, Problem: problem})
+	} else {
+		if d.Spec.Replicas != want.Spec.Replicas {
+			drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+				"has %d replicas instead of %d", d.Spec.Replicas, want.Spec.Replicas)})
+		}
+		image := d.Spec.Template.Spec.Containers[0].Image
+		wantImage := want.Spec.Template.Spec.Containers[0].Image
+		if image != wantImage {
+			drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+				"runs %s instead of %s", image, wantImage)})
+		}
+		want.Spec.Template.Spec.Containers[0].Image = image
+		for _, problem := range podTemplateDrift(&d.Spec.Template, &want.Spec.Template) {
+			drift = append(drift, types.Drift{Object: object, Problem: problem})
+		}
+		if d.Status.AvailableReplicas < d.Spec.Replicas {
+			drift = append(drift, types.Drift{Object: object, Problem: fmt.Sprintf(
+				"has %d of %d pods available",
+				d.Status.AvailableReplicas, d.Spec.Replicas)})
+		}
+	}
+
+	if len(env) != 0 {
+		object := "secret/" + envSecretName(trueName)
+		secret, err := k.C.Secrets(k.userNamespace).Get(envSecretName(trueName))
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		if err != nil {
+			drift = append(drift, types.Drift{Object: object, Problem: "is missing"})
+		} else {
+			same := len(secret.Data) == len(env)
+			for name, value := range env {
+				same = same && bytes.Equal(secret.Data[name], []byte(value))
+			}
+			if !same {
+				drift = append(drift, types.Drift{Object: object,
+					Problem: "doesn't match the project's environment"})
+			}
+		}
+	}
+	return drift, nil
+}
+
+// ProjectDrift compares a project's objects in the cluster with the ones
+// conf, env and images render to, and describes every difference.  Besides
+// changes made to the objects, it reports objects that are missing and
+// pods that aren't ready.
+func (k *Kube) ProjectDrift(trueName string, conf types.KubeConfig,
+	env map[string]string, images Images) ([]types.Drift, error) {
+	rdb, err := k.rdbDrift(trueName, conf, images)
+	if err != nil {
+		return nil, err
+	}
+	horizon, err := k.horizonDrift(trueName, conf, env, images)
+	if err != nil {
+		return nil, err
+	}
+	return append(rdb, horizon...), nil
+}
+
+// CorrectDrift brings a project's objects back in line with conf, env and
+// images: changed objects are updated, missing ones are recreated, and
+// everything is moved back to images.  A missing RethinkDB replica is the
+// exception, since replacing it would mean starting over on an empty disk
+// while the old one may hold the only copy of the data; that's left for an
+// operator.
+func (k *Kube) CorrectDrift(trueName string, conf types.KubeConfig,
+	env map[string]string, images Images) error {
+	for i := 0; i < conf.NumRDB; i++ {
+		name := rdbRCName(trueName, i)
+		rc, err := k.getRC(name)
+		if err != nil {
+			return err
+		}
+		if rc == nil {
+			return fmt.Errorf(
+				"not replacing missing %s, whose disk may still hold data", name)
+		}
+	}
+	p, err := k.EnsureProject(trueName, conf, env, images)
+	if err != nil {
+		return err
+	}
+	err = k.Wait(p)
+	if err != nil {
+		return err
+	}
+	return k.UpgradeProject(trueName, conf, images)
+}
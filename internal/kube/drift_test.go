@@ -0,0 +1,77 @@
+package kube
+
+import (
+	"testing"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+func testTemplate() *kapi.PodTemplateSpec {
+	return &kapi.PodTemplateSpec{
+		ObjectMeta: kapi.ObjectMeta{
+			Annotations: map[string]string{specHashAnnotation: "abc"},
+		},
+		Spec: kapi.PodSpec{
+			Containers: []kapi.Container{{
+				Name:  "horizon",
+				Image: "horizon:1",
+				Env:   []kapi.EnvVar{{Name: "HZ_DEBUG", Value: "yes"}},
+				Resources: kapi.ResourceRequirements{
+					Limits: kapi.ResourceList{
+						kapi.ResourceCPU: resource.MustParse("50m"),
+					},
+				},
+				Ports: []kapi.ContainerPort{{
+					Name:          "horizon",
+					ContainerPort: 8181,
+					Protocol:      kapi.ProtocolTCP,
+				}},
+			}},
+		},
+	}
+}
+
+func TestPodTemplateDrift(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Change func(live *kapi.PodTemplateSpec)
+		Drift  int
+	}{
+		{"unchanged", func(live *kapi.PodTemplateSpec) {}, 0},
+		{"image", func(live *kapi.PodTemplateSpec) {
+			live.Spec.Containers[0].Image = "horizon:2"
+		}, 0},
+		{"defaults", func(live *kapi.PodTemplateSpec) {
+			c := &live.Spec.Containers[0]
+			c.ImagePullPolicy = kapi.PullIfNotPresent
+			c.TerminationMessagePath = "/dev/termination-log"
+			c.Resources.Requests = c.Resources.Limits
+			live.Spec.DNSPolicy = kapi.DNSClusterFirst
+		}, 0},
+		{"hash", func(live *kapi.PodTemplateSpec) {
+			live.Annotations[specHashAnnotation] = "def"
+		}, 1},
+		{"env", func(live *kapi.PodTemplateSpec) {
+			live.Spec.Containers[0].Env[0].Value = "no"
+		}, 1},
+		{"limits", func(live *kapi.PodTemplateSpec) {
+			live.Spec.Containers[0].Resources.Limits[kapi.ResourceCPU] =
+				resource.MustParse("1")
+		}, 1},
+		{"command", func(live *kapi.PodTemplateSpec) {
+			live.Spec.Containers[0].Command = []string{"sleep", "1000"}
+		}, 1},
+		{"container", func(live *kapi.PodTemplateSpec) {
+			live.Spec.Containers[0].Name = "sidecar"
+		}, 1},
+	}
+	for _, test := range tests {
+		live := testTemplate()
+		test.Change(live)
+		drift := podTemplateDrift(live, testTemplate())
+		if len(drift) != test.Drift {
+			t.Errorf("%s: got drift %q, want %d problems", test.Name, drift, test.Drift)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package kube
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/manifest"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+)
+
+func envSecretName(trueName string) string {
+	return manifest.EnvSecretName(trueName)
+}
+
+// envHash returns a hash of env, or "" for an empty env.  It goes into the
+// spec hash of Horizon pods, since changing the values in the env Secret
+// doesn't change the pod spec.
+func envHash(env map[string]string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, name := range envNames(env) {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(name), name, len(env[name]), env[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ensureEnvSecret creates or updates the Secret holding a project's env.
+// It does nothing for an empty env.
+func (k *Kube) ensureEnvSecret(trueName string, env map[string]string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	data := make(map[string][]byte, len(env))
+	for name, value := range env {
+		data[name] = []byte(value)
+	}
+
+	secrets := k.C.Secrets(k.userNamespace)
+	secret, err := secrets.Get(envSecretName(trueName))
+	if err != nil {
+		if !isNotFound(err) {
+			return err
+		}
+		_, err = secrets.Create(&kapi.Secret{
+			ObjectMeta: kapi.ObjectMeta{
+				Name: envSecretName(trueName),
+				Labels: map[string]string{
+					"app":     "horizon",
+					"project": trueName,
+				},
+			},
+			Data: data,
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("created %s.", envSecretName(trueName))
+		return nil
+	}
+	secret.Data = data
+	_, err = secrets.Update(secret)
+	return err
+}
+
+// deleteEnvSecret deletes a project's env Secret, if it has one.
+func (k *Kube) deleteEnvSecret(trueName string) error {
+	err := k.C.Secrets(k.userNamespace).Delete(envSecretName(trueName))
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package kube
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/manifest"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kext "k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// horizonName is the name of both the Deployment and the service of a
+// project's Horizon servers.
+func horizonName(trueName string) string {
+	return "h-" + trueName
+}
+
+// legacyHorizonRCName is the name of the RC that ran a project's Horizon
+// servers before they ran as a Deployment.
+func legacyHorizonRCName(trueName string) string {
+	return "h0-" + trueName
+}
+
+// renderHorizon returns the Deployment and service of a project's Horizon
+// servers, with the Deployment's pods given env.
+func (k *Kube) renderHorizon(trueName string, conf types.KubeConfig,
+	images Images, env map[string]string) (*kext.Deployment, *kapi.Service, error) {
+	objs, err := k.renderManifest(manifest.Horizon,
+		ManifestParams(trueName, conf, envNames(env), images))
+	if err != nil {
+		return nil, nil, err
+	}
+	var d *kext.Deployment
+	var svc *kapi.Service
+	for _, o := range objs {
+		switch o := o.(type) {
+		case *kext.Deployment:
+			d = o
+		case *kapi.Service:
+			svc = o
+		default:
+			return nil, nil, fmt.Errorf("unexpected %T in Horizon manifest", o)
+		}
+	}
+	if d == nil || svc == nil {
+		return nil, nil, fmt.Errorf("Horizon manifest needs a deployment and a service")
+	}
+	if len(d.Spec.Template.Spec.Containers) == 0 {
+		return nil, nil, fmt.Errorf("unable to parse Horizon deployment")
+	}
+	err = setSpecHash(&d.Spec.Template, envHash(env))
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, svc, nil
+}
+
+func (k *Kube) getDeployment(name string) (*kext.Deployment, error) {
+	d, err := k.C.ExtensionsClient.Deployments(k.userNamespace).Get(name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// deploymentReady reports whether d has finished rolling out: all of its
+// pods run its current template and are available, and none of the old
+// ones are left.
+func (k *Kube) deploymentReady(d *kext.Deployment) (bool, error) {
+	log.Printf("checking readiness of deployment %s", d.Name)
+	cur, err := k.C.ExtensionsClient.Deployments(k.userNamespace).Get(d.Name)
+	if err != nil {
+		return false, err
+	}
+	if cur.Status.ObservedGeneration < cur.Generation {
+		return false, nil
+	}
+	replicas := cur.Spec.Replicas
+	return cur.Status.UpdatedReplicas == replicas &&
+		cur.Status.AvailableReplicas == replicas &&
+		cur.Status.Replicas == replicas, nil
+}
+
+func (k *Kube) waitDeployment(d *kext.Deployment) error {
+	return waitUntil(func() (bool, error) {
+		return k.deploymentReady(d)
+	})
+}
+
+// DeleteDeployment deletes d along with its replica sets and their pods,
+// which deleting a Deployment on its own leaves running.
+func (k *Kube) DeleteDeployment(d *kext.Deployment) error {
+	if d == nil {
+		return fmt.Errorf("cannot delete non-existent deployment")
+	}
+	var errs []error
+	opts := kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(d.Spec.Template.Labels),
+	}
+	errs = append(errs, k.DeleteObject(d))
+	rss, err := k.C.ExtensionsClient.ReplicaSets(k.userNamespace).List(opts)
+	errs = append(errs, err)
+	if err == nil {
+		for _, rs := range rss.Items {
+			errs = append(errs, k.DeleteObject(&rs))
+		}
+	}
+	podlist, err := k.C.Pods(k.userNamespace).List(opts)
+	errs = append(errs, err)
+	if err == nil {
+		for _, pod := range podlist.Items {
+			errs = append(errs, k.DeleteObject(&pod))
+		}
+	}
+	return compositeErr(errs...)
+}
+
+// createHorizon creates the service and Deployment of a new project's
+// Horizon servers as steps of op.
+func (k *Kube) createHorizon(op *operation, project string,
+	conf types.KubeConfig, images Images, env map[string]string) (*Horizon, error) {
+	d, svc, err := k.renderHorizon(project, conf, images, env)
+	if err != nil {
+		return nil, err
+	}
+	err = op.step(stepService, svc.Name, func() (string, error) {
+		svc, err = k.C.Services(k.userNamespace).Create(svc)
+		if err != nil {
+			return "", err
+		}
+		return svc.Name, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("created %s.", svc.Name)
+	err = op.step(stepDeployment, d.Name, func() (string, error) {
+		d, err = k.C.ExtensionsClient.Deployments(k.userNamespace).Create(d)
+		if err != nil {
+			return "", err
+		}
+		return d.Name, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("created horizon\n")
+	return &Horizon{d, svc}, nil
+}
+
+func (k *Kube) DeleteHorizon(horizon *Horizon) error {
+	var errs []error
+	errs = append(errs, k.DeleteDeployment(horizon.Deployment))
+	errs = append(errs, k.DeleteObject(horizon.SVC))
+	err := compositeErr(errs...)
+	if err != nil {
+		return err
+	}
+	log.Printf("deleted horizon")
+	return nil
+}
+
+// updateHorizon brings an existing Horizon Deployment up to date with the
+// manifest, env and replica count, keeping the image it runs and undoing
+// changes made to it by hand.  The Deployment replaces its pods one at a
+// time, and updateHorizon waits for it to finish.
+func (k *Kube) updateHorizon(d *kext.Deployment, trueName string,
+	conf types.KubeConfig, env map[string]string) (*kext.Deployment, error) {
+	image := d.Spec.Template.Spec.Containers[0].Image
+	want, _, err := k.renderHorizon(trueName, conf, Images{Horizon: image}, env)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	if drift := podTemplateDrift(&d.Spec.Template, &want.Spec.Template); len(drift) != 0 {
+		log.Printf("updating pod template of %s: %v", d.Name, drift)
+		d.Spec.Template = want.Spec.Template
+		changed = true
+	}
+	if d.Spec.Replicas != want.Spec.Replicas {
+		log.Printf("scaling %s from %d to %d replicas",
+			d.Name, d.Spec.Replicas, want.Spec.Replicas)
+		d.Spec.Replicas = want.Spec.Replicas
+		changed = true
+	}
+	if !changed {
+		return d, nil
+	}
+
+	d, err = k.C.ExtensionsClient.Deployments(k.userNamespace).Update(d)
+	if err != nil {
+		return nil, err
+	}
+	err = k.waitDeployment(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(env) == 0 {
+		// Nothing refers to the Secret any more.
+		err = k.deleteEnvSecret(trueName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// ensureHorizon returns a project's Horizon objects, creating or updating
+// them to match conf and env.  New Deployments run images.  The objects of
+// a new project are created as steps of op.
+//
+// A project from before Horizon ran as a Deployment gets one running the
+// image of its old RC, and the RC is deleted once the Deployment is ready.
+// The service sends traffic to both in the meantime.  That Deployment
+// isn't a step of op, since the RC may be gone by the time op fails.
+func (k *Kube) ensureHorizon(op *operation, trueName string,
+	conf types.KubeConfig, env map[string]string, images Images) (*Horizon, error) {
+	err := k.ensureEnvSecret(trueName, env)
+	if err != nil {
+		return nil, err
+	}
+	d, err := k.getDeployment(horizonName(trueName))
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := k.getRC(legacyHorizonRCName(trueName))
+	if err != nil {
+		return nil, err
+	}
+	if d == nil && legacy == nil {
+		return k.createHorizon(op, trueName, conf, images, env)
+	}
+
+	svc, err := k.getService(horizonName(trueName))
+	if err != nil {
+		return nil, err
+	}
+	if svc == nil {
+		return nil, fmt.Errorf("%s has no service", trueName)
+	}
+	if d == nil {
+		images = Images{Horizon: legacy.Spec.Template.Spec.Containers[0].Image}
+		d, _, err = k.renderHorizon(trueName, conf, images, env)
+		if err != nil {
+			return nil, err
+		}
+		d, err = k.C.ExtensionsClient.Deployments(k.userNamespace).Create(d)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("created %s.", d.Name)
+	} else {
+		log.Printf("%s already exists", d.Name)
+		d, err = k.updateHorizon(d, trueName, conf, env)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if legacy != nil {
+		err = k.waitDeployment(d)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("replacing %s with %s", legacy.Name, d.Name)
+		err = k.DeleteRC(legacy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Horizon{d, svc}, nil
+}
+
+// EnsureHorizonEnv makes a project's running Horizon servers use env,
+// replacing them one at a time if it has changed.  It does nothing if the
+// project has no Horizon servers yet; EnsureProject gives them env when it
+// creates them.
+func (k *Kube) EnsureHorizonEnv(
+	trueName string, conf types.KubeConfig, env map[string]string) error {
+	d, err := k.getDeployment(horizonName(trueName))
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		err = k.ensureEnvSecret(trueName, env)
+		if err != nil {
+			return err
+		}
+		_, err = k.updateHorizon(d, trueName, conf, env)
+		return err
+	}
+	legacy, err := k.getRC(legacyHorizonRCName(trueName))
+	if err != nil || legacy == nil {
+		return err
+	}
+	_, err = k.ensureHorizon(nil, trueName, conf, env, Images{})
+	return err
+}
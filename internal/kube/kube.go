@@ -2,19 +2,23 @@ package kube
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/rethinkdb/horizon-cloud/internal/gcloud"
+	"github.com/rethinkdb/horizon-cloud/internal/manifest"
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
 	"github.com/rethinkdb/horizon-cloud/internal/types"
 
 	kapi "k8s.io/kubernetes/pkg/api"
 	kerrors "k8s.io/kubernetes/pkg/api/errors"
-	kbatch "k8s.io/kubernetes/pkg/apis/extensions"
+	kext "k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/client/restclient"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	kcmd "k8s.io/kubernetes/pkg/kubectl/cmd"
@@ -22,28 +26,44 @@ import (
 	"k8s.io/kubernetes/pkg/kubectl/resource"
 	"k8s.io/kubernetes/pkg/labels"
 	"k8s.io/kubernetes/pkg/runtime"
-	"k8s.io/kubernetes/pkg/util/yaml"
 )
 
 type Kube struct {
-	TemplatePath  string
+	Manifests     *manifest.Renderer
 	C             *client.Client
 	Conf          *restclient.Config
 	M             *resource.Mapper
-	G             *gcloud.GCloud
+	P             provider.Provider
 	userNamespace string
+
+	// SaveOperation, if set, saves the operations that create project
+	// resources as they go, so that ones cut short can be rolled back.
+	SaveOperation func(op *types.Operation) error
 }
 
-type RDB struct {
+// An RDBReplica is one RethinkDB server, run by an RC of one pod on a disk
+// of its own.
+//
+// Running the replicas as a StatefulSet with volume claim templates was
+// dropped from the move of Horizon to a Deployment, and hasn't been done.
+// It needs a Kubernetes client with apps/v1beta1 (1.5 or later; the one
+// vendored here is 1.3), and a way to bind the claims to the disks created
+// through the provider, so that they can still be resized, snapshotted and
+// reaped like any other project disk.
+type RDBReplica struct {
 	VolumeID string
 	RC       *kapi.ReplicationController
+}
+
+type RDB struct {
+	Replicas []*RDBReplica
 	SVC      *kapi.Service
-	Job      *kbatch.Job
+	Job      *kext.Job
 }
 
 type Horizon struct {
-	RC  *kapi.ReplicationController
-	SVC *kapi.Service
+	Deployment *kext.Deployment
+	SVC        *kapi.Service
 }
 
 type Project struct {
@@ -51,9 +71,21 @@ type Project struct {
 	Horizon *Horizon
 }
 
+// A ConfigError is returned when a KubeConfig can't be applied to the
+// project as it currently exists.  Its message is meant for users.
+type ConfigError struct {
+	Msg string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Msg
+}
+
 var newMu sync.Mutex
 
-func New(templatePath string, userNamespace string, gc *gcloud.GCloud) *Kube {
+// New returns a Kube for userNamespace.  Manifest templates in
+// overrideDir take the place of the built-in ones.
+func New(overrideDir string, userNamespace string, p provider.Provider) *Kube {
 	newMu.Lock() // kutil.NewFactory is racy.
 	factory := kutil.NewFactory(nil)
 	newMu.Unlock()
@@ -67,38 +99,48 @@ func New(templatePath string, userNamespace string, gc *gcloud.GCloud) *Kube {
 		log.Fatalf("unable to get client config: %s", err)
 	}
 	return &Kube{
-		TemplatePath: templatePath,
-		C:            client,
-		Conf:         conf,
+		Manifests: manifest.NewRenderer(overrideDir),
+		C:         client,
+		Conf:      conf,
 		M: &resource.Mapper{
 			ObjectTyper:  typer,
 			RESTMapper:   mapper,
 			ClientMapper: resource.ClientMapperFunc(factory.ClientForMapping),
 			Decoder:      factory.Decoder(true),
 		},
-		G:             gc,
+		P:             p,
 		userNamespace: userNamespace,
 	}
 }
 
-func (k *Kube) GetHorizonPodsForProject(projectID types.ProjectID) ([]string, error) {
+// runningPods returns the names of the pods matching selector that can be
+// exec'd into.  Pods that are still starting or are shutting down (for
+// example while an RC is being scaled) are skipped.
+func (k *Kube) runningPods(selector map[string]string) ([]string, error) {
 	pods, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
-		LabelSelector: labels.Set(map[string]string{
-			"app":     "horizon",
-			"project": projectID.KubeName(),
-		}).AsSelector(),
+		LabelSelector: labels.SelectorFromSet(selector),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	ret := make([]string, len(pods.Items))
-	for index, pod := range pods.Items {
-		ret[index] = pod.Name
+	ret := make([]string, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != kapi.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		ret = append(ret, pod.Name)
 	}
 	return ret, nil
 }
 
+func (k *Kube) GetHorizonPodsForProject(trueName string) ([]string, error) {
+	return k.runningPods(map[string]string{
+		"app":     "horizon",
+		"project": trueName,
+	})
+}
+
 // Usually all you want to set are `PodName`, `Command`, and maybe `In`.
 type ExecOptions kcmd.ExecOptions
 
@@ -165,51 +207,69 @@ func (k *Kube) Exec(eo ExecOptions) (string, string, error) {
 	return resBuf.String(), errBuf.String(), err
 }
 
-func (k *Kube) Ready(p *Project) (bool, error) {
-	for _, rc := range []*kapi.ReplicationController{p.RDB.RC, p.Horizon.RC} {
-		log.Printf("checking readiness of RC %s", rc.Name)
-		podlist, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
-			LabelSelector: labels.SelectorFromSet(rc.Spec.Selector)})
-		if err != nil {
-			return false, err
+var errNoPods = errors.New("no pods")
+
+// rcReady reports whether every live pod of rc is running and ready.  Pods
+// that are shutting down are ignored.  It returns errNoPods if there are no
+// live pods at all.
+func (k *Kube) rcReady(rc *kapi.ReplicationController) (bool, error) {
+	log.Printf("checking readiness of RC %s", rc.Name)
+	podlist, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(rc.Spec.Selector)})
+	if err != nil {
+		return false, err
+	}
+	livePods := 0
+	for _, pod := range podlist.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
 		}
-		if len(podlist.Items) == 0 {
-			return false, fmt.Errorf("no pods")
+		livePods++
+		log.Printf("checking status for PO %s", pod.Name)
+		switch pod.Status.Phase {
+		case kapi.PodPending:
+			return false, nil
+		case kapi.PodRunning:
+		case kapi.PodSucceeded:
+			return false, fmt.Errorf("pod exited unexpectedly")
+		case kapi.PodFailed:
+			return false, fmt.Errorf("pod failed unexpectedly")
+		case kapi.PodUnknown:
+			return false, fmt.Errorf("pod state unknown")
+		default:
+			return false, fmt.Errorf("unrecognized pod phase '%s'", pod.Status.Phase)
 		}
-		for _, pod := range podlist.Items {
-			log.Printf("checking status for PO %s", pod.Name)
-			switch pod.Status.Phase {
-			case kapi.PodPending:
-				return false, nil
-			case kapi.PodRunning:
-			case kapi.PodSucceeded:
-				return false, fmt.Errorf("pod exited unexpectedly")
-			case kapi.PodFailed:
-				return false, fmt.Errorf("pod failed unexpectedly")
-			case kapi.PodUnknown:
-				return false, fmt.Errorf("pod state unknown")
-			default:
-				return false, fmt.Errorf("unrecognized pod phase '%s'", pod.Status.Phase)
-			}
-			for _, condition := range pod.Status.Conditions {
-				if condition.Type == kapi.PodReady {
-					switch condition.Status {
-					case kapi.ConditionTrue:
-					case kapi.ConditionFalse:
-						return false, nil
-					case kapi.ConditionUnknown:
-						return false, nil
-					default:
-						return false, fmt.Errorf("unrecognized status '%s'", condition.Status)
-					}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == kapi.PodReady {
+				switch condition.Status {
+				case kapi.ConditionTrue:
+				case kapi.ConditionFalse:
+					return false, nil
+				case kapi.ConditionUnknown:
+					return false, nil
+				default:
+					return false, fmt.Errorf("unrecognized status '%s'", condition.Status)
 				}
 			}
 		}
 	}
+	if livePods == 0 {
+		return false, errNoPods
+	}
 	return true, nil
 }
 
-func (k *Kube) Wait(p *Project) error {
+func (k *Kube) Ready(p *Project) (bool, error) {
+	for _, replica := range p.RDB.Replicas {
+		ready, err := k.rcReady(replica.RC)
+		if err != nil || !ready {
+			return false, err
+		}
+	}
+	return k.deploymentReady(p.Horizon.Deployment)
+}
+
+func waitUntil(check func() (bool, error)) error {
 	timeoutMin := 5 * time.Minute
 	backoff_ms := 1000 * time.Millisecond
 	backoff_ms_increment := 100 * time.Millisecond
@@ -222,7 +282,7 @@ func (k *Kube) Wait(p *Project) error {
 			return fmt.Errorf("timed out after %v minutes", timeoutMin)
 		case <-time.After(backoff_ms):
 			log.Printf("Polling for readiness")
-			ready, err := k.Ready(p)
+			ready, err := check()
 			if err != nil {
 				return err
 			}
@@ -234,6 +294,84 @@ func (k *Kube) Wait(p *Project) error {
 	}
 }
 
+func (k *Kube) Wait(p *Project) error {
+	return waitUntil(func() (bool, error) {
+		return k.Ready(p)
+	})
+}
+
+// RecycleRC deletes every pod of rc and waits for the RC to bring up
+// ready replacements.
+func (k *Kube) RecycleRC(rc *kapi.ReplicationController) error {
+	podlist, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(rc.Spec.Selector),
+	})
+	if err != nil {
+		return err
+	}
+	for _, pod := range podlist.Items {
+		err := k.DeleteObject(&pod)
+		if err != nil {
+			return err
+		}
+	}
+	return k.waitRC(rc)
+}
+
+// waitRC waits for rc to have ready pods, which it may not have yet if it
+// was just created or recycled.
+func (k *Kube) waitRC(rc *kapi.ReplicationController) error {
+	return waitUntil(func() (bool, error) {
+		ready, err := k.rcReady(rc)
+		if err == errNoPods {
+			return false, nil
+		}
+		return ready, err
+	})
+}
+
+// rollRC replaces the pods of rc one at a time, waiting for each
+// replacement to be ready before moving on, so that the RC keeps serving
+// throughout.
+func (k *Kube) rollRC(rc *kapi.ReplicationController) error {
+	podlist, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(rc.Spec.Selector),
+	})
+	if err != nil {
+		return err
+	}
+	for _, pod := range podlist.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		log.Printf("rolling %s of %s", pod.Name, rc.Name)
+		err := k.DeleteObject(&pod)
+		if err != nil {
+			return err
+		}
+		err = waitUntil(func() (bool, error) {
+			ready, err := k.rcReady(rc)
+			if err == errNoPods {
+				return false, nil
+			}
+			if err != nil || !ready {
+				return false, err
+			}
+			// rcReady ignores the deleted pod, so it's only done once the
+			// replacement is running too.
+			running, err := k.runningPods(rc.Spec.Selector)
+			if err != nil {
+				return false, err
+			}
+			return len(running) >= int(rc.Spec.Replicas), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (k *Kube) DeleteObject(o runtime.Object) error {
 	if o == nil {
 		return fmt.Errorf("cannot delete non-existent object")
@@ -250,114 +388,162 @@ func (k *Kube) DeleteObject(o runtime.Object) error {
 	return nil
 }
 
-func (k *Kube) CreateFromTemplate(
-	template string, args ...string) ([]runtime.Object, error) {
+func rdbRCName(project string, index int) string {
+	return manifest.RDBReplicaName(project, index)
+}
 
-	path := k.TemplatePath + template
-	cmd := exec.Command(path, args...)
-	cmdReader, err := cmd.StdoutPipe()
+// createRDB creates the service and schema job shared by all of a project's
+// RethinkDB replicas.  The returned RDB has no replicas.
+func (k *Kube) createRDB(op *operation,
+	project string, conf types.KubeConfig, images Images) (*RDB, error) {
+	objs, err := k.renderManifest(manifest.RethinkDB,
+		ManifestParams(project, conf, nil, images))
 	if err != nil {
 		return nil, err
 	}
-	err = cmd.Start()
+	rdb := &RDB{}
+	for _, o := range objs {
+		switch o := o.(type) {
+		case *kapi.Service:
+			rdb.SVC = o
+		case *kext.Job:
+			rdb.Job = o
+		default:
+			return nil, fmt.Errorf("unexpected %T in RethinkDB manifest", o)
+		}
+	}
+	if rdb.SVC == nil || rdb.Job == nil {
+		return nil, fmt.Errorf("RethinkDB manifest needs a service and a job")
+	}
+
+	objs, err = k.createObjects(op, []runtime.Object{rdb.SVC, rdb.Job})
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err := cmd.Process.Kill(); err != nil {
-			log.Printf("error killing process %v: %v", cmd.Process, err)
-		}
-		if err := cmd.Wait(); err != nil {
-			log.Printf("error waiting on cmd %v: %v", cmd, err)
-		}
-	}()
+	log.Printf("created rdb\n")
+	rdb.SVC = objs[0].(*kapi.Service)
+	rdb.Job = objs[1].(*kext.Job)
+	return rdb, nil
+}
 
-	var objs []runtime.Object
-	defer func() {
-		for _, o := range objs {
-			o := o
-			go func() {
-				if err := k.DeleteObject(o); err != nil {
-					log.Printf("error deleting object %v: %v", o, err)
-				}
-			}()
-		}
-	}()
-	d := yaml.NewYAMLOrJSONDecoder(cmdReader, 4096)
-	for {
-		var ext runtime.RawExtension
-		err = d.Decode(&ext)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		ext.RawJSON = bytes.TrimSpace(ext.RawJSON)
-		info, err := k.M.InfoForData(ext.RawJSON, path)
-		if err != nil {
-			return nil, err
-		}
-		info.Namespace = k.userNamespace
-		obj, err := resource.NewHelper(info.Client, info.Mapping).
-			Create(info.Namespace, true, info.Object)
-		if err != nil {
-			return nil, err
-		}
-		log.Printf("created %s.", info.Name)
-		objs = append(objs, obj)
+// volumeJSON returns the JSON of the `data` volume that mounts the named
+// disk, for manifest.Params.Volume.
+func (k *Kube) volumeJSON(disk string) (string, error) {
+	// Internal and v1 volumes serialize the same way.
+	volSpec, err := json.Marshal(&kapi.Volume{
+		Name:         "data",
+		VolumeSource: k.P.VolumeSource(disk),
+	})
+	if err != nil {
+		return "", err
 	}
-	ret := objs
-	objs = nil
-
-	return ret, nil
+	return string(volSpec), nil
 }
 
-func (k *Kube) CreateRDB(project string, volume string) (*RDB, error) {
-	objs, err := k.CreateFromTemplate("rethinkdb.sh", project, volume)
+// renderRDBReplica returns the RC of the replica running on volume.
+func (k *Kube) renderRDBReplica(project string, conf types.KubeConfig,
+	index int, volume string, images Images) (*kapi.ReplicationController, error) {
+	volSpec, err := k.volumeJSON(volume)
+	if err != nil {
+		return nil, err
+	}
+	params := ManifestParams(project, conf, nil, images)
+	params.Replica = index
+	params.Volume = volSpec
+	objs, err := k.renderManifest(manifest.RethinkDBReplica, params)
 	if err != nil {
 		return nil, err
 	}
-	if len(objs) != 3 {
-		log.Printf("oh shit my RDB template is wrong (%v)", objs)
-		return nil, fmt.Errorf("Internal error: template returned %d objects.", len(objs))
+	if len(objs) != 1 {
+		return nil, fmt.Errorf(
+			"RethinkDB replica manifest has %d objects instead of an RC", len(objs))
 	}
-	log.Printf("created rdb\n")
-
 	rc, ok := objs[0].(*kapi.ReplicationController)
-	if !ok {
+	if !ok || rc.Spec.Template == nil || len(rc.Spec.Template.Spec.Containers) == 0 {
 		return nil, fmt.Errorf("unable to parse RDB replication controller")
 	}
-	svc, ok := objs[1].(*kapi.Service)
-	if !ok {
-		return nil, fmt.Errorf("unable to parse RDB service")
+	err = setSpecHash(rc.Spec.Template, "")
+	if err != nil {
+		return nil, err
 	}
-	job, ok := objs[2].(*kbatch.Job)
-	if !ok {
-		return nil, fmt.Errorf("unable to parse RDB job")
+	return rc, nil
+}
+
+func (k *Kube) CreateRDBReplica(project string, conf types.KubeConfig,
+	index int, volume string, images Images) (*RDBReplica, error) {
+	return k.createRDBReplica(nil, project, conf, index, volume, images)
+}
+
+func (k *Kube) createRDBReplica(op *operation, project string,
+	conf types.KubeConfig, index int, volume string, images Images) (*RDBReplica, error) {
+	rc, err := k.renderRDBReplica(project, conf, index, volume, images)
+	if err != nil {
+		return nil, err
 	}
-	return &RDB{volume, rc, svc, job}, nil
+	err = op.step(stepRC, rc.Name, func() (string, error) {
+		rc, err = k.C.ReplicationControllers(k.userNamespace).Create(rc)
+		if err != nil {
+			return "", err
+		}
+		return rc.Name, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("created rdb replica %d\n", index)
+	return &RDBReplica{volume, rc}, nil
 }
 
-func (k *Kube) CreateHorizon(project string) (*Horizon, error) {
-	objs, err := k.CreateFromTemplate("horizon.sh", project)
+// updateRDBReplica brings the RC of an existing RethinkDB replica up to
+// date with the manifest, keeping the image it runs, and replaces its pod
+// if that changed anything.  Changes made to the RC by hand are undone.
+func (k *Kube) updateRDBReplica(rc *kapi.ReplicationController, trueName string,
+	conf types.KubeConfig, index int, volName string) (*kapi.ReplicationController, error) {
+	image := rc.Spec.Template.Spec.Containers[0].Image
+	want, err := k.renderRDBReplica(trueName, conf, index, volName,
+		Images{RethinkDB: image})
 	if err != nil {
 		return nil, err
 	}
-	if len(objs) != 2 {
-		log.Printf("oh shit my HZ template is wrong (%v)", objs)
-		return nil, fmt.Errorf("Internal error: template returned %d objects.", len(objs))
+	updated, drift := updatedRDBReplica(rc, want)
+	if updated == nil {
+		return rc, nil
 	}
-	log.Printf("created horizon\n")
-	rc, ok := objs[0].(*kapi.ReplicationController)
-	if !ok {
-		return nil, fmt.Errorf("unable to create Horizon replication controller")
+	log.Printf("updating %s: %v", rc.Name, drift)
+	rc, err = k.C.ReplicationControllers(k.userNamespace).Update(updated)
+	if err != nil {
+		return nil, err
 	}
-	svc, ok := objs[1].(*kapi.Service)
-	if !ok {
-		return nil, fmt.Errorf("unable to create Horizon service")
+	return rc, k.rollRC(rc)
+}
+
+// updatedRDBReplica returns a copy of rc brought up to date with want, and
+// what was out of date, or nil if nothing was.
+//
+// rc keeps its selector.  RCs created before projects could have more than
+// one replica select {app, project, version: v1}, and changing the
+// selector of a running RC would orphan its pod, leaving the new one unable
+// to mount the disk.  The pod labels must match the selector, so want's are
+// only added where they don't conflict with it.
+func updatedRDBReplica(
+	rc, want *kapi.ReplicationController) (*kapi.ReplicationController, []string) {
+	template := *want.Spec.Template
+	template.Labels = make(map[string]string)
+	for k, v := range want.Spec.Template.Labels {
+		template.Labels[k] = v
+	}
+	for k, v := range rc.Spec.Selector {
+		template.Labels[k] = v
 	}
-	return &Horizon{rc, svc}, nil
+
+	drift := podTemplateDrift(rc.Spec.Template, &template)
+	if len(drift) == 0 && rc.Spec.Replicas == want.Spec.Replicas {
+		return nil, nil
+	}
+	updated := *rc
+	updated.Spec.Template = &template
+	updated.Spec.Replicas = want.Spec.Replicas
+	return &updated, drift
 }
 
 func (k *Kube) DeleteRC(rc *kapi.ReplicationController) error {
@@ -379,8 +565,10 @@ func (k *Kube) DeleteRC(rc *kapi.ReplicationController) error {
 
 func (k *Kube) DeleteRDB(rdb *RDB) error {
 	var errs []error
-	errs = append(errs, k.G.DeleteDisk(rdb.VolumeID))
-	errs = append(errs, k.DeleteRC(rdb.RC))
+	for _, replica := range rdb.Replicas {
+		errs = append(errs, k.P.DeleteDisk(replica.VolumeID))
+		errs = append(errs, k.DeleteRC(replica.RC))
+	}
 	errs = append(errs, k.DeleteObject(rdb.SVC))
 	errs = append(errs, k.DeleteObject(rdb.Job))
 	err := compositeErr(errs...)
@@ -391,44 +579,110 @@ func (k *Kube) DeleteRDB(rdb *RDB) error {
 	return nil
 }
 
-func (k *Kube) DeleteHorizon(horizon *Horizon) error {
-	var errs []error
-	errs = append(errs, k.DeleteRC(horizon.RC))
-	errs = append(errs, k.DeleteObject(horizon.SVC))
-	err := compositeErr(errs...)
-	if err != nil {
-		return err
+// Disks are tagged with the namespace and project they were created for,
+// so that the disk reaper knows which disks it is responsible for.  Disks
+// created by an operation are also tagged with its ID, so that they can be
+// found again if it's cut short before it learns the disk's name.
+func (k *Kube) diskDescription(trueName string) string {
+	return "hzc namespace=" + k.userNamespace + " project=" + trueName
+}
+
+func (k *Kube) diskTags(d *provider.Disk) map[string]string {
+	fields := strings.Fields(d.Description)
+	if len(fields) < 3 || len(fields) > 4 || fields[0] != "hzc" {
+		return nil
 	}
-	log.Printf("deleted horizon")
-	return nil
+	tags := make(map[string]string, len(fields)-1)
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil
+		}
+		tags[kv[0]] = kv[1]
+	}
+	if tags["namespace"] != k.userNamespace || tags["project"] == "" {
+		return nil
+	}
+	return tags
+}
+
+// DiskProject returns the project a disk was created for, or "" if the
+// disk wasn't created for a project in this Kube's namespace.
+func (k *Kube) DiskProject(d *provider.Disk) string {
+	return k.diskTags(d)["project"]
+}
+
+// diskOperation returns the ID of the operation that created a disk, or ""
+// if it wasn't created by one.
+func (k *Kube) diskOperation(d *provider.Disk) string {
+	return k.diskTags(d)["operation"]
 }
 
-func (k *Kube) createWithVol(
-	size int,
-	volType gcloud.DiskType,
-	callback func(vol *gcloud.Disk, err error) error) {
+// VolumesInUse returns the names of all disks referenced by an RC or a
+// pod in the user namespace, mapped to the name of one object using each.
+func (k *Kube) VolumesInUse() (map[string]string, error) {
+	ret := make(map[string]string)
 
-	vol, err := k.G.CreateDisk(int64(size), volType)
+	rcs, err := k.C.ReplicationControllers(k.userNamespace).List(kapi.ListOptions{})
 	if err != nil {
-		log.Printf("failed to create disk (%v, %v): %v", size, volType, err)
-		if err = callback(nil, err); err != nil {
-			log.Printf("createWithVol callback(nil) error: %v", err)
+		return nil, err
+	}
+	for _, rc := range rcs.Items {
+		if rc.Spec.Template == nil {
+			continue
+		}
+		for _, vol := range rc.Spec.Template.Spec.Volumes {
+			if disk := k.P.VolumeDisk(&vol.VolumeSource); disk != "" {
+				ret[disk] = "rc/" + rc.Name
+			}
 		}
-		return
 	}
-	err = callback(vol, nil)
+
+	// Pods can outlive their RC for a while when it's deleted.
+	pods, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{})
 	if err != nil {
-		log.Printf("createWithVol callback(%v) error: %v", vol, err)
-		if err := k.G.DeleteDisk(vol.Name); err != nil {
-			log.Printf("cleanup failure for %v: %v", vol, err)
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		for _, vol := range pod.Spec.Volumes {
+			if disk := k.P.VolumeDisk(&vol.VolumeSource); disk != "" {
+				ret[disk] = "pod/" + pod.Name
+			}
 		}
 	}
+
+	return ret, nil
+}
+
+// createDisk creates a disk for one of a project's RethinkDB replicas.
+func (k *Kube) createDisk(op *operation,
+	trueName string, size int, volType provider.DiskType) (string, error) {
+	desc := k.diskDescription(trueName)
+	if op != nil {
+		desc += " operation=" + op.op.ID
+	}
+	var volName string
+	err := op.step(stepDisk, "", func() (string, error) {
+		vol, err := k.P.CreateDisk(int64(size), volType, desc)
+		if err != nil {
+			log.Printf("failed to create disk (%v, %v): %v", size, volType, err)
+			return "", err
+		}
+		volName = vol.Name
+		return vol.Name, nil
+	})
+	return volName, err
+}
+
+func isNotFound(err error) bool {
+	serr, ok := err.(*kerrors.StatusError)
+	return ok && serr.Status().Code == 404
 }
 
 func (k *Kube) getRC(name string) (*kapi.ReplicationController, error) {
 	rc, err := k.C.ReplicationControllers(k.userNamespace).Get(name)
 	if err != nil {
-		if serr, ok := err.(*kerrors.StatusError); ok && serr.Status().Code == 404 {
+		if isNotFound(err) {
 			return nil, nil
 		}
 		return nil, err
@@ -436,14 +690,36 @@ func (k *Kube) getRC(name string) (*kapi.ReplicationController, error) {
 	return rc, nil
 }
 
+func (k *Kube) getService(name string) (*kapi.Service, error) {
+	svc, err := k.C.Services(k.userNamespace).Get(name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return svc, nil
+}
+
+func (k *Kube) rcVolume(rc *kapi.ReplicationController) (string, error) {
+	for _, vol := range rc.Spec.Template.Spec.Volumes {
+		if disk := k.P.VolumeDisk(&vol.VolumeSource); disk != "" {
+			return disk, nil
+		}
+	}
+	return "", fmt.Errorf("no disk volumes in RC %v", rc)
+}
+
 func (k *Kube) DeleteProject(trueName string) error {
 	var errs []error
-	rc, err := k.getRC("r0-" + trueName)
-	errs = append(errs, err)
-	if err == nil {
-		k.DeleteRC(rc)
+	for i := 0; i < types.MaxNumRDB; i++ {
+		rc, err := k.getRC(rdbRCName(trueName, i))
+		errs = append(errs, err)
+		if err == nil && rc != nil {
+			k.DeleteRC(rc)
+		}
 	}
-	// We DO NOT remove the GCE disk.  That happens at a later date, to
+	// We DO NOT remove the disks.  That happens at a later date, to
 	// protect against accident or malice.
 	svc, err := k.C.Services(k.userNamespace).Get("r-" + trueName)
 	errs = append(errs, err)
@@ -456,22 +732,413 @@ func (k *Kube) DeleteProject(trueName string) error {
 		k.DeleteObject(job)
 	}
 
-	rc, err = k.getRC("h0-" + trueName)
+	d, err := k.getDeployment(horizonName(trueName))
 	errs = append(errs, err)
-	if err == nil {
+	if err == nil && d != nil {
+		k.DeleteDeployment(d)
+	}
+	// Projects from before Horizon ran as a Deployment.
+	rc, err := k.getRC(legacyHorizonRCName(trueName))
+	errs = append(errs, err)
+	if err == nil && rc != nil {
 		k.DeleteRC(rc)
 	}
-	svc, err = k.C.Services(k.userNamespace).Get("h-" + trueName)
+	svc, err = k.C.Services(k.userNamespace).Get(horizonName(trueName))
 	errs = append(errs, err)
 	if err == nil {
 		k.DeleteObject(svc)
 	}
+	errs = append(errs, k.deleteEnvSecret(trueName))
+	return compositeErr(errs...)
+}
+
+// ProjectsInCluster returns the kube names of all projects with Horizon or
+// RethinkDB objects in the user namespace.
+func (k *Kube) ProjectsInCluster() ([]string, error) {
+	selector, err := labels.Parse("app in (horizon, rethinkdb), project")
+	if err != nil {
+		return nil, err
+	}
+	opts := kapi.ListOptions{LabelSelector: selector}
+
+	seen := make(map[string]bool)
+	rcs, err := k.C.ReplicationControllers(k.userNamespace).List(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, rc := range rcs.Items {
+		seen[rc.Labels["project"]] = true
+	}
+	deployments, err := k.C.ExtensionsClient.Deployments(k.userNamespace).List(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		seen[d.Labels["project"]] = true
+	}
+	svcs, err := k.C.Services(k.userNamespace).List(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range svcs.Items {
+		seen[svc.Labels["project"]] = true
+	}
+
+	ret := make([]string, 0, len(seen))
+	for name := range seen {
+		ret = append(ret, name)
+	}
+	return ret, nil
+}
+
+// checkConfig returns a *ConfigError if conf can't be applied to the
+// project as it exists right now.
+func (k *Kube) checkConfig(trueName string, conf types.KubeConfig) error {
+	if conf.NumRDB < types.MaxNumRDB {
+		rc, err := k.getRC(rdbRCName(trueName, conf.NumRDB))
+		if err != nil {
+			return err
+		}
+		if rc != nil {
+			return &ConfigError{fmt.Sprintf(
+				"NumRDB can't be reduced (project has more than %d RethinkDB replicas)",
+				conf.NumRDB)}
+		}
+	}
+	for i := 0; i < conf.NumRDB; i++ {
+		rc, err := k.getRC(rdbRCName(trueName, i))
+		if err != nil {
+			return err
+		}
+		if rc == nil {
+			continue
+		}
+		volName, err := k.rcVolume(rc)
+		if err != nil {
+			return err
+		}
+		disk, err := k.P.GetDisk(volName)
+		if err != nil {
+			return err
+		}
+		if disk.SizeGB > int64(conf.SizeRDB) {
+			return &ConfigError{fmt.Sprintf(
+				"SizeRDB can't be reduced (RethinkDB disks are %dGB, requested %dGB)",
+				disk.SizeGB, conf.SizeRDB)}
+		}
+	}
+	return nil
+}
+
+// fsSizeAnnotation is set on the RCs of RethinkDB replicas to the size in
+// GB of the disk the filesystem was last grown to fill.  RCs without it
+// have a filesystem that fills their disk.
+const fsSizeAnnotation = "hzc/filesystem-size-gb"
+
+// ensureDiskSize grows the disk behind an RDB replica to conf.SizeRDB, and
+// then the filesystem on it.  The size the filesystem has been grown to is
+// recorded on rc, so that growing it is retried if it fails.
+func (k *Kube) ensureDiskSize(rc *kapi.ReplicationController, trueName string,
+	conf types.KubeConfig, index int, volName string) (*kapi.ReplicationController, error) {
+
+	disk, err := k.P.GetDisk(volName)
+	if err != nil {
+		return nil, err
+	}
+	fsSize := disk.SizeGB
+	if s, ok := rc.Annotations[fsSizeAnnotation]; ok {
+		fsSize, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s on %s: %v", fsSizeAnnotation, rc.Name, err)
+		}
+	}
+
+	if disk.SizeGB < int64(conf.SizeRDB) {
+		if _, ok := rc.Annotations[fsSizeAnnotation]; !ok {
+			rc, err = k.setFSSize(rc, fsSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("resizing %s from %dGB to %dGB", volName, disk.SizeGB, conf.SizeRDB)
+		err = k.P.ResizeDisk(volName, int64(conf.SizeRDB))
+		if err != nil {
+			return nil, err
+		}
+		disk.SizeGB = int64(conf.SizeRDB)
+	}
+	if fsSize >= disk.SizeGB || !k.hasOwnFilesystem(volName) {
+		return rc, nil
+	}
+
+	rc, err = k.growFilesystem(rc, trueName, conf, index, volName)
+	if err != nil {
+		return nil, err
+	}
+	return k.setFSSize(rc, disk.SizeGB)
+}
+
+// hasOwnFilesystem reports whether the named disk is a block device with a
+// filesystem on it, rather than a directory on the node (with the local
+// provider), which there is nothing to grow for.
+func (k *Kube) hasOwnFilesystem(disk string) bool {
+	return k.P.VolumeSource(disk).HostPath == nil
+}
+
+// updateRC applies f to the latest version of rc and saves it.  rc's own
+// copy may be out of date, if only in its status.
+func (k *Kube) updateRC(rc *kapi.ReplicationController,
+	f func(rc *kapi.ReplicationController)) (*kapi.ReplicationController, error) {
+	latest, err := k.getRC(rc.Name)
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%s has disappeared", rc.Name)
+	}
+	f(latest)
+	return k.C.ReplicationControllers(k.userNamespace).Update(latest)
+}
+
+func (k *Kube) setFSSize(
+	rc *kapi.ReplicationController, sizeGB int64) (*kapi.ReplicationController, error) {
+	return k.updateRC(rc, func(rc *kapi.ReplicationController) {
+		if rc.Annotations == nil {
+			rc.Annotations = make(map[string]string)
+		}
+		rc.Annotations[fsSizeAnnotation] = strconv.FormatInt(sizeGB, 10)
+	})
+}
+
+func (k *Kube) scaleRC(
+	rc *kapi.ReplicationController, replicas int32) (*kapi.ReplicationController, error) {
+	return k.updateRC(rc, func(rc *kapi.ReplicationController) {
+		rc.Spec.Replicas = replicas
+	})
+}
+
+// growFilesystem stops a RethinkDB replica, so that its disk is free to be
+// mounted elsewhere, grows the filesystem on the disk with
+// resizeFilesystem, and starts the replica again.  The replica is started
+// again even if that fails, since it runs fine on the smaller filesystem.
+func (k *Kube) growFilesystem(rc *kapi.ReplicationController, trueName string,
+	conf types.KubeConfig, index int, volName string) (*kapi.ReplicationController, error) {
+	replicas := rc.Spec.Replicas
+	image := rc.Spec.Template.Spec.Containers[0].Image
+
+	log.Printf("stopping %s to grow the filesystem on %s", rc.Name, volName)
+	rc, err := k.scaleRC(rc, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = waitUntil(func() (bool, error) {
+		pods, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
+			LabelSelector: labels.SelectorFromSet(rc.Spec.Selector),
+		})
+		if err != nil {
+			return false, err
+		}
+		return len(pods.Items) == 0, nil
+	})
+	if err == nil {
+		err = k.resizeFilesystem(trueName, conf, index, volName, image)
+	}
+
+	log.Printf("starting %s again", rc.Name)
+	rc, scaleErr := k.scaleRC(rc, replicas)
+	if scaleErr != nil {
+		return nil, compositeErr(err, scaleErr)
+	}
+	return rc, compositeErr(err, k.waitRC(rc))
+}
+
+// resizeFilesystem runs the job that grows the filesystem on the disk of a
+// RethinkDB replica, which mustn't be mounted by anything else, and
+// deletes the job once it has finished.
+func (k *Kube) resizeFilesystem(trueName string,
+	conf types.KubeConfig, index int, volName string, image string) error {
+	if !k.hasOwnFilesystem(volName) {
+		return nil
+	}
+	volSpec, err := k.volumeJSON(volName)
+	if err != nil {
+		return err
+	}
+	params := ManifestParams(trueName, conf, nil, Images{RethinkDB: image})
+	params.Replica = index
+	params.Volume = volSpec
+	objs, err := k.renderManifest(manifest.RethinkDBResize, params)
+	if err != nil {
+		return err
+	}
+	if len(objs) != 1 {
+		return fmt.Errorf(
+			"RethinkDB resize manifest has %d objects instead of a job", len(objs))
+	}
+	job, ok := objs[0].(*kext.Job)
+	if !ok {
+		return fmt.Errorf("unable to parse RethinkDB resize job")
+	}
+
+	jobs := k.C.BatchClient.Jobs(k.userNamespace)
+	// A job left behind by an earlier attempt that was cut short.
+	old, err := jobs.Get(job.Name)
+	if err == nil {
+		err = k.deleteJob(old)
+	} else if isNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("growing the filesystem on %s", volName)
+	job, err = jobs.Create(job)
+	if err != nil {
+		return err
+	}
+	err = waitUntil(func() (bool, error) {
+		j, err := jobs.Get(job.Name)
+		if err != nil {
+			return false, err
+		}
+		if j.Status.Succeeded > 0 {
+			return true, nil
+		}
+		for _, c := range j.Status.Conditions {
+			if c.Type == kext.JobFailed && c.Status == kapi.ConditionTrue {
+				return false, fmt.Errorf("%s failed: %s", job.Name, c.Message)
+			}
+		}
+		if j.Status.Failed > 0 {
+			return false, fmt.Errorf("%s failed", job.Name)
+		}
+		return false, nil
+	})
+	return compositeErr(err, k.deleteJob(job))
+}
+
+// deleteJob deletes job and the pods it ran, which Kubernetes leaves
+// behind.
+func (k *Kube) deleteJob(job *kext.Job) error {
+	errs := []error{k.DeleteObject(job)}
+	pods, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(job.Spec.Template.Labels),
+	})
+	if err != nil {
+		return compositeErr(append(errs, err)...)
+	}
+	for i := range pods.Items {
+		errs = append(errs, k.DeleteObject(&pods.Items[i]))
+	}
 	return compositeErr(errs...)
 }
 
-func (k *Kube) EnsureProject(
-	trueName string, conf types.KubeConfig) (*Project, error) {
-	// TODO: Use `NumRDB` and `NumHorizon`
+// ensureIntraclusterPort adds the intracluster port to RethinkDB services
+// created before projects could have more than one replica.
+func (k *Kube) ensureIntraclusterPort(svc *kapi.Service) (*kapi.Service, error) {
+	for _, port := range svc.Spec.Ports {
+		if port.Port == 29015 {
+			return svc, nil
+		}
+	}
+	svc.Spec.Ports = append(svc.Spec.Ports, kapi.ServicePort{
+		Name: "intracluster",
+		Port: 29015,
+	})
+	log.Printf("adding intracluster port to %s", svc.Name)
+	return k.C.Services(k.userNamespace).Update(svc)
+}
+
+func (k *Kube) ensureRDBReplica(op *operation, trueName string,
+	conf types.KubeConfig, index int, images Images) (*RDBReplica, error) {
+	name := rdbRCName(trueName, index)
+	rc, err := k.getRC(name)
+	if err != nil {
+		return nil, err
+	}
+	if rc != nil {
+		volName, err := k.rcVolume(rc)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("%s already exists with volume %s", name, volName)
+		rc, err = k.updateRDBReplica(rc, trueName, conf, index, volName)
+		if err != nil {
+			return nil, err
+		}
+		rc, err = k.ensureDiskSize(rc, trueName, conf, index, volName)
+		if err != nil {
+			return nil, err
+		}
+		return &RDBReplica{volName, rc}, nil
+	}
+
+	volName, err := k.createDisk(op, trueName, conf.SizeRDB, provider.DiskTypeSSD)
+	if err != nil {
+		return nil, err
+	}
+	return k.createRDBReplica(op, trueName, conf, index, volName, images)
+}
+
+// ensureRDB returns the project's RethinkDB objects, creating whatever is
+// missing as steps of op.
+func (k *Kube) ensureRDB(op *operation, trueName string,
+	conf types.KubeConfig, images Images) (*RDB, error) {
+	svc, err := k.getService("r-" + trueName)
+	if err != nil {
+		return nil, err
+	}
+
+	var rdb *RDB
+	if svc == nil {
+		rdb, err = k.createRDB(op, trueName, conf, images)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		svc, err = k.ensureIntraclusterPort(svc)
+		if err != nil {
+			return nil, err
+		}
+		job, err := k.C.BatchClient.Jobs(k.userNamespace).Get("ss-" + trueName)
+		if err != nil {
+			if !isNotFound(err) {
+				return nil, err
+			}
+			// The job only has to run once, so it may have been cleaned
+			// up since.
+			job = nil
+		}
+		rdb = &RDB{nil, svc, job}
+	}
+
+	for i := 0; i < conf.NumRDB; i++ {
+		replica, err := k.ensureRDBReplica(op, trueName, conf, i, images)
+		if err != nil {
+			return nil, err
+		}
+		rdb.Replicas = append(rdb.Replicas, replica)
+	}
+	return rdb, nil
+}
+
+// EnsureProject creates or updates a project's objects to match conf.  The
+// project's Horizon servers are given env.  Existing objects are updated in
+// place when the manifests or env have changed, or the objects were edited
+// by hand, and their pods replaced one at a time.  New objects run images;
+// existing ones are left on the images they have until UpgradeProject
+// changes them.
+//
+// The objects and disks EnsureProject creates are recorded as an
+// operation, and deleted again if it fails; existing objects are left
+// running.
+func (k *Kube) EnsureProject(trueName string, conf types.KubeConfig,
+	env map[string]string, images Images) (*Project, error) {
+	if err := k.checkConfig(trueName, conf); err != nil {
+		return nil, err
+	}
+	op := k.startOperation(trueName, "EnsureProject")
 
 	type MaybeRDB struct {
 		RDB *RDB
@@ -487,68 +1154,13 @@ func (k *Kube) EnsureProject(
 	horizonCh := make(chan MaybeHorizon)
 
 	go func() {
-		rdbCh <- func() MaybeRDB {
-			rc, err := k.getRC("r0-" + trueName)
-			if err != nil {
-				return MaybeRDB{nil, err}
-			}
-
-			if rc != nil {
-				svc, err := k.C.Services(k.userNamespace).Get("r-" + trueName)
-				if err != nil {
-					return MaybeRDB{nil, err}
-				}
-				job, err := k.C.BatchClient.Jobs(k.userNamespace).Get("ss-" + trueName)
-				if err != nil {
-					return MaybeRDB{nil, err}
-				}
-				var volName string
-				for _, vol := range rc.Spec.Template.Spec.Volumes {
-					if vol.GCEPersistentDisk != nil {
-						volName = vol.GCEPersistentDisk.PDName
-						break
-					}
-				}
-				if volName == "" {
-					return MaybeRDB{nil, fmt.Errorf("no GCE volumes in RC %v", rc)}
-				}
-				log.Printf("%s already exists with volume %s", "r0-"+trueName, volName)
-				return MaybeRDB{&RDB{volName, rc, svc, job}, nil}
-			}
-
-			var ret MaybeRDB
-			k.createWithVol(conf.SizeRDB, gcloud.DiskTypeSSD,
-				func(vol *gcloud.Disk, err error) error {
-					if err != nil {
-						ret = MaybeRDB{nil, err}
-						return nil
-					}
-					rdb, err := k.CreateRDB(trueName, vol.Name)
-					ret = MaybeRDB{rdb, err}
-					return err
-				})
-			return ret
-		}()
+		rdb, err := k.ensureRDB(op, trueName, conf, images)
+		rdbCh <- MaybeRDB{rdb, err}
 	}()
 
 	go func() {
-		horizonCh <- func() MaybeHorizon {
-			rc, err := k.getRC("h0-" + trueName)
-			if err != nil {
-				return MaybeHorizon{nil, err}
-			}
-			if rc != nil {
-				svc, err := k.C.Services(k.userNamespace).Get("h-" + trueName)
-				if err != nil {
-					return MaybeHorizon{nil, err}
-				}
-				log.Printf("%s already exists", "h0-"+trueName)
-				return MaybeHorizon{&Horizon{rc, svc}, nil}
-			}
-
-			horizon, err := k.CreateHorizon(trueName)
-			return MaybeHorizon{horizon, err}
-		}()
+		horizon, err := k.ensureHorizon(op, trueName, conf, env, images)
+		horizonCh <- MaybeHorizon{horizon, err}
 	}()
 
 	rdb := <-rdbCh
@@ -556,20 +1168,12 @@ func (k *Kube) EnsureProject(
 
 	err := compositeErr(rdb.Err, horizon.Err)
 	if err != nil {
-		if rdb.RDB != nil {
-			err := k.DeleteRDB(rdb.RDB)
-			if err != nil {
-				log.Printf("RDB cleanup failure for %v: %v", rdb.RDB, err)
-			}
-		}
-		if horizon.Horizon != nil {
-			err := k.DeleteHorizon(horizon.Horizon)
-			if err != nil {
-				log.Printf("HZ cleanup failure for %v: %v", horizon.Horizon, err)
-			}
+		if err := op.rollBack(err); err != nil {
+			log.Printf("cleanup failure for %v: %v", trueName, err)
 		}
 		return nil, err
 	}
+	op.finish()
 
 	return &Project{
 		RDB:     rdb.RDB,
@@ -0,0 +1,84 @@
+package kube
+
+import (
+	"reflect"
+	"testing"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+func testReplicaRC(podLabels map[string]string) *kapi.ReplicationController {
+	template := testTemplate()
+	template.Labels = podLabels
+	return &kapi.ReplicationController{
+		ObjectMeta: kapi.ObjectMeta{Name: "r0-p", Labels: podLabels},
+		Spec: kapi.ReplicationControllerSpec{
+			Replicas: 1,
+			Selector: podLabels,
+			Template: template,
+		},
+	}
+}
+
+func TestUpdatedRDBReplica(t *testing.T) {
+	// The labels of templates/rethinkdb.sh, from before projects could
+	// have more than one replica.
+	baseline := map[string]string{
+		"app": "rethinkdb", "project": "p", "version": "v1"}
+	current := map[string]string{
+		"app": "rethinkdb", "project": "p", "replica": "0", "version": "v2"}
+
+	tests := []struct {
+		Name     string
+		Live     *kapi.ReplicationController
+		Update   bool
+		Selector map[string]string
+	}{
+		{"baseline", func() *kapi.ReplicationController {
+			rc := testReplicaRC(baseline)
+			delete(rc.Spec.Template.Annotations, specHashAnnotation)
+			return rc
+		}(), true, baseline},
+		{"up to date", testReplicaRC(current), false, current},
+		{"limits", func() *kapi.ReplicationController {
+			rc := testReplicaRC(current)
+			rc.Spec.Template.Spec.Containers[0].Resources.Limits[kapi.ResourceCPU] =
+				resource.MustParse("1")
+			return rc
+		}(), true, current},
+	}
+	for _, test := range tests {
+		want := testReplicaRC(current)
+		updated, drift := updatedRDBReplica(test.Live, want)
+		if !test.Update {
+			if updated != nil {
+				t.Errorf("%s: updated with drift %q", test.Name, drift)
+			}
+			continue
+		}
+		if updated == nil {
+			t.Errorf("%s: not updated", test.Name)
+			continue
+		}
+		if !reflect.DeepEqual(updated.Spec.Selector, test.Selector) {
+			t.Errorf("%s: selector changed to %v", test.Name, updated.Spec.Selector)
+		}
+		selector := labels.SelectorFromSet(updated.Spec.Selector)
+		if !selector.Matches(labels.Set(updated.Spec.Template.Labels)) {
+			t.Errorf("%s: selector %v doesn't match pod labels %v",
+				test.Name, selector, updated.Spec.Template.Labels)
+		}
+		if updated.Spec.Template.Labels["replica"] != "0" {
+			t.Errorf("%s: pod labels %v are missing the manifest's",
+				test.Name, updated.Spec.Template.Labels)
+		}
+		if len(podTemplateDrift(updated.Spec.Template, want.Spec.Template)) != 0 {
+			t.Errorf("%s: updated template still differs from the manifest", test.Name)
+		}
+		if !reflect.DeepEqual(want.Spec.Template.Labels, current) {
+			t.Errorf("%s: want was modified", test.Name)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package kube
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/rethinkdb/horizon-cloud/internal/manifest"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kext "k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/kubectl/resource"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/yaml"
+)
+
+// ManifestParams returns the params to render a project's manifests with.
+// The Horizon servers get the variables named in envNames from the
+// project's env Secret.  Empty fields of images mean $HORIZON_GCR_ID and
+// $RETHINKDB_GCR_ID.
+func ManifestParams(trueName string, conf types.KubeConfig,
+	envNames []string, images Images) *manifest.Params {
+	if images.Horizon == "" {
+		images.Horizon = os.Getenv("HORIZON_GCR_ID")
+	}
+	if images.RethinkDB == "" {
+		images.RethinkDB = os.Getenv("RETHINKDB_GCR_ID")
+	}
+	return &manifest.Params{
+		Project:        trueName,
+		KubeConfig:     conf,
+		HorizonImage:   images.Horizon,
+		RethinkDBImage: images.RethinkDB,
+		EnvNames:       envNames,
+	}
+}
+
+// envNames returns the names in env, sorted.
+func envNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderManifest decodes the objects the named manifest describes without
+// creating them.
+func (k *Kube) renderManifest(
+	name string, params *manifest.Params) ([]runtime.Object, error) {
+	data, err := k.Manifests.Render(name, params)
+	if err != nil {
+		return nil, err
+	}
+	var objs []runtime.Object
+	d := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var ext runtime.RawExtension
+		err = d.Decode(&ext)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		ext.RawJSON = bytes.TrimSpace(ext.RawJSON)
+		if len(ext.RawJSON) == 0 {
+			continue
+		}
+		info, err := k.M.InfoForData(ext.RawJSON, name)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, info.Object)
+	}
+	return objs, nil
+}
+
+// createObjects creates objs in order, each as a step of op, and returns
+// them as created.  If one can't be created, op rolls back the ones before
+// it.
+func (k *Kube) createObjects(
+	op *operation, objs []runtime.Object) ([]runtime.Object, error) {
+	var created []runtime.Object
+	for _, o := range objs {
+		info, err := k.M.InfoForObject(o, nil)
+		if err != nil {
+			return nil, err
+		}
+		var obj runtime.Object
+		var kind string
+		switch o.(type) {
+		case *kapi.Service:
+			kind = stepService
+		case *kext.Job:
+			kind = stepJob
+		default:
+			return nil, fmt.Errorf("can't create %T", o)
+		}
+		err = op.step(kind, info.Name, func() (string, error) {
+			obj, err = resource.NewHelper(info.Client, info.Mapping).
+				Create(k.userNamespace, true, info.Object)
+			return info.Name, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("created %s.", info.Name)
+		created = append(created, obj)
+	}
+	return created, nil
+}
+
+// specHashAnnotation is set on the pod templates of RCs and Deployments to
+// a hash of the spec they were rendered with, so that EnsureProject can
+// tell when a change to the manifests has to reach running pods.
+const specHashAnnotation = "hzc/spec-hash"
+
+// setSpecHash sets the spec hash annotation of t.  Images are left out of
+// the hash, since UpgradeProject changes them, and extra is mixed in.
+func setSpecHash(t *kapi.PodTemplateSpec, extra string) error {
+	spec := t.Spec
+	spec.Containers = make([]kapi.Container, len(t.Spec.Containers))
+	copy(spec.Containers, t.Spec.Containers)
+	for i := range spec.Containers {
+		spec.Containers[i].Image = ""
+	}
+	buf, err := json.Marshal(&spec)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	h.Write(buf)
+	io.WriteString(h, extra)
+	if t.Annotations == nil {
+		t.Annotations = make(map[string]string)
+	}
+	t.Annotations[specHashAnnotation] = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
@@ -0,0 +1,217 @@
+package kube
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// Kinds of resources created by operation steps.
+const (
+	stepDisk       = "disk"
+	stepService    = "service"
+	stepJob        = "job"
+	stepRC         = "rc"
+	stepDeployment = "deployment"
+)
+
+// An operation records the resources a change to a project creates, so
+// that they can be deleted again if the change fails part way.  It's saved
+// through Kube.SaveOperation, if set, before each step starts and after it
+// finishes.  Operations without any steps aren't saved.
+//
+// A nil *operation records nothing; creating resources through it is the
+// same as creating them directly.
+type operation struct {
+	k  *Kube
+	mu sync.Mutex
+	op types.Operation
+}
+
+func (k *Kube) startOperation(trueName string, name string) *operation {
+	return &operation{k: k, op: types.Operation{
+		ID:      uuid.New(),
+		Kube:    trueName,
+		Name:    name,
+		State:   types.OperationRunning,
+		Started: time.Now(),
+	}}
+}
+
+// save saves o.  o.mu must be held.
+func (o *operation) save() error {
+	if o.k.SaveOperation == nil || len(o.op.Steps) == 0 {
+		return nil
+	}
+	return o.k.SaveOperation(&o.op)
+}
+
+// step records the creation of a resource of kind named name, and runs
+// create to create it.  create returns the resource's name, which for
+// disks isn't known beforehand.  Nothing is created if the step can't be
+// saved.
+func (o *operation) step(
+	kind string, name string, create func() (string, error)) error {
+	if o == nil {
+		_, err := create()
+		return err
+	}
+
+	o.mu.Lock()
+	i := len(o.op.Steps)
+	o.op.Steps = append(o.op.Steps, types.OperationStep{
+		Kind:    kind,
+		Name:    name,
+		State:   types.OperationRunning,
+		Started: time.Now(),
+	})
+	err := o.save()
+	o.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("couldn't save operation: %v", err)
+	}
+
+	name, err = create()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := &o.op.Steps[i]
+	s.Finished = time.Now()
+	if err != nil {
+		s.State = types.OperationFailed
+		s.Error = err.Error()
+	} else {
+		s.Name = name
+		s.State = types.OperationDone
+	}
+	if serr := o.save(); serr != nil && err == nil {
+		// The resource is only recorded in memory, so fail the operation
+		// while it can still be rolled back.
+		return fmt.Errorf("couldn't save operation: %v", serr)
+	}
+	return err
+}
+
+// finish records that o has succeeded.
+func (o *operation) finish() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.op.State = types.OperationDone
+	o.op.Finished = time.Now()
+	if err := o.save(); err != nil {
+		log.Printf("couldn't save operation %v: %v", o.op.ID, err)
+	}
+}
+
+// rollBack deletes the resources created by o's steps, newest first, and
+// records that o was rolled back because of cause.  Steps that were cut
+// short are rolled back too, since their resource may exist.  o is
+// finished even if some resources couldn't be deleted, since they may be
+// put to use by the next attempt at the change.
+func (o *operation) rollBack(cause error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var errs []error
+	for i := len(o.op.Steps) - 1; i >= 0; i-- {
+		s := &o.op.Steps[i]
+		if s.State != types.OperationRunning && s.State != types.OperationDone {
+			continue
+		}
+		var err error
+		if s.Kind == stepDisk && s.Name == "" {
+			err = o.k.DeleteUnrecordedDisks(&o.op)
+		} else {
+			err = o.k.deleteStepResource(s)
+		}
+		if err != nil {
+			log.Printf("couldn't roll back %v: %v", s, err)
+			s.Error = err.Error()
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("rolled back %v", s)
+		s.State = types.OperationRolledBack
+	}
+	o.op.State = types.OperationRolledBack
+	o.op.Finished = time.Now()
+	o.op.Error = cause.Error()
+	errs = append(errs, o.save())
+	return compositeErr(errs...)
+}
+
+// DeleteUnrecordedDisks deletes the disks op created whose names it didn't
+// get to record, because it was cut short while creating them.  They're
+// found by the operation ID they're tagged with.  Nothing can be using
+// them, since their names were never handed out.
+func (k *Kube) DeleteUnrecordedDisks(op *types.Operation) error {
+	recorded := make(map[string]bool)
+	for _, s := range op.Steps {
+		if s.Kind == stepDisk && s.Name != "" {
+			recorded[s.Name] = true
+		}
+	}
+	disks, err := k.P.ListDisks("uuid-")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, d := range disks {
+		if k.diskOperation(d) != op.ID || recorded[d.Name] {
+			continue
+		}
+		log.Printf("deleting disk %v left by operation %v", d.Name, op.ID)
+		errs = append(errs, k.P.DeleteDisk(d.Name))
+	}
+	return compositeErr(errs...)
+}
+
+// deleteStepResource deletes the resource s created, if it exists.
+func (k *Kube) deleteStepResource(s *types.OperationStep) error {
+	switch s.Kind {
+	case stepDisk:
+		return k.P.DeleteDisk(s.Name)
+	case stepService:
+		svc, err := k.getService(s.Name)
+		if err != nil || svc == nil {
+			return err
+		}
+		return k.DeleteObject(svc)
+	case stepJob:
+		job, err := k.C.BatchClient.Jobs(k.userNamespace).Get(s.Name)
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		return k.DeleteObject(job)
+	case stepRC:
+		rc, err := k.getRC(s.Name)
+		if err != nil || rc == nil {
+			return err
+		}
+		return k.DeleteRC(rc)
+	case stepDeployment:
+		d, err := k.getDeployment(s.Name)
+		if err != nil || d == nil {
+			return err
+		}
+		return k.DeleteDeployment(d)
+	}
+	return fmt.Errorf("unknown kind of resource %#v", s.Kind)
+}
+
+// RollBackOperation rolls back an operation that was cut short, deleting
+// the resources it created.  It must only be called for operations that
+// nothing is running any more, before the project's config is applied
+// again.  The config is still to be applied, so the next attempt starts
+// the change over.
+func (k *Kube) RollBackOperation(op *types.Operation) error {
+	o := &operation{k: k, op: *op}
+	return o.rollBack(fmt.Errorf("interrupted"))
+}
@@ -0,0 +1,91 @@
+package kube
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+func TestOperationStep(t *testing.T) {
+	var saved []types.Operation
+	saveErr := error(nil)
+	k := &Kube{SaveOperation: func(op *types.Operation) error {
+		c := *op
+		c.Steps = append([]types.OperationStep(nil), op.Steps...)
+		saved = append(saved, c)
+		return saveErr
+	}}
+	op := k.startOperation("p", "EnsureProject")
+
+	err := op.step(stepDisk, "", func() (string, error) {
+		return "uuid-1", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 {
+		t.Fatalf("saved %d times, want before and after the step", len(saved))
+	}
+	if s := saved[0].Steps[0]; s.State != types.OperationRunning || s.Name != "" {
+		t.Errorf("step saved as %#v before it ran", s)
+	}
+	if s := saved[1].Steps[0]; s.State != types.OperationDone || s.Name != "uuid-1" {
+		t.Errorf("step saved as %#v after it ran", s)
+	}
+
+	err = op.step(stepRC, "r0-p", func() (string, error) {
+		return "", errors.New("no")
+	})
+	if err == nil || err.Error() != "no" {
+		t.Errorf("got error %v from failed step", err)
+	}
+	if s := saved[len(saved)-1].Steps[1]; s.State != types.OperationFailed || s.Error != "no" {
+		t.Errorf("failed step saved as %#v", s)
+	}
+
+	saveErr = errors.New("db down")
+	ran := false
+	err = op.step(stepService, "r-p", func() (string, error) {
+		ran = true
+		return "r-p", nil
+	})
+	if err == nil || ran {
+		t.Errorf("step ran (%v) or succeeded (%v) when it couldn't be saved", ran, err)
+	}
+
+	var nilOp *operation
+	ran = false
+	err = nilOp.step(stepService, "r-p", func() (string, error) {
+		ran = true
+		return "r-p", nil
+	})
+	if err != nil || !ran {
+		t.Errorf("nil operation didn't just run the step: %v", err)
+	}
+}
+
+func TestDiskTags(t *testing.T) {
+	k := &Kube{userNamespace: "user"}
+	for _, tc := range []struct {
+		desc      string
+		project   string
+		operation string
+	}{
+		{"hzc namespace=user project=p", "p", ""},
+		{"hzc namespace=user project=p operation=op1", "p", "op1"},
+		{"hzc namespace=other project=p operation=op1", "", ""},
+		{"hzc namespace=user project=", "", ""},
+		{"hzc namespace=user", "", ""},
+		{"created by hand", "", ""},
+	} {
+		d := &provider.Disk{Name: "uuid-1", Description: tc.desc}
+		if got := k.DiskProject(d); got != tc.project {
+			t.Errorf("DiskProject(%#v) = %#v, want %#v", tc.desc, got, tc.project)
+		}
+		if got := k.diskOperation(d); got != tc.operation {
+			t.Errorf("diskOperation(%#v) = %#v, want %#v", tc.desc, got, tc.operation)
+		}
+	}
+}
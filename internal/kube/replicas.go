@@ -0,0 +1,108 @@
+package kube
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	r "github.com/dancannon/gorethink"
+
+	"github.com/rethinkdb/horizon-cloud/internal/util"
+)
+
+const (
+	rdbConnectTimeout = 2 * time.Minute
+	rdbJoinTimeout    = 2 * time.Minute
+)
+
+type tableConfig struct {
+	DB     string `gorethink:"db"`
+	Name   string `gorethink:"name"`
+	Shards []struct {
+		Replicas []string `gorethink:"replicas"`
+	} `gorethink:"shards"`
+}
+
+func (t *tableConfig) hasReplicas(n int) bool {
+	for _, shard := range t.Shards {
+		if len(shard.Replicas) != n {
+			return false
+		}
+	}
+	return true
+}
+
+// rdbAddr returns the driver address of a project's RethinkDB service.
+func (k *Kube) rdbAddr(trueName string) string {
+	return "r-" + trueName + "." + k.userNamespace + ":28015"
+}
+
+func (k *Kube) connectRDB(trueName string) (*r.Session, error) {
+	addr := k.rdbAddr(trueName)
+	err := util.WaitConnectable("tcp", addr, rdbConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return r.Connect(r.ConnectOpts{Address: addr})
+}
+
+// ReconfigureRDB waits for `replicas` servers to join the project's
+// RethinkDB cluster, then sets the replica count of every table to match.
+func (k *Kube) ReconfigureRDB(trueName string, replicas int) error {
+	session, err := k.connectRDB(trueName)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	deadline := time.Now().Add(rdbJoinTimeout)
+	for {
+		var servers int
+		cur, err := r.DB("rethinkdb").Table("server_status").Count().Run(session)
+		if err != nil {
+			return err
+		}
+		err = cur.One(&servers)
+		if err != nil {
+			return err
+		}
+		if servers >= replicas {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("only %d of %d RethinkDB servers joined the cluster",
+				servers, replicas)
+		}
+		time.Sleep(time.Second)
+	}
+
+	cur, err := r.DB("rethinkdb").Table("table_config").Run(session)
+	if err != nil {
+		return err
+	}
+	var tables []tableConfig
+	err = cur.All(&tables)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		if t.hasReplicas(replicas) {
+			continue
+		}
+		shards := len(t.Shards)
+		if shards == 0 {
+			shards = 1
+		}
+		log.Printf("reconfiguring %s.%s to %d replicas", t.DB, t.Name, replicas)
+		cur, err := r.DB(t.DB).Table(t.Name).Reconfigure(r.ReconfigureOpts{
+			Shards:   shards,
+			Replicas: replicas,
+		}).Run(session)
+		if err != nil {
+			return err
+		}
+		cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// rdbDataPath is where RethinkDB replicas mount their disks.
+const rdbDataPath = "/data"
+
+// statusEvents is how many of each pod's most recent events are reported.
+const statusEvents = 5
+
+type byLastTimestamp []kapi.Event
+
+func (s byLastTimestamp) Len() int      { return len(s) }
+func (s byLastTimestamp) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byLastTimestamp) Less(i, j int) bool {
+	return s[i].LastTimestamp.Time.Before(s[j].LastTimestamp.Time)
+}
+
+// podStatuses returns the state of the pods matching selector.  Pods whose
+// events can't be read are reported without them.
+func (k *Kube) podStatuses(selector map[string]string) ([]types.PodStatus, error) {
+	podlist, err := k.C.Pods(k.userNamespace).List(kapi.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]types.PodStatus, 0, len(podlist.Items))
+	for i := range podlist.Items {
+		pod := &podlist.Items[i]
+		s := types.PodStatus{
+			Name:        pod.Name,
+			Phase:       string(pod.Status.Phase),
+			Ready:       podReady(pod),
+			Terminating: pod.DeletionTimestamp != nil,
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			s.Restarts += int(cs.RestartCount)
+			if w := cs.State.Waiting; w != nil && s.Waiting == "" {
+				s.Waiting = w.Reason
+			}
+		}
+		events, err := k.C.Events(k.userNamespace).Search(pod)
+		if err == nil {
+			items := events.Items
+			sort.Sort(byLastTimestamp(items))
+			if len(items) > statusEvents {
+				items = items[len(items)-statusEvents:]
+			}
+			for _, e := range items {
+				s.Events = append(s.Events, types.Event{
+					Time:    e.LastTimestamp.Time,
+					Reason:  e.Reason,
+					Message: e.Message,
+					Count:   int(e.Count),
+				})
+			}
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// parseDF returns the used and available bytes from the output of
+// `df -Pk` for one filesystem.
+func parseDF(out string) (int64, int64, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		return 0, 0, fmt.Errorf("unexpected df output %q", out)
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) < 4 {
+		return 0, 0, fmt.Errorf("unexpected df output %q", out)
+	}
+	used, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	avail, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return used * 1024, avail * 1024, nil
+}
+
+// diskStatus returns the size of a replica's disk, and how full it is if
+// one of pods is ready to be asked.
+func (k *Kube) diskStatus(
+	volName string, pods []types.PodStatus) (*types.DiskStatus, error) {
+	disk, err := k.P.GetDisk(volName)
+	if err != nil {
+		return nil, err
+	}
+	ds := &types.DiskStatus{Name: volName, SizeGB: disk.SizeGB}
+	for _, pod := range pods {
+		if !pod.Ready {
+			continue
+		}
+		stdout, stderr, err := k.Exec(ExecOptions{
+			PodName: pod.Name,
+			Command: []string{"df", "-Pk", rdbDataPath},
+		})
+		if err != nil {
+			return ds, fmt.Errorf("df failed: %v\nStderr:\n%v", err, stderr)
+		}
+		ds.UsedBytes, ds.AvailableBytes, err = parseDF(stdout)
+		return ds, err
+	}
+	return ds, nil
+}
+
+// rdbReplicaStatus returns the state of one of a project's RethinkDB
+// replicas, or nil if it doesn't exist.
+func (k *Kube) rdbReplicaStatus(
+	trueName string, index int) (*types.ComponentStatus, error) {
+	rc, err := k.getRC(rdbRCName(trueName, index))
+	if err != nil || rc == nil {
+		return nil, err
+	}
+	cs := &types.ComponentStatus{
+		Object:   "rc/" + rc.Name,
+		Replicas: int(rc.Spec.Replicas),
+	}
+	cs.Pods, err = k.podStatuses(rc.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	volName, err := k.rcVolume(rc)
+	if err != nil {
+		cs.Error = err.Error()
+		return cs, nil
+	}
+	cs.Disk, err = k.diskStatus(volName, cs.Pods)
+	if err != nil {
+		cs.Error = err.Error()
+	}
+	return cs, nil
+}
+
+// horizonStatus returns the state of a project's Horizon servers, which
+// run as a Deployment, or as an RC for projects from before that.
+func (k *Kube) horizonStatus(trueName string) (*types.ComponentStatus, error) {
+	d, err := k.getDeployment(horizonName(trueName))
+	if err != nil {
+		return nil, err
+	}
+	cs := &types.ComponentStatus{}
+	var selector map[string]string
+	if d != nil {
+		cs.Object = "deployment/" + d.Name
+		cs.Replicas = int(d.Spec.Replicas)
+		selector = d.Spec.Template.Labels
+	} else {
+		rc, err := k.getRC(legacyHorizonRCName(trueName))
+		if err != nil {
+			return nil, err
+		}
+		if rc == nil {
+			return nil, nil
+		}
+		cs.Object = "rc/" + rc.Name
+		cs.Replicas = int(rc.Spec.Replicas)
+		selector = rc.Spec.Selector
+	}
+	cs.Pods, err = k.podStatuses(selector)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// ProjectStatus returns the live state of a project's RethinkDB replicas
+// and Horizon servers.  Components conf calls for that don't exist are
+// reported with an error.
+func (k *Kube) ProjectStatus(
+	trueName string, conf types.KubeConfig) ([]types.ComponentStatus, error) {
+	var ret []types.ComponentStatus
+	for i := 0; i < types.MaxNumRDB; i++ {
+		cs, err := k.rdbReplicaStatus(trueName, i)
+		if err != nil {
+			return nil, err
+		}
+		if cs == nil {
+			if i < conf.NumRDB {
+				ret = append(ret, types.ComponentStatus{
+					Object: "rc/" + rdbRCName(trueName, i),
+					Error:  "missing",
+				})
+			}
+			continue
+		}
+		ret = append(ret, *cs)
+	}
+
+	cs, err := k.horizonStatus(trueName)
+	if err != nil {
+		return nil, err
+	}
+	if cs == nil {
+		cs = &types.ComponentStatus{
+			Object: "deployment/" + horizonName(trueName),
+			Error:  "missing",
+		}
+	}
+	ret = append(ret, *cs)
+	return ret, nil
+}
@@ -0,0 +1,25 @@
+package kube
+
+import "testing"
+
+func TestParseDF(t *testing.T) {
+	out := "Filesystem     1024-blocks   Used Available Capacity Mounted on\n" +
+		"/dev/sdb          10190100 524288   9141524       6% /data\n"
+	used, avail, err := parseDF(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 524288*1024 || avail != 9141524*1024 {
+		t.Errorf("got used %d, available %d", used, avail)
+	}
+
+	for _, bad := range []string{
+		"",
+		"df: /data: No such file or directory\n",
+		"Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sdb 1 x 2 1% /data\n",
+	} {
+		if _, _, err := parseDF(bad); err == nil {
+			t.Errorf("no error parsing %q", bad)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package kube
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kext "k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Images are the container images a project runs.  Empty fields mean
+// $HORIZON_GCR_ID and $RETHINKDB_GCR_ID.
+type Images struct {
+	Horizon   string
+	RethinkDB string
+}
+
+// setImage changes the image rc's pods run and replaces them one at a
+// time, waiting for each new pod to be ready.
+func (k *Kube) setImage(
+	rc *kapi.ReplicationController, image string) (*kapi.ReplicationController, error) {
+	log.Printf("changing image of %s to %s", rc.Name, image)
+	rc.Spec.Template.Spec.Containers[0].Image = image
+	rc, err := k.C.ReplicationControllers(k.userNamespace).Update(rc)
+	if err != nil {
+		return nil, err
+	}
+	return rc, k.rollRC(rc)
+}
+
+// setDeploymentImage changes the image d's pods run and waits for d to
+// replace them.
+func (k *Kube) setDeploymentImage(
+	d *kext.Deployment, image string) (*kext.Deployment, error) {
+	log.Printf("changing image of %s to %s", d.Name, image)
+	d.Spec.Template.Spec.Containers[0].Image = image
+	d, err := k.C.ExtensionsClient.Deployments(k.userNamespace).Update(d)
+	if err != nil {
+		return nil, err
+	}
+	return d, k.waitDeployment(d)
+}
+
+// revertHorizon puts a project's Horizon Deployment back on image old.
+func (k *Kube) revertHorizon(name string, old string) error {
+	// The Deployment has changed since it was updated, at least in its
+	// status.
+	d, err := k.getDeployment(name)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return fmt.Errorf("%s has disappeared", name)
+	}
+	_, err = k.setDeploymentImage(d, old)
+	return err
+}
+
+// revertRDBReplica puts the RC of a RethinkDB replica back on image old,
+// one pod at a time.
+func (k *Kube) revertRDBReplica(name string, old string) error {
+	rc, err := k.getRC(name)
+	if err != nil {
+		return err
+	}
+	if rc == nil {
+		return fmt.Errorf("%s has disappeared", name)
+	}
+	_, err = k.setImage(rc, old)
+	return err
+}
+
+// An UpgradeReverted error is returned by UpgradeProject when the upgrade
+// failed and the project was put back on the images it ran before, which
+// are in Images.
+type UpgradeReverted struct {
+	Images Images
+	Err    error
+}
+
+func (e *UpgradeReverted) Error() string {
+	return fmt.Sprintf("Upgrade failed, so the old versions were restored: %v", e.Err)
+}
+
+// UpgradeProject moves a project's RethinkDB replicas, then its Horizon
+// servers, to images, one pod at a time.  Each pod must become ready
+// before the next is replaced, and the whole project must be ready at the
+// end; otherwise an error is returned.  Empty fields of images are left
+// alone.
+//
+// If the upgrade fails, Horizon and then the RethinkDB replicas are put
+// back on their old images the same way, last changed first, and an
+// *UpgradeReverted is returned.  Errors from before anything was changed
+// are returned as they are.
+func (k *Kube) UpgradeProject(
+	trueName string, conf types.KubeConfig, images Images) error {
+	p := &Project{RDB: &RDB{}, Horizon: &Horizon{}}
+
+	var old Images
+	// oldRDB holds the old image of each replica that was changed, and ""
+	// for those that weren't.
+	var oldRDB []string
+	changedRDB, changedHorizon := false, false
+	fail := func(err error) error {
+		log.Printf("upgrade of %s failed: %v", trueName, err)
+		if !changedRDB && !changedHorizon {
+			return err
+		}
+		var revertErrs []error
+		if changedHorizon {
+			log.Printf("reverting Horizon of %s to %s", trueName, old.Horizon)
+			revertErrs = append(revertErrs,
+				k.revertHorizon(horizonName(trueName), old.Horizon))
+		}
+		for i := len(oldRDB) - 1; i >= 0; i-- {
+			if oldRDB[i] == "" {
+				continue
+			}
+			log.Printf("reverting RethinkDB replica %d of %s to %s",
+				i, trueName, oldRDB[i])
+			revertErrs = append(revertErrs,
+				k.revertRDBReplica(rdbRCName(trueName, i), oldRDB[i]))
+		}
+		if revertErr := compositeErr(revertErrs...); revertErr != nil {
+			log.Printf("couldn't revert upgrade of %s: %v", trueName, revertErr)
+			return fmt.Errorf("upgrade failed (%v), and so did reverting it (%v)",
+				err, revertErr)
+		}
+		return &UpgradeReverted{old, err}
+	}
+
+	name := horizonName(trueName)
+	d, err := k.getDeployment(name)
+	if err != nil {
+		return fail(err)
+	}
+	if d == nil {
+		return fail(fmt.Errorf("%s doesn't exist", name))
+	}
+	old.Horizon = d.Spec.Template.Spec.Containers[0].Image
+
+	for i := 0; i < conf.NumRDB; i++ {
+		name := rdbRCName(trueName, i)
+		rc, err := k.getRC(name)
+		if err != nil {
+			return fail(err)
+		}
+		if rc == nil {
+			return fail(fmt.Errorf("%s doesn't exist", name))
+		}
+		image := rc.Spec.Template.Spec.Containers[0].Image
+		if i == 0 {
+			old.RethinkDB = image
+		}
+		oldRDB = append(oldRDB, "")
+		if images.RethinkDB != "" && image != images.RethinkDB {
+			oldRDB[i] = image
+			changedRDB = true
+			rc, err = k.setImage(rc, images.RethinkDB)
+			if err != nil {
+				return fail(err)
+			}
+		}
+		p.RDB.Replicas = append(p.RDB.Replicas, &RDBReplica{RC: rc})
+	}
+
+	// The Deployment may have changed while RethinkDB was upgraded.
+	d, err = k.getDeployment(name)
+	if err != nil {
+		return fail(err)
+	}
+	if d == nil {
+		return fail(fmt.Errorf("%s has disappeared", name))
+	}
+	if images.Horizon != "" && old.Horizon != images.Horizon {
+		changedHorizon = true
+		d, err = k.setDeploymentImage(d, images.Horizon)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	p.Horizon.Deployment = d
+	if !changedRDB && !changedHorizon {
+		return nil
+	}
+
+	err = k.Wait(p)
+	if err != nil {
+		return fail(err)
+	}
+	return nil
+}
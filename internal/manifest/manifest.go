@@ -0,0 +1,415 @@
+// Package manifest renders the Kubernetes objects of a project.
+//
+// Each manifest is built by a Go function from Params, unless the operator
+// has put a text/template file of the same name (plus ".yaml") in the
+// override directory, in which case that is executed with the Params
+// instead.  Either way the result is a stream of YAML or JSON documents.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+// The manifests a project has.
+const (
+	// Horizon is the Deployment and service of the Horizon servers.
+	Horizon = "horizon"
+	// RethinkDB is the service in front of all RethinkDB replicas and the
+	// job that sets the Horizon schema.
+	RethinkDB = "rethinkdb"
+	// RethinkDBReplica is the RC of one RethinkDB replica.
+	RethinkDBReplica = "rethinkdb-replica"
+	// RethinkDBResize is the job that grows the filesystem on a RethinkDB
+	// replica's disk after the disk has been resized.
+	RethinkDBResize = "rethinkdb-resize"
+)
+
+// Params are what a project's manifests are rendered from.
+type Params struct {
+	// Project is the project's Kube name.
+	Project    string
+	KubeConfig types.KubeConfig
+
+	HorizonImage   string
+	RethinkDBImage string
+
+	// EnvNames are the names of the variables in the project's env
+	// Secret, which Horizon servers get on top of (or instead of) the
+	// default environment.
+	EnvNames []string
+
+	// Replica and Volume are only used by RethinkDBReplica and
+	// RethinkDBResize: the index of the replica, and the JSON of its `data`
+	// volume, which depends on the cloud provider.
+	Replica int
+	Volume  string
+}
+
+// EnvSecretName is the name of the Secret holding a project's env.
+func EnvSecretName(project string) string {
+	return "e-" + project
+}
+
+// RDBReplicaName is the name of the RC of a RethinkDB replica.
+func RDBReplicaName(project string, index int) string {
+	return fmt.Sprintf("r%d-%s", index, project)
+}
+
+// RDBResizeName is the name of the job that grows the filesystem of a
+// RethinkDB replica.
+func RDBResizeName(project string, index int) string {
+	return "fs-" + RDBReplicaName(project, index)
+}
+
+var builders = map[string]func(p *Params) ([]interface{}, error){
+	Horizon:          horizon,
+	RethinkDB:        rethinkdb,
+	RethinkDBReplica: rethinkdbReplica,
+	RethinkDBResize:  rethinkdbResize,
+}
+
+// A Renderer renders manifests, preferring the templates in its override
+// directory to the built-in ones.
+type Renderer struct {
+	overrideDir string
+}
+
+// NewRenderer returns a Renderer using the templates in overrideDir.  It
+// is fine for overrideDir to be empty or to not contain every manifest.
+func NewRenderer(overrideDir string) *Renderer {
+	return &Renderer{overrideDir: overrideDir}
+}
+
+// Render renders the named manifest.  Override templates are read each
+// time, so changing them doesn't need a restart.
+func (r *Renderer) Render(name string, p *Params) ([]byte, error) {
+	build, ok := builders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown manifest %#v", name)
+	}
+
+	if r.overrideDir != "" {
+		path := filepath.Join(r.overrideDir, name+".yaml")
+		tmpl, err := template.ParseFiles(path)
+		if err == nil {
+			var buf bytes.Buffer
+			err = tmpl.Execute(&buf, p)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't execute %v: %v", path, err)
+			}
+			return buf.Bytes(), nil
+		}
+		if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+			return nil, fmt.Errorf("couldn't parse %v: %v", path, err)
+		}
+	}
+
+	objs, err := build(p)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, o := range objs {
+		data, err := json.MarshalIndent(o, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func emptyDirVolume(name string) json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"name":%q,"emptyDir":{}}`, name))
+}
+
+// noAPIAccess keeps containers from using the service account token that
+// would otherwise be mounted into them.
+var noAPIAccess = VolumeMount{
+	Name:      "disable-api-access",
+	MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+}
+
+// defaultHorizonEnv is the environment of Horizon servers before the
+// project's env is applied.  The HZ_* options other than HZ_SERVE_STATIC,
+// HZ_CONNECT and HZ_BIND are only the defaults for projects that have never
+// changed their Horizon settings; keep them in sync with
+// types.DefaultHorizonSettings.
+func defaultHorizonEnv(project string) []EnvVar {
+	return []EnvVar{
+		{Name: "HZ_SERVE_STATIC", Value: "dist"},
+		{Name: "HZ_DEBUG", Value: "yes"},
+		{Name: "HZ_PERMISSIONS", Value: "yes"},
+		{Name: "HZ_ALLOW_UNAUTHENTICATED", Value: "yes"},
+		{Name: "HZ_ALLOW_ANONYMOUS", Value: "yes"},
+		{Name: "HZ_SECURE", Value: "no"},
+		{Name: "HZ_AUTO_CREATE_COLLECTION", Value: "no"},
+		{Name: "HZ_AUTO_CREATE_INDEX", Value: "yes"},
+		{Name: "HZ_CONNECT", Value: "r-" + project + ":28015"},
+		{Name: "HZ_BIND", Value: "0.0.0.0"},
+	}
+}
+
+// horizonEnv returns the default environment, overridden by references to
+// every variable in the project's env Secret.
+func horizonEnv(p *Params) []EnvVar {
+	overridden := make(map[string]bool, len(p.EnvNames))
+	for _, name := range p.EnvNames {
+		overridden[name] = true
+	}
+	var env []EnvVar
+	for _, v := range defaultHorizonEnv(p.Project) {
+		if !overridden[v.Name] {
+			env = append(env, v)
+		}
+	}
+	names := append([]string(nil), p.EnvNames...)
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, EnvVar{
+			Name: name,
+			ValueFrom: &EnvVarSource{
+				SecretKeyRef: &SecretKeySelector{
+					Name: EnvSecretName(p.Project),
+					Key:  name,
+				},
+			},
+		})
+	}
+	return env
+}
+
+// horizonPodSpec is the pod spec of Horizon servers, and of the job that
+// sets the schema, which runs command instead.
+func horizonPodSpec(p *Params, command []string) PodSpec {
+	return PodSpec{
+		Containers: []Container{{
+			Name:    "horizon",
+			Image:   p.HorizonImage,
+			Command: command,
+			Resources: ResourceRequirements{
+				Limits: map[string]string{"cpu": "50m", "memory": "128Mi"},
+			},
+			VolumeMounts:   []VolumeMount{noAPIAccess},
+			Env:            horizonEnv(p),
+			ReadinessProbe: &Probe{TCPSocket: &TCPSocketAction{Port: 8181}},
+			Ports: []ContainerPort{
+				{ContainerPort: 8181, Name: "horizon", Protocol: "TCP"},
+			},
+		}},
+		Volumes: []json.RawMessage{emptyDirVolume(noAPIAccess.Name)},
+	}
+}
+
+func horizon(p *Params) ([]interface{}, error) {
+	labels := map[string]string{
+		"app":     "horizon",
+		"project": p.Project,
+		"version": "v5",
+	}
+	return []interface{}{
+		&Deployment{
+			TypeMeta: TypeMeta{"extensions/v1beta1", "Deployment"},
+			Metadata: ObjectMeta{Name: "h-" + p.Project, Labels: labels},
+			Spec: DeploymentSpec{
+				Replicas: p.KubeConfig.NumHorizon,
+				Selector: LabelSelector{MatchLabels: labels},
+				// Replace pods one at a time, only taking an old one down
+				// once its replacement is ready.
+				Strategy: DeploymentStrategy{
+					Type: "RollingUpdate",
+					RollingUpdate: &RollingUpdateDeployment{
+						MaxUnavailable: 0,
+						MaxSurge:       1,
+					},
+				},
+				Template: PodTemplateSpec{
+					Metadata: ObjectMeta{Labels: labels},
+					Spec:     horizonPodSpec(p, nil),
+				},
+			},
+		},
+		&Service{
+			TypeMeta: TypeMeta{"v1", "Service"},
+			Metadata: ObjectMeta{
+				Name: "h-" + p.Project,
+				Labels: map[string]string{
+					"app":     "horizon",
+					"project": p.Project,
+				},
+			},
+			Spec: ServiceSpec{
+				Type: "ClusterIP",
+				Selector: map[string]string{
+					"app":     "horizon",
+					"project": p.Project,
+				},
+				Ports: []ServicePort{{Name: "http", Port: 8181}},
+			},
+		},
+	}, nil
+}
+
+func rethinkdb(p *Params) ([]interface{}, error) {
+	return []interface{}{
+		&Service{
+			TypeMeta: TypeMeta{"v1", "Service"},
+			Metadata: ObjectMeta{
+				Name: "r-" + p.Project,
+				Labels: map[string]string{
+					"app":     "rethinkdb",
+					"project": p.Project,
+				},
+			},
+			Spec: ServiceSpec{
+				Selector: map[string]string{
+					"app":     "rethinkdb",
+					"project": p.Project,
+				},
+				Ports: []ServicePort{
+					{Name: "driver", Port: 28015},
+					{Name: "intracluster", Port: 29015},
+					{Name: "webui", Port: 8080},
+				},
+			},
+		},
+		&Job{
+			TypeMeta: TypeMeta{"batch/v1", "Job"},
+			Metadata: ObjectMeta{Name: "ss-" + p.Project},
+			Spec: JobSpec{
+				ActiveDeadlineSeconds: 300,
+				Template: PodTemplateSpec{
+					Metadata: ObjectMeta{Name: "ss-" + p.Project},
+					Spec: func() PodSpec {
+						// The job is only run once, when the project is
+						// created, so it doesn't get the project's env.
+						jp := *p
+						jp.EnvNames = nil
+						spec := horizonPodSpec(&jp, []string{
+							"/bin/bash", "-c", "echo | hz set-schema -n app -"})
+						spec.RestartPolicy = "OnFailure"
+						return spec
+					}(),
+				},
+			},
+		},
+	}, nil
+}
+
+func rethinkdbReplica(p *Params) ([]interface{}, error) {
+	var volume map[string]interface{}
+	if err := json.Unmarshal([]byte(p.Volume), &volume); err != nil {
+		return nil, fmt.Errorf("bad volume for replica %d: %v", p.Replica, err)
+	}
+	labels := map[string]string{
+		"app":     "rethinkdb",
+		"project": p.Project,
+		"replica": fmt.Sprintf("%d", p.Replica),
+		"version": "v2",
+	}
+	return []interface{}{
+		&ReplicationController{
+			TypeMeta: TypeMeta{"v1", "ReplicationController"},
+			Metadata: ObjectMeta{
+				Name:   RDBReplicaName(p.Project, p.Replica),
+				Labels: labels,
+			},
+			Spec: ReplicationControllerSpec{
+				Replicas: 1,
+				Selector: labels,
+				Template: PodTemplateSpec{
+					Metadata: ObjectMeta{Labels: labels},
+					Spec: PodSpec{
+						Containers: []Container{{
+							Name:  "rethinkdb",
+							Image: p.RethinkDBImage,
+							Resources: ResourceRequirements{
+								Limits: map[string]string{"cpu": "250m", "memory": "512Mi"},
+							},
+							VolumeMounts: []VolumeMount{
+								noAPIAccess,
+								{Name: "data", MountPath: "/data"},
+							},
+							Env: []EnvVar{
+								{Name: "RDB_CACHE_SIZE", Value: "384"},
+								{Name: "RDB_JOIN", Value: "r-" + p.Project + ":29015"},
+							},
+							ReadinessProbe: &Probe{TCPSocket: &TCPSocketAction{Port: 28015}},
+							Ports: []ContainerPort{
+								{ContainerPort: 28015, Name: "driver", Protocol: "TCP"},
+								{ContainerPort: 29015, Name: "intracluster", Protocol: "TCP"},
+								{ContainerPort: 8080, Name: "webui", Protocol: "TCP"},
+							},
+						}},
+						Volumes: []json.RawMessage{
+							emptyDirVolume(noAPIAccess.Name),
+							json.RawMessage(p.Volume),
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// rethinkdbResize grows the filesystem on a replica's disk to fill it.
+// That needs the disk's device, which only privileged containers can see,
+// so it's done by a job that runs nothing else, while the replica is
+// stopped, rather than by the replica itself.
+func rethinkdbResize(p *Params) ([]interface{}, error) {
+	var volume map[string]interface{}
+	if err := json.Unmarshal([]byte(p.Volume), &volume); err != nil {
+		return nil, fmt.Errorf("bad volume for replica %d: %v", p.Replica, err)
+	}
+	name := RDBResizeName(p.Project, p.Replica)
+	privileged := true
+	return []interface{}{
+		&Job{
+			TypeMeta: TypeMeta{"batch/v1", "Job"},
+			Metadata: ObjectMeta{Name: name},
+			Spec: JobSpec{
+				ActiveDeadlineSeconds: 300,
+				Template: PodTemplateSpec{
+					Metadata: ObjectMeta{
+						Name: name,
+						Labels: map[string]string{
+							"app":     "rethinkdb-resize",
+							"project": p.Project,
+							"replica": fmt.Sprintf("%d", p.Replica),
+						},
+					},
+					Spec: PodSpec{
+						RestartPolicy: "Never",
+						Containers: []Container{{
+							Name:  "resize",
+							Image: p.RethinkDBImage,
+							Command: []string{"/bin/sh", "-c",
+								`resize2fs "$(awk '$2 == "/data" { print $1 }' /proc/mounts)"`},
+							SecurityContext: &SecurityContext{Privileged: &privileged},
+							Resources: ResourceRequirements{
+								Limits: map[string]string{"cpu": "100m", "memory": "128Mi"},
+							},
+							VolumeMounts: []VolumeMount{
+								noAPIAccess,
+								{Name: "data", MountPath: "/data"},
+							},
+						}},
+						Volumes: []json.RawMessage{
+							emptyDirVolume(noAPIAccess.Name),
+							json.RawMessage(p.Volume),
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
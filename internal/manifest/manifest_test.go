@@ -0,0 +1,115 @@
+package manifest
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rethinkdb/horizon-cloud/internal/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var testParams = Params{
+	Project: "hzctest",
+	KubeConfig: types.KubeConfig{
+		NumRDB:     2,
+		SizeRDB:    10,
+		NumHorizon: 3,
+	},
+	HorizonImage:   "gcr.io/hzc/horizon:1",
+	RethinkDBImage: "gcr.io/hzc/rethinkdb:1",
+	EnvNames:       []string{"HZ_DEBUG", "API_KEY"},
+	Replica:        1,
+	Volume:         `{"name":"data","gcePersistentDisk":{"pdName":"disk-1","fsType":"ext4"}}`,
+}
+
+func TestGolden(t *testing.T) {
+	r := NewRenderer("")
+	for _, name := range []string{Horizon, RethinkDB, RethinkDBReplica, RethinkDBResize} {
+		got, err := r.Render(name, &testParams)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		path := filepath.Join("testdata", name+".golden")
+		if *update {
+			err = ioutil.WriteFile(path, got, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v doesn't match %v; got:\n%s", name, path, got)
+		}
+	}
+}
+
+func TestRenderBadVolume(t *testing.T) {
+	p := testParams
+	p.Volume = "data"
+	_, err := NewRenderer("").Render(RethinkDBReplica, &p)
+	if err == nil {
+		t.Errorf("rendered a replica with a bad volume")
+	}
+}
+
+func TestOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpl := "kind: Service\nmetadata:\n  name: h-{{.Project}}\n" +
+		"{{range .EnvNames}}# {{.}}\n{{end}}"
+	err = ioutil.WriteFile(filepath.Join(dir, "horizon.yaml"), []byte(tmpl), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRenderer(dir)
+
+	got, err := r.Render(Horizon, &testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "kind: Service\nmetadata:\n  name: h-hzctest\n# HZ_DEBUG\n# API_KEY\n"
+	if string(got) != want {
+		t.Errorf("override rendered %q, want %q", got, want)
+	}
+
+	// Manifests without an override are still built in.
+	got, err = r.Render(RethinkDB, &testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), `"name": "r-hzctest"`) {
+		t.Errorf("built-in rethinkdb manifest not used:\n%s", got)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "rethinkdb.yaml"),
+		[]byte("{{.NoSuchField}}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Render(RethinkDB, &testParams)
+	if err == nil {
+		t.Errorf("broken override didn't fail")
+	}
+}
+
+func TestRenderUnknown(t *testing.T) {
+	_, err := NewRenderer("").Render("nginx", &testParams)
+	if err == nil {
+		t.Errorf("rendered an unknown manifest")
+	}
+}
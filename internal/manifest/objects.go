@@ -0,0 +1,152 @@
+package manifest
+
+import "encoding/json"
+
+// These mirror the parts of the Kubernetes v1 API that project manifests
+// use.  They only need to serialize the same way; the kube package decodes
+// manifests into its own types.
+
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+type ObjectMeta struct {
+	Name        string            `json:"name,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ServicePort struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+type ServiceSpec struct {
+	Type     string            `json:"type,omitempty"`
+	Selector map[string]string `json:"selector"`
+	Ports    []ServicePort     `json:"ports"`
+}
+
+type Service struct {
+	TypeMeta
+	Metadata ObjectMeta  `json:"metadata"`
+	Spec     ServiceSpec `json:"spec"`
+}
+
+type SecurityContext struct {
+	Privileged *bool `json:"privileged,omitempty"`
+}
+
+type ResourceRequirements struct {
+	Limits map[string]string `json:"limits,omitempty"`
+}
+
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+}
+
+type SecretKeySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type EnvVarSource struct {
+	SecretKeyRef *SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+type EnvVar struct {
+	Name      string        `json:"name"`
+	Value     string        `json:"value,omitempty"`
+	ValueFrom *EnvVarSource `json:"valueFrom,omitempty"`
+}
+
+type TCPSocketAction struct {
+	Port int `json:"port"`
+}
+
+type Probe struct {
+	TCPSocket *TCPSocketAction `json:"tcpSocket,omitempty"`
+}
+
+type ContainerPort struct {
+	ContainerPort int    `json:"containerPort"`
+	Name          string `json:"name"`
+	Protocol      string `json:"protocol"`
+}
+
+type Container struct {
+	Name            string               `json:"name"`
+	Image           string               `json:"image"`
+	Command         []string             `json:"command,omitempty"`
+	SecurityContext *SecurityContext     `json:"securityContext,omitempty"`
+	Resources       ResourceRequirements `json:"resources"`
+	VolumeMounts    []VolumeMount        `json:"volumeMounts,omitempty"`
+	Env             []EnvVar             `json:"env,omitempty"`
+	ReadinessProbe  *Probe               `json:"readinessProbe,omitempty"`
+	Ports           []ContainerPort      `json:"ports,omitempty"`
+}
+
+type PodSpec struct {
+	RestartPolicy string      `json:"restartPolicy,omitempty"`
+	Containers    []Container `json:"containers"`
+	// Volumes are raw JSON because the data volumes of RethinkDB replicas
+	// depend on the cloud provider.
+	Volumes []json.RawMessage `json:"volumes,omitempty"`
+}
+
+type PodTemplateSpec struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     PodSpec    `json:"spec"`
+}
+
+type ReplicationControllerSpec struct {
+	Replicas int               `json:"replicas"`
+	Selector map[string]string `json:"selector"`
+	Template PodTemplateSpec   `json:"template"`
+}
+
+type ReplicationController struct {
+	TypeMeta
+	Metadata ObjectMeta                `json:"metadata"`
+	Spec     ReplicationControllerSpec `json:"spec"`
+}
+
+type LabelSelector struct {
+	MatchLabels map[string]string `json:"matchLabels"`
+}
+
+type RollingUpdateDeployment struct {
+	MaxUnavailable int `json:"maxUnavailable"`
+	MaxSurge       int `json:"maxSurge"`
+}
+
+type DeploymentStrategy struct {
+	Type          string                   `json:"type"`
+	RollingUpdate *RollingUpdateDeployment `json:"rollingUpdate,omitempty"`
+}
+
+type DeploymentSpec struct {
+	Replicas int                `json:"replicas"`
+	Selector LabelSelector      `json:"selector"`
+	Strategy DeploymentStrategy `json:"strategy"`
+	Template PodTemplateSpec    `json:"template"`
+}
+
+type Deployment struct {
+	TypeMeta
+	Metadata ObjectMeta     `json:"metadata"`
+	Spec     DeploymentSpec `json:"spec"`
+}
+
+type JobSpec struct {
+	ActiveDeadlineSeconds int             `json:"activeDeadlineSeconds"`
+	Template              PodTemplateSpec `json:"template"`
+}
+
+type Job struct {
+	TypeMeta
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     JobSpec    `json:"spec"`
+}
@@ -0,0 +1,399 @@
+package local
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+	"github.com/rethinkdb/horizon-cloud/internal/util"
+)
+
+var _ provider.ObjectStore = &Dir{}
+
+// Dir is an object store kept in a directory, with one subdirectory per
+// bucket.  Content types are kept in a parallel tree under each bucket's
+// .meta directory.  Objects are served by Handler.
+type Dir struct {
+	root      string
+	objectURL string
+	key       []byte
+}
+
+// NewDir returns a Dir storing objects under root.  Handler must be
+// reachable by clients at objectURL; key is used to sign upload URLs.
+func NewDir(root string, objectURL string, key []byte) (*Dir, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{root, strings.TrimSuffix(objectURL, "/"), key}, nil
+}
+
+func (d *Dir) objectPath(bucket, name string) (string, error) {
+	if !validName(bucket) || name == "" || !util.IsSafeRelPath(name) ||
+		name == metaDir || strings.HasPrefix(name, metaDir+"/") {
+		return "", fmt.Errorf("invalid object name %#v in bucket %#v", name, bucket)
+	}
+	return filepath.Join(d.root, bucket, filepath.FromSlash(name)), nil
+}
+
+// metaDir holds the content types of a bucket's objects; it's not a
+// valid object name, so it can't collide with one.
+const metaDir = ".meta"
+
+func (d *Dir) typePath(bucket, name string) string {
+	return filepath.Join(d.root, bucket, metaDir, filepath.FromSlash(name))
+}
+
+func (d *Dir) contentType(bucket, name string) string {
+	data, err := ioutil.ReadFile(d.typePath(bucket, name))
+	if err != nil {
+		return mime.TypeByExtension(path.Ext(name))
+	}
+	return string(data)
+}
+
+func fileMD5(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := md5.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func (d *Dir) attrs(
+	bucket, name, p string, info os.FileInfo) (*provider.ObjectAttrs, error) {
+	sum, err := fileMD5(p)
+	if err != nil {
+		return nil, err
+	}
+	return &provider.ObjectAttrs{
+		Name:        name,
+		Size:        info.Size(),
+		ContentType: d.contentType(bucket, name),
+		MD5:         sum,
+	}, nil
+}
+
+type attrsByName []*provider.ObjectAttrs
+
+func (a attrsByName) Len() int           { return len(a) }
+func (a attrsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a attrsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+func (d *Dir) List(bucket, prefix string) ([]*provider.ObjectAttrs, error) {
+	if !validName(bucket) {
+		return nil, fmt.Errorf("invalid bucket name %#v", bucket)
+	}
+	bucketDir := filepath.Join(d.root, bucket)
+
+	var ret []*provider.ObjectAttrs
+	err := filepath.Walk(bucketDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && p == filepath.Join(bucketDir, metaDir) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		attrs, err := d.attrs(bucket, name, p, info)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, attrs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(attrsByName(ret))
+	return ret, nil
+}
+
+func (d *Dir) Stat(bucket, name string) (*provider.ObjectAttrs, error) {
+	p, err := d.objectPath(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		return nil, provider.ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.attrs(bucket, name, p, info)
+}
+
+func (d *Dir) NewReader(bucket, name string) (io.ReadCloser, error) {
+	p, err := d.objectPath(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, provider.ErrObjectNotExist
+	}
+	return f, err
+}
+
+type dirWriter struct {
+	f           *os.File
+	path        string
+	typePath    string
+	contentType string
+	err         error
+}
+
+func (w *dirWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.f.Write(p)
+}
+
+func (w *dirWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	err := w.f.Close()
+	if err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(w.typePath), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(w.typePath, []byte(w.contentType), 0644)
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	return os.Rename(w.f.Name(), w.path)
+}
+
+func (w *dirWriter) CloseWithError(err error) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+// NewWriter writes to a temporary file which is renamed into place on
+// Close.
+func (d *Dir) NewWriter(bucket, name, contentType string) provider.ObjectWriter {
+	p, err := d.objectPath(bucket, name)
+	if err != nil {
+		return &dirWriter{err: err}
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return &dirWriter{err: err}
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return &dirWriter{err: err}
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	return &dirWriter{
+		f:           f,
+		path:        p,
+		typePath:    d.typePath(bucket, name),
+		contentType: contentType,
+	}
+}
+
+func (d *Dir) Copy(srcBucket, srcName, dstBucket, dstName string) error {
+	rd, err := d.NewReader(srcBucket, srcName)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	w := d.NewWriter(dstBucket, dstName, d.contentType(srcBucket, srcName))
+	_, err = io.Copy(w, rd)
+	if err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	return w.Close()
+}
+
+func (d *Dir) Delete(bucket, name string) error {
+	p, err := d.objectPath(bucket, name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return provider.ErrObjectNotExist
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Remove(d.typePath(bucket, name))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+func (d *Dir) signature(
+	method, bucket, name, contentType, md5base64 string, expires int64) string {
+	mac := hmac.New(sha256.New, d.key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%d",
+		method, bucket, name, contentType, md5base64, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dir) SignPut(bucket, name, contentType string, md5 []byte,
+	expires time.Time) (*provider.SignedRequest, error) {
+	if _, err := d.objectPath(bucket, name); err != nil {
+		return nil, err
+	}
+	md5base64 := base64.StdEncoding.EncodeToString(md5)
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("signature", d.signature(
+		"PUT", bucket, name, contentType, md5base64, expires.Unix()))
+
+	u := d.objectURL + "/" + bucket + "/" + (&url.URL{Path: name}).EscapedPath()
+	return &provider.SignedRequest{
+		Method: "PUT",
+		URL:    u + "?" + q.Encode(),
+		Headers: map[string]string{
+			"Content-Type":  contentType,
+			"Cache-Control": "private,no-cache",
+			"Content-MD5":   md5base64,
+		},
+	}, nil
+}
+
+func (d *Dir) PublicURL() string {
+	return d.objectURL + "/"
+}
+
+// Handler serves objects to anyone with GET and accepts uploads signed by
+// SignPut.  It expects the request path to be /<bucket>/<name>, relative
+// to objectURL.
+func (d *Dir) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		bucket, name := parts[0], parts[1]
+		p, err := d.objectPath(bucket, name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case "GET", "HEAD":
+			f, err := os.Open(p)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil || !info.Mode().IsRegular() {
+				http.NotFound(w, r)
+				return
+			}
+			if ct := d.contentType(bucket, name); ct != "" {
+				w.Header().Set("Content-Type", ct)
+			}
+			http.ServeContent(w, r, name, info.ModTime(), f)
+		case "PUT":
+			code, err := d.handlePut(r, bucket, name)
+			if err != nil {
+				http.Error(w, err.Error(), code)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+var errBadSignature = errors.New("bad signature")
+
+func (d *Dir) handlePut(r *http.Request, bucket, name string) (int, error) {
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return http.StatusForbidden, errBadSignature
+	}
+	if time.Now().Unix() > expires {
+		return http.StatusForbidden, errors.New("signature expired")
+	}
+	md5base64 := r.Header.Get("Content-MD5")
+	want := d.signature("PUT", bucket, name,
+		r.Header.Get("Content-Type"), md5base64, expires)
+	if !hmac.Equal([]byte(want), []byte(q.Get("signature"))) {
+		return http.StatusForbidden, errBadSignature
+	}
+
+	w := d.NewWriter(bucket, name, r.Header.Get("Content-Type"))
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(w, hash), r.Body)
+	if err != nil {
+		w.CloseWithError(err)
+		return http.StatusInternalServerError, err
+	}
+	if base64.StdEncoding.EncodeToString(hash.Sum(nil)) != md5base64 {
+		err := errors.New("Content-MD5 does not match body")
+		w.CloseWithError(err)
+		return http.StatusBadRequest, err
+	}
+	err = w.Close()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
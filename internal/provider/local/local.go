@@ -0,0 +1,339 @@
+// Package local implements provider.Provider on top of a directory on the
+// local filesystem.  Disks are directories mounted into pods with
+// hostPath volumes, so the directory must be at the same path on every
+// node that runs RethinkDB; in practice this means a single-node cluster.
+// It is meant for on-prem installs and testing.
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pborman/uuid"
+
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+)
+
+var _ provider.Provider = &Local{}
+
+type Local struct {
+	root    string
+	objects *Dir
+}
+
+// New returns a Local storing everything under root.  Objects are kept
+// in a Dir under root/objects; see NewDir for objectURL and key.
+func New(root string, objectURL string, key []byte) (*Local, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"disks", "snapshots"} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	objects, err := NewDir(filepath.Join(root, "objects"), objectURL, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{root, objects}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Disks
+
+// A disk is stored as disks/<name>/meta.json, describing it, and
+// disks/<name>/data, which is what gets mounted.  Snapshots are laid out
+// the same way under snapshots/.
+
+type diskMeta struct {
+	SizeGB      int64
+	Description string
+	SourceDisk  string `json:",omitempty"`
+}
+
+func (l *Local) diskDir(name string) string {
+	return filepath.Join(l.root, "disks", name)
+}
+
+func (l *Local) snapshotDir(name string) string {
+	return filepath.Join(l.root, "snapshots", name)
+}
+
+func readMeta(dir string) (*diskMeta, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+	var meta diskMeta
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func writeMeta(dir string, meta *diskMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "meta.json.tmp")
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "meta.json"))
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\x00")
+}
+
+func (l *Local) CreateDisk(
+	sizeGB int64, disktype provider.DiskType, description string) (*provider.Disk, error) {
+	return l.createDisk(sizeGB, description, "")
+}
+
+func (l *Local) CreateDiskFromSnapshot(
+	snapshot string, sizeGB int64, disktype provider.DiskType, description string) (*provider.Disk, error) {
+	if !validName(snapshot) {
+		return nil, fmt.Errorf("invalid snapshot name %#v", snapshot)
+	}
+	meta, err := readMeta(l.snapshotDir(snapshot))
+	if err != nil {
+		return nil, err
+	}
+	if sizeGB < meta.SizeGB {
+		return nil, fmt.Errorf("disk size %vGB is smaller than snapshot size %vGB",
+			sizeGB, meta.SizeGB)
+	}
+	return l.createDisk(sizeGB, description, l.snapshotDir(snapshot))
+}
+
+func (l *Local) createDisk(
+	sizeGB int64, description string, fromDir string) (*provider.Disk, error) {
+	name := "uuid-" + uuid.New()
+	dir := l.diskDir(name)
+
+	log.Printf("Creating disk %v", name)
+
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	if fromDir != "" {
+		err = copyTree(filepath.Join(fromDir, "data"), filepath.Join(dir, "data"))
+	} else {
+		err = os.Mkdir(filepath.Join(dir, "data"), 0755)
+	}
+	if err == nil {
+		err = writeMeta(dir, &diskMeta{SizeGB: sizeGB, Description: description})
+	}
+	if err != nil {
+		if rerr := os.RemoveAll(dir); rerr != nil {
+			log.Printf("cleanup failure for %v: %v", dir, rerr)
+		}
+		return nil, err
+	}
+
+	return &provider.Disk{
+		Name:        name,
+		SizeGB:      sizeGB,
+		Description: description,
+	}, nil
+}
+
+func (l *Local) GetDisk(name string) (*provider.Disk, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid disk name %#v", name)
+	}
+	meta, err := readMeta(l.diskDir(name))
+	if err != nil {
+		return nil, err
+	}
+	return &provider.Disk{
+		Name:        name,
+		SizeGB:      meta.SizeGB,
+		Description: meta.Description,
+	}, nil
+}
+
+func (l *Local) ListDisks(prefix string) ([]*provider.Disk, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(l.root, "disks"))
+	if err != nil {
+		return nil, err
+	}
+	var ret []*provider.Disk
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		disk, err := l.GetDisk(info.Name())
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Being created or deleted.
+				continue
+			}
+			return nil, err
+		}
+		ret = append(ret, disk)
+	}
+	return ret, nil
+}
+
+// ResizeDisk only records the new size; local disks aren't size-limited.
+func (l *Local) ResizeDisk(name string, sizeGB int64) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid disk name %#v", name)
+	}
+	log.Printf("Resizing disk %v to %vGB", name, sizeGB)
+	dir := l.diskDir(name)
+	meta, err := readMeta(dir)
+	if err != nil {
+		return err
+	}
+	if sizeGB < meta.SizeGB {
+		return fmt.Errorf("can't shrink disk from %vGB to %vGB", meta.SizeGB, sizeGB)
+	}
+	meta.SizeGB = sizeGB
+	return writeMeta(dir, meta)
+}
+
+func (l *Local) DeleteDisk(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid disk name %#v", name)
+	}
+	log.Printf("Deleting disk %v", name)
+	return os.RemoveAll(l.diskDir(name))
+}
+
+func (l *Local) CreateSnapshot(diskName string) (*provider.Snapshot, error) {
+	if !validName(diskName) {
+		return nil, fmt.Errorf("invalid disk name %#v", diskName)
+	}
+	name := "snap-" + uuid.New()
+	log.Printf("Creating snapshot %v of disk %v", name, diskName)
+
+	src := l.diskDir(diskName)
+	meta, err := readMeta(src)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := l.snapshotDir(name)
+	err = os.Mkdir(dst, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = copyTree(filepath.Join(src, "data"), filepath.Join(dst, "data"))
+	if err == nil {
+		err = writeMeta(dst, &diskMeta{SizeGB: meta.SizeGB, SourceDisk: diskName})
+	}
+	if err != nil {
+		if rerr := os.RemoveAll(dst); rerr != nil {
+			log.Printf("cleanup failure for %v: %v", dst, rerr)
+		}
+		return nil, err
+	}
+
+	return &provider.Snapshot{
+		Name:       name,
+		SourceDisk: diskName,
+		SizeGB:     meta.SizeGB,
+	}, nil
+}
+
+func (l *Local) DeleteSnapshot(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid snapshot name %#v", name)
+	}
+	log.Printf("Deleting snapshot %v", name)
+	return os.RemoveAll(l.snapshotDir(name))
+}
+
+// copyTree copies the directory src to dst, which must not exist.  Only
+// regular files, directories and symlinks are copied.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		}
+		log.Printf("not copying special file %v", p)
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (l *Local) VolumeSource(diskName string) kapi.VolumeSource {
+	return kapi.VolumeSource{
+		HostPath: &kapi.HostPathVolumeSource{
+			Path: filepath.Join(l.diskDir(diskName), "data"),
+		},
+	}
+}
+
+func (l *Local) VolumeDisk(vol *kapi.VolumeSource) string {
+	if vol.HostPath == nil {
+		return ""
+	}
+	dir, data := filepath.Split(vol.HostPath.Path)
+	if data != "data" {
+		return ""
+	}
+	dir, name := filepath.Split(filepath.Clean(dir))
+	if filepath.Clean(dir) != filepath.Join(l.root, "disks") {
+		return ""
+	}
+	return name
+}
+
+func (l *Local) Objects() provider.ObjectStore {
+	return l.objects
+}
@@ -0,0 +1,116 @@
+// Package provider defines the interface between Horizon Cloud and the
+// infrastructure it runs on: persistent disks for RethinkDB, and object
+// storage for deploys and backups.
+package provider
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+)
+
+// ErrObjectNotExist is returned by ObjectStore methods when there is no
+// such object.
+var ErrObjectNotExist = errors.New("object does not exist")
+
+type DiskType string
+
+var (
+	DiskTypeStandard DiskType = "standard"
+	DiskTypeSSD      DiskType = "ssd"
+)
+
+type Disk struct {
+	Name        string
+	SizeGB      int64
+	Description string
+}
+
+type Snapshot struct {
+	Name       string
+	SourceDisk string
+	SizeGB     int64
+}
+
+// An ObjectWriter writes a new object, which becomes visible when Close
+// returns successfully.
+type ObjectWriter interface {
+	io.WriteCloser
+	// CloseWithError aborts the write; the object is not created.
+	CloseWithError(err error) error
+}
+
+// A SignedRequest is an HTTP request which clients can make without any
+// credentials of their own.
+type SignedRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+}
+
+// ObjectAttrs describes a stored object.
+type ObjectAttrs struct {
+	Name        string
+	Size        int64
+	ContentType string
+	MD5         []byte
+}
+
+// An ObjectStore holds objects in flat namespaces called buckets.
+// Implementations include GCS (in package gcloud), S3 and S3-compatible
+// servers (package s3) and a plain directory (package local).
+type ObjectStore interface {
+	// List returns the objects in bucket whose names start with prefix,
+	// sorted by name.
+	List(bucket, prefix string) ([]*ObjectAttrs, error)
+	// Stat returns ErrObjectNotExist if there is no such object.
+	Stat(bucket, name string) (*ObjectAttrs, error)
+	// NewReader returns ErrObjectNotExist if there is no such object.
+	NewReader(bucket, name string) (io.ReadCloser, error)
+	NewWriter(bucket, name, contentType string) ObjectWriter
+	Copy(srcBucket, srcName, dstBucket, dstName string) error
+	Delete(bucket, name string) error
+	// SignPut returns a request that uploads an object with the given
+	// content type and MD5 sum.  The object is publicly readable once
+	// uploaded.
+	SignPut(bucket, name, contentType string, md5 []byte,
+		expires time.Time) (*SignedRequest, error)
+	// PublicURL returns the base URL for reading public objects over
+	// HTTP, as <PublicURL><bucket>/<name>.  It ends in a slash.
+	PublicURL() string
+}
+
+type Provider interface {
+	// CreateDisk creates a new, empty disk with a unique name.  The
+	// description is stored with the disk and returned by GetDisk and
+	// ListDisks.
+	CreateDisk(sizeGB int64, disktype DiskType, description string) (*Disk, error)
+	// CreateDiskFromSnapshot creates a new disk holding the contents of the
+	// given snapshot.  sizeGB must be at least the size of the snapshotted
+	// disk.
+	CreateDiskFromSnapshot(
+		snapshot string, sizeGB int64, disktype DiskType, description string) (*Disk, error)
+	GetDisk(name string) (*Disk, error)
+	// ListDisks returns all disks whose names start with prefix.
+	ListDisks(prefix string) ([]*Disk, error)
+	// ResizeDisk grows a disk to sizeGB.  The filesystem on the disk is not
+	// touched; it has to be grown separately.
+	ResizeDisk(name string, sizeGB int64) error
+	DeleteDisk(name string) error
+
+	// CreateSnapshot takes a crash-consistent snapshot of a disk, which may
+	// be in use.
+	CreateSnapshot(diskName string) (*Snapshot, error)
+	DeleteSnapshot(name string) error
+
+	// VolumeSource returns the Kubernetes volume that mounts the named
+	// disk.  VolumeDisk does the reverse, returning "" for volumes this
+	// provider didn't create.
+	VolumeSource(diskName string) kapi.VolumeSource
+	VolumeDisk(vol *kapi.VolumeSource) string
+
+	// Objects returns the provider's object storage.
+	Objects() ObjectStore
+}
@@ -0,0 +1,353 @@
+// Package s3 implements provider.ObjectStore for Amazon S3 and
+// S3-compatible servers such as MinIO.
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rethinkdb/horizon-cloud/internal/provider"
+)
+
+var _ provider.ObjectStore = &S3{}
+
+// S3 always uses path-style URLs (<endpoint>/<bucket>/<key>), since
+// that's what MinIO expects and bucket names with dots can't be used with
+// virtual-hosted URLs over HTTPS.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// New returns an S3 talking to endpoint, such as "https://s3.amazonaws.com"
+// or "http://minio:9000".  MinIO ignores the region, but it still has to
+// be part of the signature; "us-east-1" is the usual choice.
+func New(endpoint, region, accessKey, secretKey string) (*S3, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("S3 endpoint %#v must be an http or https URL", endpoint)
+	}
+	return &S3{u, region, accessKey, secretKey, &http.Client{}}, nil
+}
+
+func (s *S3) url(bucket, key string, q url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = u.Path + "/" + bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, true)
+	u.RawQuery = canonicalQuery(q)
+	return &u
+}
+
+// An Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("S3 error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func readError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err == nil {
+		xml.Unmarshal(body, e)
+	}
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return e
+}
+
+// do signs and sends a request with a body of the given size; S3 doesn't
+// accept chunked uploads.
+func (s *S3) do(method string, u *url.URL, h http.Header,
+	body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	s.sign(req, payloadHash, time.Now())
+	return s.client.Do(req)
+}
+
+// etagMD5 returns the MD5 sum in an ETag, or nil for objects uploaded in
+// multiple parts, whose ETags aren't MD5 sums.
+func etagMD5(etag string) []byte {
+	sum, err := hex.DecodeString(strings.Trim(etag, `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List doesn't return content types; S3 doesn't include them in
+// listings.
+func (s *S3) List(bucket, prefix string) ([]*provider.ObjectAttrs, error) {
+	var ret []*provider.ObjectAttrs
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u := s.url(bucket, "", q)
+		resp, err := s.do("GET", u, nil, nil, 0, emptyPayload)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := readError(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Contents {
+			ret = append(ret, &provider.ObjectAttrs{
+				Name: item.Key,
+				Size: item.Size,
+				MD5:  etagMD5(item.ETag),
+			})
+		}
+		if !result.IsTruncated {
+			return ret, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Stat(bucket, name string) (*provider.ObjectAttrs, error) {
+	resp, err := s.do("HEAD", s.url(bucket, name, nil), nil, nil, 0, emptyPayload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, provider.ErrObjectNotExist
+	default:
+		// HEAD responses have no body to read an error from.
+		return nil, &Error{StatusCode: resp.StatusCode, Code: resp.Status}
+	}
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return &provider.ObjectAttrs{
+		Name:        name,
+		Size:        size,
+		ContentType: resp.Header.Get("Content-Type"),
+		MD5:         etagMD5(resp.Header.Get("ETag")),
+	}, nil
+}
+
+func (s *S3) NewReader(bucket, name string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", s.url(bucket, name, nil), nil, nil, 0, emptyPayload)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, provider.ErrObjectNotExist
+	}
+	defer resp.Body.Close()
+	return nil, readError(resp)
+}
+
+// writer buffers the object in a temporary file, since a signed PUT needs
+// its length and hash up front.
+type writer struct {
+	s           *S3
+	bucket      string
+	name        string
+	contentType string
+	f           *os.File
+	size        int64
+	md5, sha    hash.Hash
+	err         error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	w.md5.Write(p[:n])
+	w.sha.Write(p[:n])
+	return n, err
+}
+
+func (w *writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	defer os.Remove(w.f.Name())
+	defer w.f.Close()
+
+	_, err := w.f.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	h := http.Header{}
+	if w.contentType != "" {
+		h.Set("Content-Type", w.contentType)
+	}
+	h.Set("Content-MD5", base64.StdEncoding.EncodeToString(w.md5.Sum(nil)))
+	resp, err := w.s.do("PUT", w.s.url(w.bucket, w.name, nil), h,
+		w.f, w.size, hex.EncodeToString(w.sha.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	return nil
+}
+
+func (w *writer) CloseWithError(err error) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+func (s *S3) NewWriter(bucket, name, contentType string) provider.ObjectWriter {
+	f, err := ioutil.TempFile("", "hzc-s3-")
+	if err != nil {
+		return &writer{err: err}
+	}
+	return &writer{
+		s:           s,
+		bucket:      bucket,
+		name:        name,
+		contentType: contentType,
+		f:           f,
+		md5:         md5.New(),
+		sha:         sha256.New(),
+	}
+}
+
+func (s *S3) Copy(srcBucket, srcName, dstBucket, dstName string) error {
+	h := http.Header{}
+	h.Set("X-Amz-Copy-Source", uriEncode("/"+srcBucket+"/"+srcName, true))
+	h.Set("X-Amz-Acl", "public-read")
+	resp, err := s.do("PUT", s.url(dstBucket, dstName, nil), h, nil, 0, emptyPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return provider.ErrObjectNotExist
+	}
+	// Copies can fail after the server has already sent 200 OK, in which
+	// case the body is an error instead of a CopyObjectResult.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || bytes.Contains(body, []byte("<Error>")) {
+		e := &Error{StatusCode: resp.StatusCode}
+		xml.Unmarshal(body, e)
+		return e
+	}
+	return nil
+}
+
+// Delete succeeds whether or not the object exists; S3 doesn't say.
+func (s *S3) Delete(bucket, name string) error {
+	resp, err := s.do("DELETE", s.url(bucket, name, nil), nil, nil, 0, emptyPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	return nil
+}
+
+// maxPresignExpiry is the longest expiry S3 accepts.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+func (s *S3) SignPut(bucket, name, contentType string, md5 []byte,
+	expires time.Time) (*provider.SignedRequest, error) {
+	now := time.Now()
+	expiry := expires.Sub(now)
+	if expiry <= 0 || expiry > maxPresignExpiry {
+		return nil, fmt.Errorf("can't sign a URL expiring in %v", expiry)
+	}
+
+	md5base64 := base64.StdEncoding.EncodeToString(md5)
+	u := s.url(bucket, name, nil)
+	h := http.Header{}
+	h.Set("Host", u.Host)
+	h.Set("Content-Type", contentType)
+	h.Set("Content-MD5", md5base64)
+	h.Set("X-Amz-Acl", "public-read")
+
+	return &provider.SignedRequest{
+		Method: "PUT",
+		URL:    s.presign("PUT", u, h, expiry, now).String(),
+		Headers: map[string]string{
+			"Content-Type":  contentType,
+			"Cache-Control": "private,no-cache",
+			"Content-MD5":   md5base64,
+			"x-amz-acl":     "public-read",
+		},
+	}, nil
+}
+
+func (s *S3) PublicURL() string {
+	return s.endpoint.String() + "/"
+}
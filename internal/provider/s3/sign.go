@@ -0,0 +1,163 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requests are signed with AWS Signature Version 4, which S3 and the
+// S3-compatible servers we care about (MinIO) all accept.  See
+// http://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	sigAlgorithm    = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	scopeDateFormat = "20060102"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	emptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncode escapes everything but the unreserved characters, and '/' if
+// path is true.
+func uriEncode(s string, path bool) string {
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			buf = append(buf, c)
+			continue
+		}
+		buf = append(buf, '%', "0123456789ABCDEF"[c>>4], "0123456789ABCDEF"[c&15])
+	}
+	return string(buf)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// canonicalHeaders returns the canonical header block and the list of
+// signed headers for h, which must include host.
+func canonicalHeaders(h http.Header) (string, string) {
+	names := make([]string, 0, len(h))
+	lower := make(map[string][]string, len(h))
+	for k, v := range h {
+		name := strings.ToLower(k)
+		names = append(names, name)
+		lower[name] = v
+	}
+	sort.Strings(names)
+	var buf []string
+	for _, name := range names {
+		vals := make([]string, len(lower[name]))
+		for i, v := range lower[name] {
+			vals[i] = strings.Join(strings.Fields(v), " ")
+		}
+		buf = append(buf, name+":"+strings.Join(vals, ",")+"\n")
+	}
+	return strings.Join(buf, ""), strings.Join(names, ";")
+}
+
+func (s *S3) scope(now time.Time) string {
+	return now.Format(scopeDateFormat) + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := sigAlgorithm + "\n" +
+		now.Format(amzDateFormat) + "\n" +
+		s.scope(now) + "\n" +
+		sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format(scopeDateFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// sign adds an Authorization header to req.  payloadHash is the hex
+// SHA-256 of the body, or unsignedPayload.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	h := http.Header{}
+	for k, v := range req.Header {
+		h[k] = v
+	}
+	h.Set("Host", req.URL.Host)
+	headers, signed := canonicalHeaders(h)
+
+	canonicalRequest := req.Method + "\n" +
+		req.URL.EscapedPath() + "\n" +
+		canonicalQuery(req.URL.Query()) + "\n" +
+		headers + "\n" +
+		signed + "\n" +
+		payloadHash
+
+	req.Header.Set("Authorization", sigAlgorithm+
+		" Credential="+s.accessKey+"/"+s.scope(now)+
+		", SignedHeaders="+signed+
+		", Signature="+s.signature(now, canonicalRequest))
+}
+
+// presign returns u with query parameters added that authorize a request
+// with the given method and headers (which must include host) until
+// now+expires.
+func (s *S3) presign(method string, u *url.URL, h http.Header,
+	expires time.Duration, now time.Time) *url.URL {
+	now = now.UTC()
+	headers, signed := canonicalHeaders(h)
+
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", sigAlgorithm)
+	q.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(now))
+	q.Set("X-Amz-Date", now.Format(amzDateFormat))
+	q.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	q.Set("X-Amz-SignedHeaders", signed)
+
+	canonicalRequest := method + "\n" +
+		u.EscapedPath() + "\n" +
+		canonicalQuery(q) + "\n" +
+		headers + "\n" +
+		signed + "\n" +
+		unsignedPayload
+
+	ret := *u
+	ret.RawQuery = canonicalQuery(q) + "&X-Amz-Signature=" +
+		s.signature(now, canonicalRequest)
+	return &ret
+}
@@ -1,7 +1,10 @@
 package ssh
 
 import (
+	"crypto/md5"
 	"encoding/base64"
+	"fmt"
+	"strings"
 
 	cryptoSSH "golang.org/x/crypto/ssh"
 )
@@ -14,3 +17,29 @@ func ValidKey(key string) bool {
 	_, err = cryptoSSH.ParsePublicKey(data)
 	return err == nil
 }
+
+// KeyFromAuthorizedKey parses a line in authorized_keys format, like the
+// contents of a .pub file, and returns the key in the format ValidKey
+// accepts.
+func KeyFromAuthorizedKey(line string) (string, error) {
+	pub, _, _, _, err := cryptoSSH.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub.Marshal()), nil
+}
+
+// Fingerprint returns the MD5 fingerprint of a key, as printed by
+// `ssh-keygen -l -E md5`.
+func Fingerprint(key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum(data)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":"), nil
+}
@@ -22,3 +22,38 @@ func TestValidKey(t *testing.T) {
 		}
 	}
 }
+
+const testKey = "AAAAB3NzaC1yc2EAAAADAQABAAABAQDFJYYZRAakqyzg9Fy6nuyxvJe4eNOT4AG8bfZH7EB2HcHLR6OmnhwQsE4fwx878eeFMwuQYkeU/fW3/5VgqLhTHB4Za8C4ZmwN4RvAZbidMf53+5FuwX6bTY6OZcDwsIiD1rss/+M7PcwHE0Ig8/UgBCb38amFAWPUgyELfd/+ZKDlxBRETH3Ia0+UOR/JYf8Xl6XWR+xCGgIY3AI8n6yQsusCaoKMlK2somn6NXBIJ+2DejgdCGeEj1/yu4lM2UMBwuPuoaBOJbjBNhKaQOUIK4P/50mY/cpTCLFLVxgftIc3aZgnai04DIVAe5PmfXRl7i6AbJgYHvEfqmKjCNYz"
+
+func TestKeyFromAuthorizedKey(t *testing.T) {
+	for _, line := range []string{
+		"ssh-rsa " + testKey,
+		"ssh-rsa " + testKey + " ubuntu@ip-10-0-0-175\n",
+	} {
+		key, err := KeyFromAuthorizedKey(line)
+		if err != nil {
+			t.Errorf("KeyFromAuthorizedKey(%#v) failed: %v", line, err)
+			continue
+		}
+		if key != testKey {
+			t.Errorf("KeyFromAuthorizedKey(%#v) = %#v, but wanted %#v",
+				line, key, testKey)
+		}
+	}
+
+	_, err := KeyFromAuthorizedKey(testKey)
+	if err == nil {
+		t.Errorf("KeyFromAuthorizedKey accepted a key without a type")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	const want = "73:30:58:d8:9f:39:50:6e:53:a6:bb:20:a7:fe:89:ba"
+	fp, err := Fingerprint(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp != want {
+		t.Errorf("Fingerprint = %#v, but wanted %#v", fp, want)
+	}
+}
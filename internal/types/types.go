@@ -1,11 +1,13 @@
 package types
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/rethinkdb/horizon-cloud/internal/util"
 )
@@ -23,32 +25,354 @@ type User struct {
 	PublicSSHKeys []string
 }
 
+// MaxNumRDB is the largest supported RethinkDB replica count.  Replica RCs
+// are named `rN-<kubename>`, and a second digit wouldn't fit in
+// maxKubePrefixLength.
+const MaxNumRDB = 10
+
+// MaxNumHorizon is the largest supported Horizon server count.
+const MaxNumHorizon = 20
+
 type KubeConfig struct {
 	NumRDB     int `gorethink:",omitempty"`
 	SizeRDB    int `gorethink:",omitempty"`
 	NumHorizon int `gorethink:",omitempty"`
 }
 
+// DefaultKubeConfig is the KubeConfig of newly created projects.
+var DefaultKubeConfig = KubeConfig{
+	NumRDB:     1,
+	SizeRDB:    10,
+	NumHorizon: 1,
+}
+
 func (dc *KubeConfig) Validate() error {
-	if dc.NumRDB != 1 {
-		return fmt.Errorf("NumRDB = %d, but only 1 is supported", dc.NumRDB)
+	if dc.NumRDB < 1 || dc.NumRDB > MaxNumRDB {
+		return fmt.Errorf("NumRDB = %d, but only 1 to %d are supported",
+			dc.NumRDB, MaxNumRDB)
 	}
 	if dc.SizeRDB < 10 {
 		return fmt.Errorf("SizeRDB = %d, but only >=10 is supported", dc.SizeRDB)
 	}
-	if dc.NumHorizon != 1 {
-		return fmt.Errorf("NumHorizon = %d, but only 1 is supported", dc.NumHorizon)
+	if dc.NumHorizon < 1 || dc.NumHorizon > MaxNumHorizon {
+		return fmt.Errorf("NumHorizon = %d, but only 1 to %d are supported",
+			dc.NumHorizon, MaxNumHorizon)
 	}
 	return nil
 }
 
 type HorizonConfig []byte
 
+// An EnvVar is an environment variable set in a project's Horizon servers.
+// The Value of a secret EnvVar is sealed with hzc-api's env key, and is
+// never shown to users once set.
+type EnvVar struct {
+	Value  string `gorethink:",omitempty"`
+	Secret bool   `gorethink:",omitempty"`
+}
+
+// MaxEnvVars is the most environment variables a project can set.
+const MaxEnvVars = 100
+
+// MaxEnvValueLength is the length of the longest environment variable
+// value a project can set.
+const MaxEnvValueLength = 16 * 1024
+
+// reservedEnv holds the environment variables Horizon servers need to run
+// in the cluster, which projects can't override, and those set from
+// HorizonSettings.
+var reservedEnv = map[string]bool{
+	"HZ_BIND":         true,
+	"HZ_CONNECT":      true,
+	"HZ_PORT":         true,
+	"HZ_SERVE_STATIC": true,
+
+	"HZ_PERMISSIONS":            true,
+	"HZ_ALLOW_ANONYMOUS":        true,
+	"HZ_ALLOW_UNAUTHENTICATED":  true,
+	"HZ_AUTO_CREATE_COLLECTION": true,
+	"HZ_AUTO_CREATE_INDEX":      true,
+	"HZ_TOKEN_SECRET":           true,
+}
+
+// ValidateEnvName checks that name is an environment variable name that
+// projects can set.
+func ValidateEnvName(name string, fieldName string) error {
+	err := util.ValidateEnvName(name, fieldName)
+	if err != nil {
+		return err
+	}
+	if reservedEnv[name] || strings.HasPrefix(name, "HZ_AUTH_") {
+		return fmt.Errorf("%s is set by Horizon Cloud and can't be changed "+
+			"directly (some Horizon options are project settings)", name)
+	}
+	return nil
+}
+
+// AuthProviders are the OAuth providers Horizon supports.
+var AuthProviders = []string{
+	"facebook", "github", "google", "slack", "twitch", "twitter",
+}
+
+const (
+	MaxAuthFieldLength   = 1024
+	MaxTokenSecretLength = 1024
+)
+
+// An AuthProvider lets users log in to a project's Horizon app with an
+// OAuth provider.
+type AuthProvider struct {
+	Name string
+	ID   string
+	// Secret is sealed like secret EnvVars.
+	Secret string `gorethink:",omitempty"`
+}
+
+// HorizonSettings are the options Horizon servers run with.  Projects that
+// have never set them run with DefaultHorizonSettings.
+type HorizonSettings struct {
+	Permissions          bool
+	AllowAnonymous       bool
+	AllowUnauthenticated bool
+	AutoCreateCollection bool
+	AutoCreateIndex      bool
+
+	// TokenSecret signs the tokens Horizon gives logged in users, and is
+	// sealed like secret EnvVars.  Every server of a project must use the
+	// same one.
+	TokenSecret   string         `gorethink:",omitempty"`
+	AuthRedirect  string         `gorethink:",omitempty"`
+	AuthProviders []AuthProvider `gorethink:",omitempty"`
+}
+
+// DefaultHorizonSettings match what Horizon servers ran with before
+// settings could be changed.
+var DefaultHorizonSettings = HorizonSettings{
+	Permissions:          true,
+	AllowAnonymous:       true,
+	AllowUnauthenticated: true,
+	AutoCreateCollection: false,
+	AutoCreateIndex:      true,
+}
+
+func (hs *HorizonSettings) Validate() error {
+	if len(hs.TokenSecret) > MaxTokenSecretLength {
+		return fmt.Errorf("TokenSecret is too long (%v > %v)",
+			len(hs.TokenSecret), MaxTokenSecretLength)
+	}
+	if len(hs.AuthRedirect) > MaxAuthFieldLength {
+		return fmt.Errorf("AuthRedirect is too long (%v > %v)",
+			len(hs.AuthRedirect), MaxAuthFieldLength)
+	}
+	if hs.AuthRedirect != "" && !strings.HasPrefix(hs.AuthRedirect, "/") &&
+		!strings.HasPrefix(hs.AuthRedirect, "https://") &&
+		!strings.HasPrefix(hs.AuthRedirect, "http://") {
+		return fmt.Errorf("AuthRedirect must be a path or an http(s) URL")
+	}
+	seen := make(map[string]bool)
+	for _, p := range hs.AuthProviders {
+		known := false
+		for _, name := range AuthProviders {
+			known = known || p.Name == name
+		}
+		if !known {
+			return fmt.Errorf("unknown auth provider %#v (supported: %s)",
+				p.Name, strings.Join(AuthProviders, ", "))
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("auth provider %v is listed twice", p.Name)
+		}
+		seen[p.Name] = true
+		if p.ID == "" {
+			return fmt.Errorf("auth provider %v has no ID", p.Name)
+		}
+		if len(p.ID) > MaxAuthFieldLength || len(p.Secret) > MaxAuthFieldLength {
+			return fmt.Errorf("auth provider %v has an ID or secret that is too long",
+				p.Name)
+		}
+	}
+	return nil
+}
+
+// Env returns the environment variables that make Horizon use hs.  Its
+// secrets must already be unsealed.
+func (hs *HorizonSettings) Env() map[string]string {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	env := map[string]string{
+		"HZ_PERMISSIONS":            yesNo(hs.Permissions),
+		"HZ_ALLOW_ANONYMOUS":        yesNo(hs.AllowAnonymous),
+		"HZ_ALLOW_UNAUTHENTICATED":  yesNo(hs.AllowUnauthenticated),
+		"HZ_AUTO_CREATE_COLLECTION": yesNo(hs.AutoCreateCollection),
+		"HZ_AUTO_CREATE_INDEX":      yesNo(hs.AutoCreateIndex),
+	}
+	if hs.TokenSecret != "" {
+		env["HZ_TOKEN_SECRET"] = hs.TokenSecret
+	}
+	if hs.AuthRedirect != "" {
+		env["HZ_AUTH_REDIRECT"] = hs.AuthRedirect
+	}
+	for _, p := range hs.AuthProviders {
+		prefix := "HZ_AUTH_" + strings.ToUpper(p.Name)
+		env[prefix+"_ID"] = p.ID
+		env[prefix+"_SECRET"] = p.Secret
+	}
+	return env
+}
+
+// Runtime names the versions of Horizon and RethinkDB a project runs, out
+// of those in the VersionCatalog.  Empty fields mean the default version.
+type Runtime struct {
+	Horizon   string `gorethink:",omitempty"`
+	RethinkDB string `gorethink:",omitempty"`
+}
+
+// A RuntimeVersion is a version of Horizon or RethinkDB that projects can
+// run, and the image it's in.
+type RuntimeVersion struct {
+	Name  string
+	Image string
+}
+
+// A VersionCatalog lists the versions of Horizon and RethinkDB that
+// projects can run.  The first of each is the default.
+type VersionCatalog struct {
+	Horizon   []RuntimeVersion
+	RethinkDB []RuntimeVersion
+}
+
+func (vc *VersionCatalog) Validate() error {
+	if len(vc.Horizon) == 0 || len(vc.RethinkDB) == 0 {
+		return errors.New("version catalog needs at least one version of each")
+	}
+	for _, list := range [][]RuntimeVersion{vc.Horizon, vc.RethinkDB} {
+		for _, v := range list {
+			if v.Name == "" || v.Image == "" {
+				return fmt.Errorf("version %#v needs a Name and an Image", v)
+			}
+		}
+	}
+	return nil
+}
+
+func findVersion(list []RuntimeVersion, kind string, name string) (string, error) {
+	if name == "" {
+		return list[0].Image, nil
+	}
+	for _, v := range list {
+		if v.Name == name {
+			return v.Image, nil
+		}
+	}
+	return "", fmt.Errorf("%s version %#v isn't available", kind, name)
+}
+
+// Images returns the images of the versions rt names.
+func (vc *VersionCatalog) Images(rt Runtime) (string, string, error) {
+	horizon, err := findVersion(vc.Horizon, "Horizon", rt.Horizon)
+	if err != nil {
+		return "", "", err
+	}
+	rethinkdb, err := findVersion(vc.RethinkDB, "RethinkDB", rt.RethinkDB)
+	if err != nil {
+		return "", "", err
+	}
+	return horizon, rethinkdb, nil
+}
+
+func findName(list []RuntimeVersion, kind string, image string) (string, error) {
+	if list[0].Image == image {
+		return "", nil
+	}
+	for _, v := range list {
+		if v.Image == image {
+			return v.Name, nil
+		}
+	}
+	return "", fmt.Errorf("%s image %#v isn't in the version catalog", kind, image)
+}
+
+// Runtime returns the Runtime that names the versions in the given images,
+// preferring the default version.
+func (vc *VersionCatalog) Runtime(horizon string, rethinkdb string) (Runtime, error) {
+	var rt Runtime
+	var err error
+	rt.Horizon, err = findName(vc.Horizon, "Horizon", horizon)
+	if err != nil {
+		return Runtime{}, err
+	}
+	rt.RethinkDB, err = findName(vc.RethinkDB, "RethinkDB", rethinkdb)
+	if err != nil {
+		return Runtime{}, err
+	}
+	return rt, nil
+}
+
+// A ConfigVersion tracks the application of one part of a project's
+// config.  Desired is bumped whenever the config changes, and is copied to
+// Applied once applied.  A failed attempt to apply Desired is retried
+// with backoff, and after too many failures (or one that retrying can't
+// fix) Desired is copied to Error and left alone until an operator
+// requeues it or the config changes again.
 type ConfigVersion struct {
 	Desired   int64  `gorethink:",omitempty"`
 	Applied   int64  `gorethink:",omitempty"`
 	Error     int64  `gorethink:",omitempty"`
 	LastError string `gorethink:",omitempty"`
+
+	// Attempts is the number of failed attempts to apply Attempted, which
+	// is retried at NextAttempt.  They mean nothing once Desired has moved
+	// on from Attempted.
+	Attempted   int64     `gorethink:",omitempty"`
+	Attempts    int       `gorethink:",omitempty"`
+	LastAttempt time.Time `gorethink:",omitempty"`
+	NextAttempt time.Time `gorethink:",omitempty"`
+}
+
+// A RetryPolicy says how failures to apply a ConfigVersion are retried.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a version is tried before it is
+	// given up on.
+	MaxAttempts int
+	// Backoff is the wait after the first failure, which doubles with
+	// each failure after that up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable reports whether err might go away if the version is tried
+	// again.  If nil, every error is retried.
+	Retryable func(err error) bool
+}
+
+func (rp *RetryPolicy) backoff(attempts int) time.Duration {
+	d := rp.Backoff
+	for i := 1; i < attempts && d < rp.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	return d
+}
+
+// Pending reports whether Desired is still to be applied, now or on a
+// later retry.
+func (cv *ConfigVersion) Pending() bool {
+	return cv.Desired != cv.Applied && cv.Desired != cv.Error
+}
+
+// Failed reports whether Desired was given up on.
+func (cv *ConfigVersion) Failed() bool {
+	return cv.Desired != cv.Applied && cv.Desired == cv.Error
+}
+
+// Retrying reports whether Desired has failed and is waiting to be tried
+// again.
+func (cv *ConfigVersion) Retrying() bool {
+	return cv.Pending() && cv.Attempted == cv.Desired && cv.Attempts > 0
 }
 
 func (cv *ConfigVersion) Success() ConfigVersion {
@@ -56,23 +380,120 @@ func (cv *ConfigVersion) Success() ConfigVersion {
 	cv2.Applied = cv.Desired
 	return cv2
 }
-func (cv *ConfigVersion) Failure(err error) ConfigVersion {
+
+// Failure records a failed attempt to apply Desired at now, scheduling
+// another one if rp allows it and giving up on Desired otherwise.
+func (cv *ConfigVersion) Failure(
+	now time.Time, rp RetryPolicy, err error) ConfigVersion {
 	cv2 := *cv
-	cv2.Error = cv.Desired
+	if cv.Attempted != cv.Desired {
+		cv2.Attempted = cv.Desired
+		cv2.Attempts = 0
+	}
+	cv2.Attempts++
+	cv2.LastAttempt = now
 	cv2.LastError = err.Error()
+	if cv2.Attempts >= rp.MaxAttempts ||
+		(rp.Retryable != nil && !rp.Retryable(err)) {
+		cv2.Error = cv.Desired
+		cv2.NextAttempt = time.Time{}
+	} else {
+		cv2.NextAttempt = now.Add(rp.backoff(cv2.Attempts))
+	}
 	return cv2
 }
-func (cv *ConfigVersion) MaybeConfigure(f func() error) ConfigVersion {
-	if cv.Desired == cv.Applied || cv.Desired == cv.Error {
+
+// A RevertedError is returned by a function applying a ConfigVersion when
+// the change failed and was undone, and the project's config was changed
+// back to match.  The version then counts as applied, with Err recorded as
+// LastError.
+type RevertedError struct {
+	Err error
+}
+
+func (e *RevertedError) Error() string {
+	return e.Err.Error()
+}
+
+// MaybeConfigure calls f to apply Desired if it's pending and not waiting
+// for a retry at now, and returns the result.
+func (cv *ConfigVersion) MaybeConfigure(
+	now time.Time, rp RetryPolicy, f func() error) ConfigVersion {
+	if !cv.Pending() || (cv.Retrying() && now.Before(cv.NextAttempt)) {
 		return *cv
 	}
 	err := f()
+	if rerr, ok := err.(*RevertedError); ok {
+		cv2 := cv.Success()
+		cv2.LastAttempt = time.Now()
+		cv2.LastError = rerr.Error()
+		return cv2
+	}
 	if err != nil {
-		return cv.Failure(err)
+		// Back off from when f gave up, however long it took.
+		return cv.Failure(time.Now(), rp, err)
 	}
 	return cv.Success()
 }
 
+type BackupMode string
+
+const (
+	// BackupSnapshot backups are GCE snapshots of the disk behind the
+	// project's first RethinkDB replica.
+	BackupSnapshot BackupMode = "snapshot"
+	// BackupDump backups are `rethinkdb dump` archives stored in the
+	// storage bucket.
+	BackupDump BackupMode = "dump"
+)
+
+const MaxBackupRetain = 100
+
+// BackupConfig describes when a project is backed up.  Backups are disabled
+// when Mode is empty.
+type BackupConfig struct {
+	Mode          BackupMode `gorethink:",omitempty"`
+	IntervalHours int        `gorethink:",omitempty"`
+	Retain        int        `gorethink:",omitempty"`
+}
+
+func (bc *BackupConfig) Enabled() bool {
+	return bc.Mode != ""
+}
+
+func (bc *BackupConfig) Interval() time.Duration {
+	return time.Duration(bc.IntervalHours) * time.Hour
+}
+
+func (bc *BackupConfig) Validate() error {
+	switch bc.Mode {
+	case "":
+		return nil
+	case BackupSnapshot, BackupDump:
+	default:
+		return fmt.Errorf("Mode = %#v, but only %#v and %#v are supported",
+			bc.Mode, BackupSnapshot, BackupDump)
+	}
+	if bc.IntervalHours < 1 {
+		return fmt.Errorf("IntervalHours = %d, but must be at least 1", bc.IntervalHours)
+	}
+	if bc.Retain < 1 || bc.Retain > MaxBackupRetain {
+		return fmt.Errorf("Retain = %d, but only 1 to %d are supported",
+			bc.Retain, MaxBackupRetain)
+	}
+	return nil
+}
+
+type Backup struct {
+	ID        string `gorethink:"id,omitempty"`
+	ProjectID ProjectID
+	Mode      BackupMode
+	// Location is the snapshot name for BackupSnapshot backups and the
+	// object name in the storage bucket for BackupDump backups.
+	Location string
+	Created  time.Time
+}
+
 type ProjectID [2]string
 
 func NewProjectID(userName string, projectName string) ProjectID {
@@ -98,40 +519,110 @@ const (
 	kubeNameLength = maxKubeObjectLength - maxKubePrefixLength
 )
 
+// KubeName returns the Kube name of a project that doesn't have one
+// stored.  Use Project.KubeName instead where possible.
 func (p *ProjectID) KubeName() string {
 	compositeName := p.Owner() + "/" + p.Name()
 	rawHash := sha256.Sum256([]byte(compositeName))
 	return hex.EncodeToString(rawHash[:])[0:kubeNameLength]
 }
 
+// NewKubeName returns a random Kube name for a new project.  Unlike names
+// derived from the ProjectID, it can't collide with a project that was
+// renamed away from the same ID.
+func NewKubeName() string {
+	var buf [kubeNameLength/2 + 1]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])[0:kubeNameLength]
+}
+
 type ProjectAddr struct {
-	Owner     string
-	Name      string
-	HTTPAddr  string
-	GCSPrefix string
+	Owner    string
+	Name     string
+	HTTPAddr string
+	// Static files are served from StorageURL + GCSPrefix + path.  An
+	// empty StorageURL means GCS.  If ReleaseID is set, path is instead
+	// the BlobName the release's manifest maps the request path to.
+	StorageURL string `json:",omitempty"`
+	GCSPrefix  string
+	ReleaseID  string `json:",omitempty"`
 }
 
-func (p *ProjectID) Addr(bucketName string) ProjectAddr {
-	kubeName := p.KubeName()
-	return ProjectAddr{
-		Owner:     p.Owner(),
-		Name:      p.Name(),
-		HTTPAddr:  "h-" + kubeName + ":8181",
-		GCSPrefix: bucketName + "/deploy/" + kubeName + "/active/",
-	}
+// BlobName returns the name in the storage bucket of the blob holding a
+// file with the given MD5 sum.  Blobs are shared between all projects and
+// releases; the store checks the MD5 on upload, so a blob's contents
+// always match its name.
+func BlobName(md5 []byte) string {
+	return "blobs/" + hex.EncodeToString(md5)
+}
+
+// A ReleaseFile is one entry in a release's manifest.  Blobs are shared
+// by files with different content types, so the content type comes from
+// here rather than from the stored object.
+type ReleaseFile struct {
+	MD5         []byte
+	ContentType string
+}
+
+// A Release is one deploy of a project's static files.  Releases are
+// never modified once created; deploying or rolling back changes which
+// one the project's ActiveRelease points to.
+type Release struct {
+	ID        string `gorethink:"id,omitempty"`
+	ProjectID ProjectID
+	Created   time.Time
+	// Files maps paths to the blobs served for them.  It is left out when
+	// listing releases.
+	Files map[string]ReleaseFile `gorethink:",omitempty" json:",omitempty"`
 }
 
 type Project struct {
 	ID    ProjectID `gorethink:"id,omitempty"`
 	Users []string  `gorethink:",omitempty"`
 
+	// Kube is the name the project's Kube objects and disks are labeled
+	// with.  It stays the same when the project is renamed or transferred.
+	// Projects created before it existed use ID.KubeName().
+	Kube string `gorethink:",omitempty"`
+
 	Deleting bool `gorethink:",omitempty"`
+	// MovedTo is set on the old row of a project being renamed or
+	// transferred, until the row is removed.  The project lives on under
+	// the new ID, so its cluster must not be torn down.
+	MovedTo *ProjectID `gorethink:",omitempty"`
 
 	KubeConfig        KubeConfig    `gorethink:",omitempty"`
 	KubeConfigVersion ConfigVersion `gorethink:",omitempty"`
 
 	HorizonConfig        HorizonConfig `gorethink:",omitempty"`
 	HorizonConfigVersion ConfigVersion `gorethink:",omitempty"`
+
+	// Env is applied along with KubeConfig, so changing it bumps
+	// KubeConfigVersion.  It's left out of API responses because secret
+	// values must not leave the server; use ListEnv instead.
+	Env map[string]EnvVar `gorethink:",omitempty" json:"-"`
+
+	Runtime        Runtime       `gorethink:",omitempty"`
+	RuntimeVersion ConfigVersion `gorethink:",omitempty"`
+
+	// HorizonSettings is nil for projects that have never set it.  It's
+	// left out of API responses for the same reason as Env.
+	HorizonSettings        *HorizonSettings `gorethink:",omitempty" json:"-"`
+	HorizonSettingsVersion ConfigVersion    `gorethink:",omitempty"`
+
+	BackupConfig BackupConfig `gorethink:",omitempty"`
+
+	// RestoreBackupID is the backup that RestoreVersion.Desired refers to.
+	RestoreBackupID string        `gorethink:",omitempty"`
+	RestoreVersion  ConfigVersion `gorethink:",omitempty"`
+
+	// ActiveRelease is the ID of the Release being served.  Projects last
+	// deployed before releases existed don't have one; their files are
+	// served from deploy/<kubename>/active/.
+	ActiveRelease string `gorethink:",omitempty"`
 }
 
 func (p *Project) Owner() string {
@@ -147,22 +638,268 @@ func (p *Project) SlashName() string {
 }
 
 func (p *Project) KubeName() string {
+	if p.Kube != "" {
+		return p.Kube
+	}
 	return p.ID.KubeName()
 }
 
-func (p *Project) Addr(bucketName string) ProjectAddr {
-	return p.ID.Addr(bucketName)
+func (p *Project) Addr(storageURL, bucketName string) ProjectAddr {
+	kubeName := p.KubeName()
+	addr := ProjectAddr{
+		Owner:      p.Owner(),
+		Name:       p.Name(),
+		HTTPAddr:   "h-" + kubeName + ":8181",
+		StorageURL: storageURL,
+		GCSPrefix:  bucketName + "/deploy/" + kubeName + "/active/",
+	}
+	if p.ActiveRelease != "" {
+		addr.GCSPrefix = bucketName + "/"
+		addr.ReleaseID = p.ActiveRelease
+	}
+	return addr
+}
+
+// ConfigVersionNames are the names of a Project's ConfigVersions, in the
+// order the sync loop applies them.
+var ConfigVersionNames = []string{
+	"KubeConfigVersion",
+	"RuntimeVersion",
+	"HorizonSettingsVersion",
+	"HorizonConfigVersion",
+	"RestoreVersion",
+}
+
+// ConfigVersion returns the named ConfigVersion of p, or nil if there's
+// no such version.
+func (p *Project) ConfigVersion(name string) *ConfigVersion {
+	switch name {
+	case "KubeConfigVersion":
+		return &p.KubeConfigVersion
+	case "RuntimeVersion":
+		return &p.RuntimeVersion
+	case "HorizonSettingsVersion":
+		return &p.HorizonSettingsVersion
+	case "HorizonConfigVersion":
+		return &p.HorizonConfigVersion
+	case "RestoreVersion":
+		return &p.RestoreVersion
+	}
+	return nil
 }
 
 func (p *Project) HasBeenDeployedTo() bool {
 	return p.HorizonConfigVersion.Desired > 0
 }
 
+// A Drift is a difference between one of a project's Kube objects and
+// what the project's config says it should be.
+type Drift struct {
+	// Object is the kind and name of the object, like "rc/r0-<kubename>".
+	Object  string
+	Problem string
+}
+
+func (d Drift) String() string {
+	return d.Object + ": " + d.Problem
+}
+
+// A DriftStatus is the result of the last time a project's Kube objects
+// were compared with its config.
+type DriftStatus struct {
+	Kube      string `gorethink:"id"`
+	ProjectID ProjectID
+	Checked   time.Time
+	// Drift is empty if the objects matched.
+	Drift []Drift `gorethink:",omitempty"`
+	// Error is set if the objects couldn't be compared.
+	Error string `gorethink:",omitempty"`
+	// Corrected is set when Drift was found and the objects were put back
+	// to match the config, in which case Drift is what was found before.
+	// CorrectError is set if that failed.
+	Corrected    bool   `gorethink:",omitempty"`
+	CorrectError string `gorethink:",omitempty"`
+}
+
+// A ComponentStatus is the live state of one part of a project in Kube,
+// like one RethinkDB replica or the Horizon servers.
+type ComponentStatus struct {
+	// Object is the kind and name of the object running the pods, like
+	// "rc/r0-<kubename>".
+	Object string
+	// Replicas is the number of pods the object wants.
+	Replicas int
+	Pods     []PodStatus
+	// Disk is set for RethinkDB replicas.
+	Disk *DiskStatus `json:",omitempty"`
+	// Error is set if the component is missing or couldn't be read.
+	Error string `json:",omitempty"`
+}
+
+type PodStatus struct {
+	Name  string
+	Phase string
+	Ready bool
+	// Waiting is why a container isn't running yet, like
+	// "ImagePullBackOff" or "CrashLoopBackOff".
+	Waiting     string `json:",omitempty"`
+	Terminating bool   `json:",omitempty"`
+	Restarts    int
+	// Events are the pod's most recent events, oldest first.
+	Events []Event `json:",omitempty"`
+}
+
+type Event struct {
+	Time    time.Time
+	Reason  string
+	Message string
+	Count   int
+}
+
+type DiskStatus struct {
+	Name   string
+	SizeGB int64
+	// UsedBytes and AvailableBytes are read from the filesystem, and are
+	// zero if no pod was running to read them.
+	UsedBytes      int64
+	AvailableBytes int64
+}
+
+// Operation and step states.
+const (
+	OperationRunning    = "running"
+	OperationDone       = "done"
+	OperationFailed     = "failed"
+	OperationRolledBack = "rolled back"
+)
+
+// An Operation is a change to a project's Kube objects, like EnsureProject,
+// that creates resources in several steps.  It's recorded as each step
+// starts and finishes, so that an operation cut short by hzc-api dying can
+// be rolled back when it restarts.
+type Operation struct {
+	ID   string `gorethink:"id"`
+	Kube string
+	Name string
+	// State is OperationRunning, OperationDone or OperationRolledBack, or
+	// OperationFailed if it was cut short but its resources were kept.
+	State    string
+	Started  time.Time
+	Finished time.Time `gorethink:",omitempty"`
+	// Error is why the operation was rolled back or failed.
+	Error string `gorethink:",omitempty"`
+	Steps []OperationStep
+}
+
+// An OperationStep is the creation of one resource.
+type OperationStep struct {
+	// Kind is "disk", "service", "job", "rc" or "deployment".
+	Kind string
+	// Name is the resource's name.  It's empty for a disk until the disk
+	// has been created.
+	Name string
+	// State is any of the operation states.  A step is rolled back once
+	// the resource it created has been deleted again.
+	State    string
+	Started  time.Time
+	Finished time.Time `gorethink:",omitempty"`
+	Error    string    `gorethink:",omitempty"`
+}
+
+func (s OperationStep) String() string {
+	name := s.Name
+	if name == "" {
+		name = "?"
+	}
+	return s.Kind + "/" + name
+}
+
 type Domain struct {
 	Domain    string `gorethink:"id"`
 	ProjectID ProjectID
 }
 
+// A DomainClaim is a request to serve a project on a domain, which takes
+// effect once the project's owner proves they control the domain by
+// publishing the claim's ID in a TXT record or at a well-known URL.
+type DomainClaim struct {
+	ID        string `gorethink:"id,omitempty"`
+	Domain    string
+	ProjectID ProjectID
+	Created   time.Time
+}
+
+// TXTRecord returns the name of the TXT record that must contain c.ID.
+func (c *DomainClaim) TXTRecord() string {
+	return "_hzc-challenge." + c.Domain
+}
+
+// WellKnownURL returns the URL that must respond with c.ID.
+func (c *DomainClaim) WellKnownURL() string {
+	return "http://" + c.Domain + "/.well-known/hzc-challenge/" + c.ID
+}
+
+// A Certificate is a TLS certificate for a Domain, obtained from an ACME
+// certificate authority by hzc-http.  A Certificate with no CertPEM is
+// just a lock, held while its first certificate is being obtained.
+type Certificate struct {
+	Domain string `gorethink:"id"`
+	// CertPEM holds the certificate followed by the rest of its chain.
+	CertPEM  []byte `gorethink:",omitempty" json:",omitempty"`
+	KeyPEM   []byte `gorethink:",omitempty" json:",omitempty"`
+	NotAfter time.Time
+
+	// The hzc-http server renewing the certificate, if any, holds it until
+	// LockedUntil so that others don't also try.
+	LockedBy    string `gorethink:",omitempty" json:",omitempty"`
+	LockedUntil time.Time
+}
+
+// An ACMEChallenge is the response to an ACME HTTP-01 challenge, which
+// the certificate authority fetches from
+// http://<domain>/.well-known/acme-challenge/<Token>.
+type ACMEChallenge struct {
+	Token   string `gorethink:"id"`
+	KeyAuth string
+	Created time.Time
+}
+
+// An ACMEAccount is the account hzc-http servers share with an ACME
+// certificate authority.
+type ACMEAccount struct {
+	DirectoryURL string `gorethink:"id"`
+	KeyPEM       []byte
+}
+
+// A Lease lets one process at a time do something, like run hzc-api's
+// sync loop.  The holder has to keep renewing it; once Expires passes,
+// anyone can take it.
+type Lease struct {
+	Name    string `gorethink:"id"`
+	Holder  string
+	Expires time.Time
+}
+
+// An AuditRecord records a change to who can access what.
+type AuditRecord struct {
+	ID   string `gorethink:"id,omitempty"`
+	Time time.Time
+	// Actors are the users of the token that made the change.
+	Actors []string
+	Action string
+	// ProjectID is set for changes to a project.
+	ProjectID *ProjectID `gorethink:",omitempty"`
+	// User is the account the change was made to, or that was added to or
+	// removed from the project.
+	User string `gorethink:",omitempty"`
+	// PublicKey is set for changes to SSH keys.
+	PublicKey string `gorethink:",omitempty"`
+	// Domain is set for changes to a project's domains.
+	Domain string `gorethink:",omitempty"`
+	// Env is the name of the environment variable set or unset.
+	Env string `gorethink:",omitempty"`
+}
+
 type ClusterStartBool bool
 
 const AllowClusterStart ClusterStartBool = ClusterStartBool(true)
@@ -182,7 +919,7 @@ func (d *FileDescription) Validate() error {
 		return fmt.Errorf("Path %#v is not safe", d.Path)
 	}
 	if strings.HasPrefix(d.Path, ".well-known") {
-		return fmt.Errorf("Path %#v is in .well-known, which is not supported")
+		return fmt.Errorf("Path %#v is in .well-known, which is not supported", d.Path)
 	}
 	if len(d.MD5) != 16 {
 		return fmt.Errorf("MD5 must be exactly 16 bytes long (after base64 decoding)")
@@ -0,0 +1,237 @@
+package types
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errPermanent = errors.New("permanent")
+
+var testPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     time.Minute,
+	MaxBackoff:  5 * time.Minute,
+	Retryable: func(err error) bool {
+		return err != errPermanent
+	},
+}
+
+func TestBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute},
+		{10, 5 * time.Minute},
+	} {
+		if got := testPolicy.backoff(tc.attempts); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestFailure(t *testing.T) {
+	now := time.Date(2016, 7, 1, 12, 0, 0, 0, time.UTC)
+	failing := errors.New("failing")
+	for _, tc := range []struct {
+		name     string
+		cv       ConfigVersion
+		err      error
+		attempts int
+		gaveUp   bool
+		next     time.Duration
+	}{
+		{
+			name:     "first failure",
+			cv:       ConfigVersion{Desired: 2, Applied: 1},
+			err:      failing,
+			attempts: 1,
+			next:     time.Minute,
+		},
+		{
+			name: "backoff doubles",
+			cv: ConfigVersion{Desired: 2, Applied: 1,
+				Attempted: 2, Attempts: 2},
+			err:      failing,
+			attempts: 3,
+			next:     4 * time.Minute,
+		},
+		{
+			name: "backoff capped",
+			cv: ConfigVersion{Desired: 2, Applied: 1,
+				Attempted: 2, Attempts: 3},
+			err:      failing,
+			attempts: 4,
+			next:     5 * time.Minute,
+		},
+		{
+			name: "gives up at MaxAttempts",
+			cv: ConfigVersion{Desired: 2, Applied: 1,
+				Attempted: 2, Attempts: 4},
+			err:      failing,
+			attempts: 5,
+			gaveUp:   true,
+		},
+		{
+			name:     "not retryable",
+			cv:       ConfigVersion{Desired: 2, Applied: 1},
+			err:      errPermanent,
+			attempts: 1,
+			gaveUp:   true,
+		},
+		{
+			name: "attempts reset when Desired moves on",
+			cv: ConfigVersion{Desired: 3, Applied: 1,
+				Attempted: 2, Attempts: 4},
+			err:      failing,
+			attempts: 1,
+			next:     time.Minute,
+		},
+	} {
+		cv := tc.cv.Failure(now, testPolicy, tc.err)
+		if cv.Attempted != tc.cv.Desired || cv.Attempts != tc.attempts {
+			t.Errorf("%s: attempt %d of version %d, want %d of %d",
+				tc.name, cv.Attempts, cv.Attempted, tc.attempts, tc.cv.Desired)
+		}
+		if cv.LastError != tc.err.Error() || !cv.LastAttempt.Equal(now) {
+			t.Errorf("%s: last attempt recorded as %v at %v",
+				tc.name, cv.LastError, cv.LastAttempt)
+		}
+		if tc.gaveUp {
+			if !cv.Failed() || cv.Retrying() || !cv.NextAttempt.IsZero() {
+				t.Errorf("%s: didn't give up: %#v", tc.name, cv)
+			}
+			continue
+		}
+		if cv.Failed() || !cv.Retrying() {
+			t.Errorf("%s: not retrying: %#v", tc.name, cv)
+		}
+		if want := now.Add(tc.next); !cv.NextAttempt.Equal(want) {
+			t.Errorf("%s: next attempt at %v, want %v",
+				tc.name, cv.NextAttempt, want)
+		}
+	}
+}
+
+func TestMaybeConfigure(t *testing.T) {
+	now := time.Now()
+	retrying := ConfigVersion{Desired: 2, Applied: 1,
+		Attempted: 2, Attempts: 1, NextAttempt: now.Add(time.Minute)}
+	for _, tc := range []struct {
+		name string
+		cv   ConfigVersion
+		at   time.Time
+		err  error
+		ran  bool
+		want func(ConfigVersion) bool
+	}{
+		{
+			name: "applied",
+			cv:   ConfigVersion{Desired: 2, Applied: 2},
+			at:   now,
+			want: func(cv ConfigVersion) bool { return cv.Applied == 2 },
+		},
+		{
+			name: "given up",
+			cv:   ConfigVersion{Desired: 2, Applied: 1, Error: 2},
+			at:   now,
+			want: func(cv ConfigVersion) bool { return cv.Failed() },
+		},
+		{
+			name: "before NextAttempt",
+			cv:   retrying,
+			at:   now,
+			want: func(cv ConfigVersion) bool { return cv.Attempts == 1 },
+		},
+		{
+			name: "after NextAttempt",
+			cv:   retrying,
+			at:   now.Add(2 * time.Minute),
+			ran:  true,
+			want: func(cv ConfigVersion) bool { return cv.Applied == 2 },
+		},
+		{
+			name: "fails again",
+			cv:   retrying,
+			at:   now.Add(2 * time.Minute),
+			err:  errors.New("failing"),
+			ran:  true,
+			want: func(cv ConfigVersion) bool {
+				return cv.Attempts == 2 && cv.Retrying() &&
+					!cv.NextAttempt.Before(now.Add(2*time.Minute))
+			},
+		},
+		{
+			name: "reverted",
+			cv:   retrying,
+			at:   now.Add(2 * time.Minute),
+			err:  &RevertedError{errors.New("reverted")},
+			ran:  true,
+			want: func(cv ConfigVersion) bool {
+				return cv.Applied == 2 && !cv.Failed() && cv.LastError == "reverted"
+			},
+		},
+		{
+			name: "Desired moved on while retrying",
+			cv: ConfigVersion{Desired: 3, Applied: 1,
+				Attempted: 2, Attempts: 1, NextAttempt: now.Add(time.Minute)},
+			at:   now,
+			ran:  true,
+			want: func(cv ConfigVersion) bool { return cv.Applied == 3 },
+		},
+	} {
+		ran := false
+		cv := tc.cv.MaybeConfigure(tc.at, testPolicy, func() error {
+			ran = true
+			return tc.err
+		})
+		if ran != tc.ran {
+			t.Errorf("%s: ran = %v, want %v", tc.name, ran, tc.ran)
+		}
+		if !tc.want(cv) {
+			t.Errorf("%s: got %#v", tc.name, cv)
+		}
+	}
+}
+
+func TestVersionCatalogRuntime(t *testing.T) {
+	vc := VersionCatalog{
+		Horizon: []RuntimeVersion{
+			{Name: "2.0", Image: "horizon:2.0"},
+			{Name: "1.0", Image: "horizon:1.0"},
+		},
+		RethinkDB: []RuntimeVersion{
+			{Name: "2.3", Image: "rethinkdb:2.3"},
+			{Name: "2.2", Image: "rethinkdb:2.2"},
+			{Name: "2.2-again", Image: "rethinkdb:2.2"},
+		},
+	}
+	for _, tc := range []struct {
+		horizon, rethinkdb string
+		want               Runtime
+		err                bool
+	}{
+		{"horizon:2.0", "rethinkdb:2.3", Runtime{}, false},
+		{"horizon:1.0", "rethinkdb:2.2", Runtime{"1.0", "2.2"}, false},
+		{"horizon:1.0", "rethinkdb:2.3", Runtime{Horizon: "1.0"}, false},
+		{"horizon:0.9", "rethinkdb:2.3", Runtime{}, true},
+		{"horizon:2.0", "rethinkdb:2.1", Runtime{}, true},
+	} {
+		rt, err := vc.Runtime(tc.horizon, tc.rethinkdb)
+		if (err != nil) != tc.err || rt != tc.want {
+			t.Errorf("Runtime(%v, %v) = %#v, %v", tc.horizon, tc.rethinkdb, rt, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		horizon, rethinkdb, err := vc.Images(rt)
+		if err != nil || horizon != tc.horizon || rethinkdb != tc.rethinkdb {
+			t.Errorf("Images(%#v) = %v, %v, %v", rt, horizon, rethinkdb, err)
+		}
+	}
+}
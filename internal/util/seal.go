@@ -0,0 +1,60 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// A Sealer encrypts and authenticates short secrets, such as project
+// environment variables, for storage in the database.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer returns a Sealer whose key is derived from secret, which
+// should be at least 16 random bytes.
+func NewSealer(secret []byte) (*Sealer, error) {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead}, nil
+}
+
+// Seal returns plaintext encrypted with a random nonce, in base64.
+func (s *Sealer) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a string returned by Seal.  It fails if the string was
+// sealed with a different key or has been tampered with.
+func (s *Sealer) Open(sealed string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(buf) < s.aead.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := buf[:s.aead.NonceSize()], buf[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,43 @@
+package util
+
+import "testing"
+
+func TestSealer(t *testing.T) {
+	s, err := NewSealer([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := s.Seal("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed == "hunter2" {
+		t.Fatalf("Seal returned the plaintext")
+	}
+	again, err := s.Seal("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Errorf("Seal returned the same value twice: %v", sealed)
+	}
+
+	plain, err := s.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "hunter2" {
+		t.Errorf("Open(Seal(%#v)) = %#v", "hunter2", plain)
+	}
+
+	other, err := NewSealer([]byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(sealed); err == nil {
+		t.Errorf("Open with the wrong key succeeded")
+	}
+	if _, err := s.Open(sealed[:len(sealed)-4] + "AAAA"); err == nil {
+		t.Errorf("Open of a tampered value succeeded")
+	}
+}
@@ -17,12 +17,66 @@ func ValidateDomainName(domain string, fieldName string) error {
 	return nil
 }
 
+// ValidateHostname checks that host is a fully qualified, lowercase DNS
+// name without a trailing dot, like "www.example.com".
+func ValidateHostname(host string, fieldName string) error {
+	if host == "" {
+		return fmt.Errorf("field `%s` empty", fieldName)
+	}
+	if len(host) > 253 {
+		return fmt.Errorf("field `%s` too long (%v > %v)", fieldName, len(host), 253)
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("field `%s` must be a fully qualified domain name", fieldName)
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("field `%s` has an empty or too long label", fieldName)
+		}
+		for i := 0; i < len(label); i++ {
+			ch := label[i]
+			if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-') {
+				return fmt.Errorf("field `%s` may only contain lowercase letters, "+
+					"digits, dashes and dots", fieldName)
+			}
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("field `%s` has a label starting or ending with a dash",
+				fieldName)
+		}
+	}
+	return nil
+}
+
+// MaxProjectNameLength is the length of the longest project name, which
+// keeps names usable as DNS labels.
+const MaxProjectNameLength = 63
+
+// ValidateProjectName checks that name is a valid name for a new project:
+// lowercase letters, digits and dashes, starting with a letter and not
+// ending with a dash.  Projects created by hand before these rules existed
+// may not follow them.
 func ValidateProjectName(name string, fieldName string) error {
 	if name == "" {
 		return fmt.Errorf("field `%s` empty", fieldName)
 	}
-	if len(name) > 1024 {
-		return fmt.Errorf("field `%s` too long (%v > %v)", fieldName, len(name), 1024)
+	if len(name) > MaxProjectNameLength {
+		return fmt.Errorf("field `%s` too long (%v > %v)",
+			fieldName, len(name), MaxProjectNameLength)
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-') {
+			return fmt.Errorf("field `%s` may only contain lowercase letters, "+
+				"digits and dashes", fieldName)
+		}
+	}
+	if name[0] < 'a' || name[0] > 'z' {
+		return fmt.Errorf("field `%s` must start with a letter", fieldName)
+	}
+	if name[len(name)-1] == '-' {
+		return fmt.Errorf("field `%s` must not end with a dash", fieldName)
 	}
 	return nil
 }
@@ -42,6 +96,31 @@ func ValidateUserName(name string) error {
 	return nil
 }
 
+// MaxEnvNameLength is the length of the longest environment variable
+// name a project can set.
+const MaxEnvNameLength = 128
+
+// ValidateEnvName checks that name is a valid environment variable name:
+// letters, digits and underscores, not starting with a digit.
+func ValidateEnvName(name string, fieldName string) error {
+	if name == "" {
+		return fmt.Errorf("field `%s` empty", fieldName)
+	}
+	if len(name) > MaxEnvNameLength {
+		return fmt.Errorf("field `%s` too long (%v > %v)",
+			fieldName, len(name), MaxEnvNameLength)
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' ||
+			i > 0 && ch >= '0' && ch <= '9') {
+			return fmt.Errorf("field `%s` may only contain letters, digits and "+
+				"underscores, and may not start with a digit", fieldName)
+		}
+	}
+	return nil
+}
+
 // ReasonableToken returns true if the token could possibly be a JWT token.
 func ReasonableToken(token string) bool {
 	dots := 0
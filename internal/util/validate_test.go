@@ -0,0 +1,100 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateProjectName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"a", true},
+		{"my-app", true},
+		{"app2", true},
+		{"a-b-c-1", true},
+		{strings.Repeat("a", MaxProjectNameLength), true},
+
+		{"", false},
+		{strings.Repeat("a", MaxProjectNameLength+1), false},
+		{"MyApp", false},
+		{"2app", false},
+		{"-app", false},
+		{"app-", false},
+		{"my_app", false},
+		{"my.app", false},
+		{"owner/app", false},
+		{"café", false},
+	}
+	for _, test := range tests {
+		err := ValidateProjectName(test.name, "Name")
+		if test.ok && err != nil {
+			t.Errorf("ValidateProjectName(%#v) = %v, wanted no error", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ValidateProjectName(%#v) succeeded, wanted an error", test.name)
+		}
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"my-app.example.co.uk", true},
+		{"1.example.com", true},
+
+		{"", false},
+		{"localhost", false},
+		{"example.com.", false},
+		{".example.com", false},
+		{"www..example.com", false},
+		{"Example.com", false},
+		{"-www.example.com", false},
+		{"www-.example.com", false},
+		{"example.com:80", false},
+		{"ex_ample.com", false},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a.", 127) + "com", false},
+	}
+	for _, test := range tests {
+		err := ValidateHostname(test.host, "Domain")
+		if test.ok && err != nil {
+			t.Errorf("ValidateHostname(%#v) = %v, wanted no error", test.host, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ValidateHostname(%#v) succeeded, wanted an error", test.host)
+		}
+	}
+}
+
+func TestValidateEnvName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"HZ_DEBUG", true},
+		{"_private", true},
+		{"a1", true},
+
+		{"", false},
+		{"1A", false},
+		{"A-B", false},
+		{"A B", false},
+		{"A=B", false},
+		{strings.Repeat("A", MaxEnvNameLength+1), false},
+	}
+	for _, test := range tests {
+		err := ValidateEnvName(test.name, "Name")
+		if test.ok && err != nil {
+			t.Errorf("ValidateEnvName(%#v) = %v, wanted no error", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ValidateEnvName(%#v) succeeded, wanted an error", test.name)
+		}
+	}
+}
@@ -6,4 +6,11 @@ chown -R rethinkdb:rethinkdb /data/rethinkdb_data
 
 RDB_CACHE_SIZE=${RDB_CACHE_SIZE:-128}
 
-exec su -c /bin/sh rethinkdb -c '/rethinkdb --bind all -d /data/rethinkdb_data --cache-size '"$RDB_CACHE_SIZE"
+# Every replica joins through the project's service, so a restarted pod
+# finds its peers again even though its IP has changed.
+join_args=
+if [[ -n "${RDB_JOIN-}" ]]; then
+    join_args="--join $RDB_JOIN"
+fi
+
+exec su -c /bin/sh rethinkdb -c '/rethinkdb --bind all -d /data/rethinkdb_data --cache-size '"$RDB_CACHE_SIZE $join_args"
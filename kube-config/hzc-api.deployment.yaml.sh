@@ -20,21 +20,31 @@ metadata:
   name: $name
   namespace: $DEPLOY
 spec:
-  replicas: 1
+  replicas: 2
   strategy:
-    type: Recreate
+    type: RollingUpdate
+    rollingUpdate:
+      maxUnavailable: 1
+      maxSurge: 0
   template:
     metadata:
       labels:
         app: $name
     spec:
+      # Longer than hzc-api's shutdown_timeout, so that it can finish
+      # applying project configs before it's killed.
+      terminationGracePeriodSeconds: 300
       volumes:
       - name: disable-api-access
         emptyDir: {}
       - name: api-shared-secret
         secret: { secretName: "api-shared-secret" }
+      - name: api-http-secret
+        secret: { secretName: "api-http-secret" }
       - name: token-secret
         secret: { secretName: "token-secret" }
+      - name: env-key
+        secret: { secretName: "env-key" }
       - name: names
         secret: { secretName: "names" }
       - name: gcloud-service-account
@@ -55,8 +65,12 @@ spec:
           value: ":8000"
         - name: HZC_SHARED_SECRET
           value: /secrets/api-shared-secret/api-shared-secret
+        - name: HZC_HTTP_SECRET
+          value: /secrets/api-http-secret/api-http-secret
         - name: HZC_TOKEN_SECRET
           value: /secrets/token-secret/token-secret
+        - name: HZC_ENV_KEY
+          value: /secrets/env-key/env-key
         - name: HZC_TEMPLATE_PATH
           value: /templates/
         - name: HZC_STORAGE_BUCKET_FILE
@@ -74,8 +88,12 @@ spec:
           mountPath: /var/run/secrets/kubernetes.io/serviceaccount
         - name: api-shared-secret
           mountPath: /secrets/api-shared-secret
+        - name: api-http-secret
+          mountPath: /secrets/api-http-secret
         - name: token-secret
           mountPath: /secrets/token-secret
+        - name: env-key
+          mountPath: /secrets/env-key
         - name: names
           mountPath: /secrets/names
         - name: gcloud-service-account
@@ -39,6 +39,8 @@ spec:
       volumes:
       - name: disable-api-access
         emptyDir: {}
+      - name: api-http-secret
+        secret: { secretName: "api-http-secret" }
 
       containers:
       - name: proxy
@@ -51,11 +53,20 @@ spec:
         env:
         - name: API_SERVER
           value: "https://$api_host"
+        - name: HTTP_SECRET
+          value: /secrets/api-http-secret/api-http-secret
+        - name: TLS_LISTEN
+          value: ":8443"
         volumeMounts:
         - name: disable-api-access
           mountPath: /var/run/secrets/kubernetes.io/serviceaccount
+        - name: api-http-secret
+          mountPath: /secrets/api-http-secret
         ports:
         - containerPort: 8000
           name: http
           protocol: TCP
+        - containerPort: 8443
+          name: https
+          protocol: TCP
 EOF